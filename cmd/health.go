@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Hymiside/hezzl-api/pkg/breaker"
+	"github.com/Hymiside/hezzl-api/pkg/health"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/queue"
+	"github.com/Hymiside/hezzl-api/pkg/repository/clickhouse"
+	"github.com/Hymiside/hezzl-api/pkg/repository/postgres"
+	"github.com/Hymiside/hezzl-api/pkg/repository/redis"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	goredis "github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// dependencies are the backends the readiness checks reach. The replicas, the
+// queue and the breakers are left out where they don't exist.
+type dependencies struct {
+	postgres   *pgxpool.Pool
+	replicas   *postgres.Replicas
+	redis      *goredis.Client
+	clickhouse *sql.DB
+	nats       *nats.Conn
+	queue      *queue.Queue
+
+	redisBreaker      *breaker.Breaker
+	clickhouseBreaker *breaker.Breaker
+	natsBreaker       *breaker.Breaker
+}
+
+// checks lists the checks of the dependencies. Only Postgres is critical, the
+// service runs degraded without the others.
+func (d dependencies) checks(cfg models.ConfigHealth) []health.Check {
+	checks := []health.Check{
+		{Name: "postgres", Critical: true, Check: d.postgres.Ping},
+		{Name: "redis", Check: func(ctx context.Context) error {
+			return d.redis.Ping(ctx).Err()
+		}, State: stateOf(d.redisBreaker)},
+		{Name: "clickhouse", Check: d.clickhouse.PingContext, State: stateOf(d.clickhouseBreaker)},
+		{Name: "nats", Check: d.nats.FlushWithContext, State: stateOf(d.natsBreaker)},
+	}
+
+	if d.replicas != nil {
+		// Reads go to the primary while no replica is in rotation.
+		checks = append(checks, health.Check{Name: "postgres-replicas", Check: func(context.Context) error {
+			if inRotation, total := d.replicas.InRotation(); inRotation == 0 {
+				return fmt.Errorf("none of the %d replicas is in rotation", total)
+			}
+			return nil
+		}})
+	}
+
+	if d.queue != nil {
+		checks = append(checks, health.Check{Name: "log-batcher", Check: func(context.Context) error {
+			if backlog := d.queue.Backlog(); backlog > cfg.MaxLogBacklog {
+				return fmt.Errorf("%d logs are waiting for clickhouse, over %d", backlog, cfg.MaxLogBacklog)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+func stateOf(b *breaker.Breaker) func() string {
+	if b == nil {
+		return nil
+	}
+	return b.State
+}
+
+// check runs the readiness checks once and prints their result. It fails when
+// the service would be unavailable, or degraded with -strict.
+//
+//	hezzl-api check [-critical] [-strict] [-timeout 2s]
+//
+// The log batcher lives in the running instances and is not checked.
+func check(ctx context.Context, args []string) error {
+	cfg := configHealth()
+
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	critical := fs.Bool("critical", false, "only check the critical dependencies")
+	strict := fs.Bool("strict", false, "fail when an optional dependency fails too")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "timeout of each check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Nothing is waited for here, a dependency that can't be reached fails its check.
+	dbPostgres, err := postgres.OpenPostgresDB(ctx, configPostgres())
+	if err != nil {
+		return fmt.Errorf("error to open postgres: %v", err)
+	}
+	dbClickhouse, err := clickhouse.OpenClickhouseDB(ctx, configClickhouse())
+	if err != nil {
+		return fmt.Errorf("error to open clickhouse: %v", err)
+	}
+	nc, err := queue.NewNats(ctx, models.ConfigNats{
+		Host: os.Getenv("NATS_HOST"),
+		Port: os.Getenv("NATS_PORT"),
+	})
+	if err != nil {
+		return fmt.Errorf("error to open nats: %v", err)
+	}
+
+	deps := dependencies{
+		postgres: dbPostgres,
+		redis: redis.OpenRedisDB(models.ConfigRedis{
+			Host: os.Getenv("REDIS_HOST"),
+			Port: os.Getenv("REDIS_PORT"),
+		}),
+		clickhouse: dbClickhouse,
+		nats:       nc,
+	}
+	if replicasCfg := configReplicas(); len(replicasCfg.Hosts) > 0 {
+		if deps.replicas, err = postgres.NewReplicas(ctx, configPostgres(), replicasCfg); err != nil {
+			return fmt.Errorf("error to open postgres replicas: %v", err)
+		}
+	}
+
+	checks := deps.checks(cfg)
+	if *critical {
+		var criticalChecks []health.Check
+		for _, c := range checks {
+			if c.Critical {
+				criticalChecks = append(criticalChecks, c)
+			}
+		}
+		checks = criticalChecks
+	}
+
+	readiness := health.New(cfg, checks...).Ready(ctx)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(readiness); err != nil {
+		return fmt.Errorf("error to encode readiness: %v", err)
+	}
+
+	switch {
+	case readiness.Status == health.StatusUnavailable:
+		return fmt.Errorf("service is %s", readiness.Status)
+	case readiness.Status == health.StatusDegraded && *strict:
+		return fmt.Errorf("service is %s", readiness.Status)
+	case readiness.Status == health.StatusDegraded:
+		log.Warnf("service is %s", readiness.Status)
+	}
+	return nil
+}
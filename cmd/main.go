@@ -8,17 +8,35 @@ import (
 	"path"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/Hymiside/hezzl-api/pkg/auth"
+	"github.com/Hymiside/hezzl-api/pkg/breaker"
+	"github.com/Hymiside/hezzl-api/pkg/cdc"
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/graphqlapi"
+	"github.com/Hymiside/hezzl-api/pkg/grpcapi"
 	"github.com/Hymiside/hezzl-api/pkg/handler"
+	"github.com/Hymiside/hezzl-api/pkg/health"
+	"github.com/Hymiside/hezzl-api/pkg/metrics"
 	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/openapi"
 	"github.com/Hymiside/hezzl-api/pkg/queue"
 	"github.com/Hymiside/hezzl-api/pkg/repository/clickhouse"
 	"github.com/Hymiside/hezzl-api/pkg/repository/postgres"
 	"github.com/Hymiside/hezzl-api/pkg/repository/redis"
 	"github.com/Hymiside/hezzl-api/pkg/server"
 	"github.com/Hymiside/hezzl-api/pkg/service"
+	"github.com/Hymiside/hezzl-api/pkg/stream"
+	"github.com/Hymiside/hezzl-api/pkg/webhook"
+	trmpgx "github.com/avito-tech/go-transaction-manager/drivers/pgxv5/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -41,33 +59,61 @@ func main() {
 		log.Panicf("error to load .env file: %v", err)
 	}
 
-	dbPostgres, err := postgres.NewPostgresDB(ctx, models.ConfigPostgres{
-		User:     os.Getenv("POSTGRES_USER"),
-		Password: os.Getenv("POSTGRES_PASSWORD"),
-		Host:     os.Getenv("POSTGRES_HOST"),
-		Port:     os.Getenv("POSTGRES_PORT"),
-		Database: os.Getenv("POSTGRES_DB"),
-	})
-	if err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "rebuild" {
+		if err := rebuild(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("error to rebuild goods: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		if err := check(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("error to check dependencies: %v", err)
+		}
+		return
+	}
+
+	var dbPostgres *pgxpool.Pool
+	// The pool lives as long as ctx, not as the attempt: a failed attempt is
+	// bounded by the connect timeout instead.
+	if err := waitFor(ctx, "postgres", func(context.Context) (err error) {
+		dbPostgres, err = postgres.NewPostgresDB(ctx, configPostgres())
+		return err
+	}); err != nil {
 		log.Fatalf("error to connect postgres: %v", err)
 	}
 
-	dbClickhouse, err := clickhouse.NewClickhouseDB(ctx, models.ConfigClickhouse{
-		Host:     os.Getenv("CLICKHOUSE_HOST"),
-		Port:     os.Getenv("CLICKHOUSE_PORT"),
-		Database: os.Getenv("CLICKHOUSE_DATABASE"),
+	// Redis, ClickHouse and NATS are optional: the service starts without them
+	// and their breakers report the degraded mode until they are reachable.
+	breakerCfg := configBreaker()
+	redisBreaker := breaker.New("redis", models.ConfigBreaker{
+		Threshold:   breakerCfg.Threshold,
+		OpenTimeout: breakerCfg.OpenTimeout,
+		CallTimeout: breakerCfg.CallTimeout,
 	})
+	clickhouseBreaker := breaker.New("clickhouse", breakerCfg, custerrors.ErrNotFound)
+	natsBreaker := breaker.New("nats", breakerCfg)
+
+	clickhouseCfg := configClickhouse()
+	dbClickhouse, err := clickhouse.OpenClickhouseDB(ctx, clickhouseCfg)
 	if err != nil {
 		log.Fatalf("error to connect clickhouse: %v", err)
 	}
+	if err = waitFor(ctx, "clickhouse", func(ctx context.Context) error {
+		return dbClickhouse.PingContext(ctx)
+	}); err != nil {
+		log.Warnf("clickhouse is unavailable, starting without it: %v", err)
+	}
 
-	rdb, err := redis.NewRedisDB(ctx, models.ConfigRedis{
+	rdb := redis.OpenRedisDB(models.ConfigRedis{
 		Host: os.Getenv("REDIS_HOST"),
 		Port: os.Getenv("REDIS_PORT"),
 	})
-	if err != nil {
-		log.Fatalf("error to connect redis: %v", err)
+	if err = waitFor(ctx, "redis", func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	}); err != nil {
+		log.Warnf("redis is unavailable, starting without it: %v", err)
 	}
+	rdb.AddHook(redis.NewBreakerHook(redisBreaker))
 
 	qu, err := queue.NewNats(ctx, models.ConfigNats{
 		Host: os.Getenv("NATS_HOST"),
@@ -76,18 +122,155 @@ func main() {
 	if err != nil {
 		log.Fatalf("error to connect nats: %v", err)
 	}
+	if err = waitFor(ctx, "nats", func(ctx context.Context) error {
+		if !qu.IsConnected() {
+			return fmt.Errorf("not connected")
+		}
+		return nil
+	}); err != nil {
+		log.Warnf("nats is unavailable, starting without it: %v", err)
+	}
 
 	numOfLogs, err := strconv.Atoi(os.Getenv("NUM_OF_LOGS"))
 	if err != nil {
 		numOfLogs = 25
 	}
 
-	repoPostgres := postgres.NewRepositoryPostgres(dbPostgres)
-	repoClickhouse := clickhouse.NewRepositoryClickhouse(dbClickhouse)
-	repoRedis := redis.NewRepositoryRedis(rdb)
-	quNats := queue.NewQueue(qu, repoClickhouse, numOfLogs)
-	services := service.NewService(repoPostgres, repoRedis, quNats)
-	handlers := handler.NewHandler(services)
+	redisTTL, err := time.ParseDuration(os.Getenv("REDIS_TTL"))
+	if err != nil {
+		redisTTL = time.Minute
+	}
+
+	trManager := manager.Must(trmpgx.NewDefaultFactory(dbPostgres))
+	replicasCfg := configReplicas()
+	var replicas *postgres.Replicas
+	if len(replicasCfg.Hosts) > 0 {
+		if replicas, err = postgres.NewReplicas(ctx, configPostgres(), replicasCfg); err != nil {
+			log.Fatalf("error to connect postgres replicas: %v", err)
+		}
+	}
+	pools := map[string]*pgxpool.Pool{"primary": dbPostgres}
+	if replicas != nil {
+		for addr, pool := range replicas.Pools() {
+			pools[addr] = pool
+		}
+	}
+	prometheus.MustRegister(
+		metrics.NewPoolCollector(pools),
+		collectors.NewDBStatsCollector(dbClickhouse, "clickhouse"),
+	)
+
+	repoPostgres := postgres.NewRepositoryPostgres(dbPostgres, trManager, replicas)
+	repoClickhouse := clickhouse.NewRepositoryClickhouse(dbClickhouse, clickhouseBreaker, clickhouseCfg.AnalyticsTimeout)
+	repoRedis := redis.NewRepositoryRedis(rdb, redisTTL)
+	quNats := queue.NewQueue(qu, repoClickhouse, numOfLogs, natsBreaker)
+
+	cdcEnabled, _ := strconv.ParseBool(os.Getenv("CDC_ENABLED"))
+	if cdcEnabled {
+		worker := cdc.NewCDC(configPostgres(), models.ConfigCDC{
+			Enabled:     cdcEnabled,
+			Slot:        getenv("CDC_SLOT", "hezzl_cdc"),
+			Publication: getenv("CDC_PUBLICATION", "hezzl_cdc"),
+		}, quNats)
+		go func() {
+			for {
+				if err := worker.Run(ctx); err != nil {
+					log.Errorf("error to run cdc: %v", err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}()
+	}
+
+	var (
+		services *service.Service
+		reset    = repoRedis.Reset
+	)
+	if localCache := configLocalCache(); localCache.Enabled {
+		repoLocal, err := redis.NewLocalRepository(repoRedis, quNats, localCache.Size, localCache.TTL)
+		if err != nil {
+			log.Fatalf("error to create local cache: %v", err)
+		}
+		go repoLocal.ReportStats(ctx, time.Minute)
+		reset = repoLocal.Reset
+		services = service.NewService(repoPostgres, repoLocal, repoClickhouse, quNats, trManager, !cdcEnabled)
+	} else {
+		services = service.NewService(repoPostgres, repoRedis, repoClickhouse, quNats, trManager, !cdcEnabled)
+	}
+
+	// Writes made while Redis was unreachable were not applied to the cache,
+	// so it is dropped as soon as Redis is back.
+	redisBreaker.OnChange(func(from, to string) {
+		log.Warnf("redis breaker %s -> %s", from, to)
+		if to != breaker.StateClosed {
+			return
+		}
+		if err := reset(ctx); err != nil {
+			log.Errorf("error to reset goods cache: %v", err)
+		}
+	})
+	for _, b := range []*breaker.Breaker{clickhouseBreaker, natsBreaker} {
+		name := b.Name()
+		b.OnChange(func(from, to string) {
+			log.Warnf("%s breaker %s -> %s", name, from, to)
+		})
+	}
+
+	reconcileInterval, err := time.ParseDuration(os.Getenv("COUNTERS_RECONCILE_INTERVAL"))
+	if err != nil {
+		reconcileInterval = 10 * time.Minute
+	}
+	go services.RunReconciler(ctx, reconcileInterval)
+	if !cdcEnabled {
+		relayInterval, err := time.ParseDuration(os.Getenv("OUTBOX_RELAY_INTERVAL"))
+		if err != nil || relayInterval <= 0 {
+			relayInterval = 200 * time.Millisecond
+		}
+		go services.RunOutboxRelay(ctx, relayInterval)
+	}
+	go services.RunJobs(ctx, configJobs())
+
+	dispatcher := webhook.NewDispatcher(repoPostgres, quNats, nil, configWebhooks())
+	go func() {
+		if err := dispatcher.Run(ctx); err != nil {
+			log.Errorf("error to run webhooks: %v", err)
+		}
+	}()
+
+	hub := stream.NewHub(configStream())
+	if err = quNats.SubscribeEvents("", hub.Publish); err != nil {
+		log.Fatalf("error to subscribe goods stream: %v", err)
+	}
+
+	tokens := auth.New(strings.Split(os.Getenv("API_TOKENS"), ","))
+	validateResponses, _ := strconv.ParseBool(os.Getenv("OPENAPI_VALIDATE_RESPONSES"))
+	spec, err := openapi.New(validateResponses)
+	if err != nil {
+		log.Fatalf("error to load openapi document: %v", err)
+	}
+
+	healthCfg := configHealth()
+	checker := health.New(healthCfg, dependencies{
+		postgres:          dbPostgres,
+		replicas:          replicas,
+		redis:             rdb,
+		clickhouse:        dbClickhouse,
+		nats:              qu,
+		queue:             quNats,
+		redisBreaker:      redisBreaker,
+		clickhouseBreaker: clickhouseBreaker,
+		natsBreaker:       natsBreaker,
+	}.checks(healthCfg)...)
+
+	handlers := handler.NewHandler(services, hub, graphqlapi.NewSchema(services, hub), spec, tokens, replicasCfg.MaxLag, configDeprecations(), checker)
+	routes := handlers.NewRoutes()
+	if err = spec.CheckRoutes(routes); err != nil {
+		log.Fatalf("error to check routes: %v", err)
+	}
 
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -100,10 +283,245 @@ func main() {
 		}
 	}()
 
-	if err = server.StartServer(ctx, handlers.NewRoutes(), models.ConfigServer{
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "3001"
+	}
+	go func() {
+		if err := grpcapi.StartServer(ctx, grpcapi.NewServer(services, hub, tokens), models.ConfigServer{
+			Host: os.Getenv("SERVER_HOST"),
+			Port: grpcPort,
+		}); err != nil {
+			log.Fatalf("error to start grpc server: %v", err)
+		}
+	}()
+
+	if err = server.StartServer(ctx, routes, models.ConfigServer{
 		Host: os.Getenv("SERVER_HOST"),
 		Port: os.Getenv("SERVER_PORT"),
 	}); err != nil {
 		log.Fatalf("error to start server: %v", err)
 	}
 }
+
+func configPostgres() models.ConfigPostgres {
+	cfg := models.ConfigPostgres{
+		User:                   os.Getenv("POSTGRES_USER"),
+		Password:               os.Getenv("POSTGRES_PASSWORD"),
+		Host:                   os.Getenv("POSTGRES_HOST"),
+		Port:                   os.Getenv("POSTGRES_PORT"),
+		Database:               os.Getenv("POSTGRES_DB"),
+		MaxConns:               10,
+		MaxConnIdleTime:        5 * time.Minute,
+		MaxConnLifetime:        time.Hour,
+		QueryTimeout:           5 * time.Second,
+		StatementCacheCapacity: 128,
+	}
+	if maxConns, err := strconv.Atoi(os.Getenv("POSTGRES_MAX_CONNS")); err == nil {
+		cfg.MaxConns = int32(maxConns)
+	}
+	if minConns, err := strconv.Atoi(os.Getenv("POSTGRES_MIN_CONNS")); err == nil {
+		cfg.MinConns = int32(minConns)
+	}
+	if idle, err := time.ParseDuration(os.Getenv("POSTGRES_MAX_CONN_IDLE_TIME")); err == nil {
+		cfg.MaxConnIdleTime = idle
+	}
+	if lifetime, err := time.ParseDuration(os.Getenv("POSTGRES_MAX_CONN_LIFETIME")); err == nil {
+		cfg.MaxConnLifetime = lifetime
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("POSTGRES_QUERY_TIMEOUT")); err == nil {
+		cfg.QueryTimeout = timeout
+	}
+	if capacity, err := strconv.Atoi(os.Getenv("POSTGRES_STATEMENT_CACHE")); err == nil {
+		cfg.StatementCacheCapacity = capacity
+	}
+	return cfg
+}
+
+func configReplicas() models.ConfigReplicas {
+	cfg := models.ConfigReplicas{MaxLag: 5 * time.Second, CheckInterval: 2 * time.Second}
+	for _, host := range strings.Split(os.Getenv("POSTGRES_REPLICAS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.Hosts = append(cfg.Hosts, host)
+		}
+	}
+	if lag, err := time.ParseDuration(os.Getenv("POSTGRES_REPLICA_MAX_LAG")); err == nil {
+		cfg.MaxLag = lag
+	}
+	if interval, err := time.ParseDuration(os.Getenv("POSTGRES_REPLICA_CHECK_INTERVAL")); err == nil {
+		cfg.CheckInterval = interval
+	}
+	return cfg
+}
+
+func configClickhouse() models.ConfigClickhouse {
+	cfg := models.ConfigClickhouse{
+		Host:             os.Getenv("CLICKHOUSE_HOST"),
+		Port:             os.Getenv("CLICKHOUSE_PORT"),
+		Database:         os.Getenv("CLICKHOUSE_DATABASE"),
+		AnalyticsTimeout: 30 * time.Second,
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("CLICKHOUSE_ANALYTICS_TIMEOUT")); err == nil {
+		cfg.AnalyticsTimeout = timeout
+	}
+	return cfg
+}
+
+func configBreaker() models.ConfigBreaker {
+	cfg := models.ConfigBreaker{
+		Threshold:   5,
+		Retries:     2,
+		OpenTimeout: 10 * time.Second,
+		CallTimeout: time.Second,
+		Backoff:     100 * time.Millisecond,
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("BREAKER_THRESHOLD")); err == nil {
+		cfg.Threshold = threshold
+	}
+	if retries, err := strconv.Atoi(os.Getenv("BREAKER_RETRIES")); err == nil {
+		cfg.Retries = retries
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("BREAKER_OPEN_TIMEOUT")); err == nil {
+		cfg.OpenTimeout = timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("BREAKER_CALL_TIMEOUT")); err == nil {
+		cfg.CallTimeout = timeout
+	}
+	if backoff, err := time.ParseDuration(os.Getenv("BREAKER_BACKOFF")); err == nil {
+		cfg.Backoff = backoff
+	}
+	return cfg
+}
+
+// waitFor retries connect with exponential backoff until it succeeds or the
+// STARTUP_ATTEMPTS are used up.
+func waitFor(ctx context.Context, name string, connect func(ctx context.Context) error) error {
+	attempts, err := strconv.Atoi(os.Getenv("STARTUP_ATTEMPTS"))
+	if err != nil || attempts < 1 {
+		attempts = 5
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = connect(attemptCtx)
+		cancel()
+		if err == nil || attempt == attempts {
+			return err
+		}
+
+		log.Warnf("%s is not ready (attempt %d/%d): %v", name, attempt, attempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func configJobs() models.ConfigJobs {
+	cfg := models.ConfigJobs{Workers: 2, PollInterval: time.Second, StaleAfter: 30 * time.Second}
+	if workers, err := strconv.Atoi(os.Getenv("JOBS_WORKERS")); err == nil {
+		cfg.Workers = workers
+	}
+	if interval, err := time.ParseDuration(os.Getenv("JOBS_POLL_INTERVAL")); err == nil && interval > 0 {
+		cfg.PollInterval = interval
+	}
+	if staleAfter, err := time.ParseDuration(os.Getenv("JOBS_STALE_AFTER")); err == nil && staleAfter > 0 {
+		cfg.StaleAfter = staleAfter
+	}
+	return cfg
+}
+
+func configWebhooks() models.ConfigWebhooks {
+	cfg := models.ConfigWebhooks{
+		Workers:      2,
+		PollInterval: time.Second,
+		Timeout:      10 * time.Second,
+		MaxAttempts:  8,
+		Backoff:      10 * time.Second,
+		MaxBackoff:   time.Hour,
+	}
+	if workers, err := strconv.Atoi(os.Getenv("WEBHOOKS_WORKERS")); err == nil {
+		cfg.Workers = workers
+	}
+	if interval, err := time.ParseDuration(os.Getenv("WEBHOOKS_POLL_INTERVAL")); err == nil && interval > 0 {
+		cfg.PollInterval = interval
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("WEBHOOKS_TIMEOUT")); err == nil && timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("WEBHOOKS_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		cfg.MaxAttempts = attempts
+	}
+	if backoff, err := time.ParseDuration(os.Getenv("WEBHOOKS_BACKOFF")); err == nil && backoff > 0 {
+		cfg.Backoff = backoff
+	}
+	if maxBackoff, err := time.ParseDuration(os.Getenv("WEBHOOKS_MAX_BACKOFF")); err == nil && maxBackoff > 0 {
+		cfg.MaxBackoff = maxBackoff
+	}
+	cfg.AllowPrivate, _ = strconv.ParseBool(os.Getenv("WEBHOOKS_ALLOW_PRIVATE"))
+	return cfg
+}
+
+func configHealth() models.ConfigHealth {
+	cfg := models.ConfigHealth{Timeout: 2 * time.Second, CacheFor: time.Second, MaxLogBacklog: 1000}
+	if timeout, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_TIMEOUT")); err == nil && timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	if cacheFor, err := time.ParseDuration(os.Getenv("HEALTH_CACHE_FOR")); err == nil && cacheFor >= 0 {
+		cfg.CacheFor = cacheFor
+	}
+	if backlog, err := strconv.Atoi(os.Getenv("HEALTH_MAX_LOG_BACKLOG")); err == nil && backlog > 0 {
+		cfg.MaxLogBacklog = backlog
+	}
+	return cfg
+}
+
+func configStream() models.ConfigStream {
+	cfg := models.ConfigStream{History: 1024, Buffer: 64}
+	if history, err := strconv.Atoi(os.Getenv("STREAM_HISTORY")); err == nil && history >= 0 {
+		cfg.History = history
+	}
+	if buffer, err := strconv.Atoi(os.Getenv("STREAM_BUFFER")); err == nil && buffer > 0 {
+		cfg.Buffer = buffer
+	}
+	return cfg
+}
+
+func configLocalCache() models.ConfigLocalCache {
+	cfg := models.ConfigLocalCache{Size: 1024, TTL: 5 * time.Second}
+	cfg.Enabled, _ = strconv.ParseBool(os.Getenv("LOCAL_CACHE_ENABLED"))
+	if size, err := strconv.Atoi(os.Getenv("LOCAL_CACHE_SIZE")); err == nil {
+		cfg.Size = size
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("LOCAL_CACHE_TTL")); err == nil {
+		cfg.TTL = ttl
+	}
+	return cfg
+}
+
+// configDeprecations reads when each API version was deprecated and when it
+// goes away, "" being the unversioned routes. A version without a
+// deprecation date is not deprecated.
+func configDeprecations() map[string]models.ConfigDeprecation {
+	deprecations := map[string]models.ConfigDeprecation{}
+	for version, prefix := range map[string]string{"": "API_UNVERSIONED", "v1": "API_V1"} {
+		since, err := time.Parse(time.RFC3339, os.Getenv(prefix+"_DEPRECATED_AT"))
+		if err != nil {
+			continue
+		}
+		deprecation := models.ConfigDeprecation{Since: since}
+		deprecation.Sunset, _ = time.Parse(time.RFC3339, os.Getenv(prefix+"_SUNSET"))
+		deprecations[version] = deprecation
+	}
+	return deprecations
+}
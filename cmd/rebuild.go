@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/repository/clickhouse"
+	"github.com/Hymiside/hezzl-api/pkg/repository/postgres"
+	"github.com/Hymiside/hezzl-api/pkg/repository/redis"
+	"github.com/Hymiside/hezzl-api/pkg/service"
+	trmpgx "github.com/avito-tech/go-transaction-manager/drivers/pgxv5/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	log "github.com/sirupsen/logrus"
+)
+
+// rebuild replays the ClickHouse logs into the Postgres projects and goods
+// tables and drops the goods cached in Redis. Goods that can't be restored are
+// listed as skipped.
+//
+//	hezzl-api rebuild [-dry-run] [-prune] [-as-of 2024-03-01T12:00:00Z]
+func rebuild(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the diff between the log and the goods table")
+	prune := fs.Bool("prune", false, "delete goods that are not present in the log")
+	asOf := fs.String("as-of", "", "replay events up to this RFC 3339 timestamp instead of now")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	asOfTime := time.Now().UTC()
+	if *asOf != "" {
+		t, err := time.Parse(time.RFC3339, *asOf)
+		if err != nil {
+			return fmt.Errorf("as-of must be an RFC 3339 timestamp: %v", err)
+		}
+		asOfTime = t.UTC()
+	}
+
+	dbPostgres, err := postgres.NewPostgresDB(ctx, configPostgres())
+	if err != nil {
+		return fmt.Errorf("error to connect postgres: %v", err)
+	}
+
+	dbClickhouse, err := clickhouse.NewClickhouseDB(ctx, configClickhouse())
+	if err != nil {
+		return fmt.Errorf("error to connect clickhouse: %v", err)
+	}
+
+	rdb, err := redis.NewRedisDB(ctx, models.ConfigRedis{
+		Host: os.Getenv("REDIS_HOST"),
+		Port: os.Getenv("REDIS_PORT"),
+	})
+	if err != nil {
+		return fmt.Errorf("error to connect redis: %v", err)
+	}
+
+	log.Warn("events still buffered by running instances are not in the log yet and will not be replayed")
+
+	rebuilder := service.NewRebuilder(
+		postgres.NewRepositoryPostgres(dbPostgres, manager.Must(trmpgx.NewDefaultFactory(dbPostgres)), nil),
+		clickhouse.NewRepositoryClickhouse(dbClickhouse, nil, 0),
+		redis.NewRepositoryRedis(rdb, 0),
+	)
+	report, err := rebuilder.Rebuild(ctx, asOfTime, *dryRun, *prune)
+	if err != nil {
+		return err
+	}
+
+	log.Infof(
+		"rebuilt %d projects and %d goods as of %s: %d missing, %d extra, %d changed, %d skipped (dry run: %t)",
+		report.Projects, report.Goods, report.AsOf.Format(time.RFC3339),
+		report.Missing, report.Extra, report.Changed, len(report.Skipped), report.DryRun,
+	)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
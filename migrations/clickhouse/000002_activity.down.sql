@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS logs_edits_daily_mv;
+DROP TABLE IF EXISTS logs_edits_daily;
+DROP VIEW IF EXISTS logs_activity_hourly_mv;
+DROP TABLE IF EXISTS logs_activity_hourly;
+ALTER TABLE logs DROP COLUMN IF EXISTS event;
@@ -0,0 +1,61 @@
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS event LowCardinality(String) DEFAULT '' AFTER id;
+
+CREATE TABLE logs_activity_hourly(
+    project_id INT NOT NULL,
+    event LowCardinality(String) NOT NULL,
+    bucket DATETIME NOT NULL,
+    events UInt64 NOT NULL
+)
+ENGINE = SummingMergeTree(events)
+ORDER BY (project_id, event, bucket);
+
+-- The views only see the logs inserted after them, the logs already there are
+-- copied below, those from before the event column under an empty event.
+-- Logs written while this migration runs may be counted twice, so run it while
+-- the log batchers are stopped.
+CREATE MATERIALIZED VIEW logs_activity_hourly_mv TO logs_activity_hourly AS
+SELECT
+    project_id,
+    event,
+    toStartOfHour(created_at) AS bucket,
+    count() AS events
+FROM logs
+GROUP BY project_id, event, bucket;
+
+CREATE TABLE logs_edits_daily(
+    project_id INT NOT NULL,
+    id INT NOT NULL,
+    day DATE NOT NULL,
+    edits UInt64 NOT NULL
+)
+ENGINE = SummingMergeTree(edits)
+ORDER BY (project_id, day, id);
+
+CREATE MATERIALIZED VIEW logs_edits_daily_mv TO logs_edits_daily AS
+SELECT
+    project_id,
+    id,
+    toDate(created_at) AS day,
+    count() AS edits
+FROM logs
+WHERE event = 'updated'
+GROUP BY project_id, id, day;
+
+INSERT INTO logs_activity_hourly
+SELECT
+    project_id,
+    event,
+    toStartOfHour(created_at) AS bucket,
+    count() AS events
+FROM logs
+GROUP BY project_id, event, bucket;
+
+INSERT INTO logs_edits_daily
+SELECT
+    project_id,
+    id,
+    toDate(created_at) AS day,
+    count() AS edits
+FROM logs
+WHERE event = 'updated'
+GROUP BY project_id, id, day;
\ No newline at end of file
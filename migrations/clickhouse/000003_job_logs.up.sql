@@ -0,0 +1,12 @@
+CREATE TABLE job_logs(
+    id Int64 NOT NULL,
+    event LowCardinality(String) NOT NULL,
+    kind LowCardinality(String) NOT NULL,
+    project_id INT NOT NULL,
+    progress INT NOT NULL,
+    total INT NOT NULL,
+    error String,
+    created_at DATETIME DEFAULT now()
+)
+ENGINE = MergeTree()
+ORDER BY (id, created_at);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE logs DROP COLUMN IF EXISTS version;
+ALTER TABLE logs DROP COLUMN IF EXISTS occurred_at;
\ No newline at end of file
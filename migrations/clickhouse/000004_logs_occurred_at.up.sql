@@ -0,0 +1,4 @@
+-- created_at is set when a batch is written, so the logs already there keep it
+-- as the best known time of their change.
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS occurred_at DateTime64(6) DEFAULT created_at;
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS version Int64 DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS project_logs;
\ No newline at end of file
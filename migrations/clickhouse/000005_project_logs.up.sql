@@ -0,0 +1,12 @@
+CREATE TABLE project_logs(
+    id INT NOT NULL,
+    event LowCardinality(String) NOT NULL,
+    name String NOT NULL,
+    -- project_created_at is when the project was created, occurred_at when it
+    -- changed and created_at when the change was written here.
+    project_created_at DATETIME NOT NULL,
+    occurred_at DateTime64(6) NOT NULL,
+    created_at DATETIME DEFAULT now()
+)
+ENGINE = MergeTree()
+ORDER BY (id, occurred_at);
\ No newline at end of file
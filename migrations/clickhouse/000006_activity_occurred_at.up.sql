@@ -0,0 +1,45 @@
+-- created_at is the time the batch was written, the views bucket on the time
+-- of the change instead. They are refilled from the logs, so as for 000002 run
+-- this while the log batchers are stopped.
+DROP VIEW IF EXISTS logs_activity_hourly_mv;
+DROP VIEW IF EXISTS logs_edits_daily_mv;
+TRUNCATE TABLE IF EXISTS logs_activity_hourly;
+TRUNCATE TABLE IF EXISTS logs_edits_daily;
+
+CREATE MATERIALIZED VIEW logs_activity_hourly_mv TO logs_activity_hourly AS
+SELECT
+    project_id,
+    event,
+    toStartOfHour(occurred_at) AS bucket,
+    count() AS events
+FROM logs
+GROUP BY project_id, event, bucket;
+
+CREATE MATERIALIZED VIEW logs_edits_daily_mv TO logs_edits_daily AS
+SELECT
+    project_id,
+    id,
+    toDate(occurred_at) AS day,
+    count() AS edits
+FROM logs
+WHERE event = 'updated'
+GROUP BY project_id, id, day;
+
+INSERT INTO logs_activity_hourly
+SELECT
+    project_id,
+    event,
+    toStartOfHour(occurred_at) AS bucket,
+    count() AS events
+FROM logs
+GROUP BY project_id, event, bucket;
+
+INSERT INTO logs_edits_daily
+SELECT
+    project_id,
+    id,
+    toDate(occurred_at) AS day,
+    count() AS edits
+FROM logs
+WHERE event = 'updated'
+GROUP BY project_id, id, day;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP PUBLICATION IF EXISTS hezzl_cdc;
+ALTER TABLE goods REPLICA IDENTITY DEFAULT;
\ No newline at end of file
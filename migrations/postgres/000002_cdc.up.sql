@@ -0,0 +1,8 @@
+-- Change data capture needs wal_level = logical on the server.
+-- Full replica identity logs the old row of every update and delete of goods.
+-- The key alone is not enough: updates are told apart into removals and
+-- reprioritizations by comparing the old row with the new one, and a deleted
+-- good is published whole. It costs the old row in the WAL of those writes.
+ALTER TABLE goods REPLICA IDENTITY FULL;
+
+CREATE PUBLICATION hezzl_cdc FOR TABLE goods, projects;
\ No newline at end of file
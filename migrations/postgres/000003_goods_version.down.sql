@@ -0,0 +1 @@
+ALTER TABLE goods DROP COLUMN IF EXISTS version;
\ No newline at end of file
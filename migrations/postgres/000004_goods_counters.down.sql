@@ -0,0 +1 @@
+DROP TABLE IF EXISTS goods_counters;
\ No newline at end of file
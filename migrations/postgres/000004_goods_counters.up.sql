@@ -0,0 +1,10 @@
+CREATE TABLE goods_counters (
+    project_id INT PRIMARY KEY REFERENCES projects(id),
+    total INT NOT NULL DEFAULT 0,
+    removed INT NOT NULL DEFAULT 0
+);
+
+INSERT INTO goods_counters (project_id, total, removed)
+SELECT project_id, COUNT(*), COUNT(*) FILTER (WHERE removed)
+FROM goods
+GROUP BY project_id;
\ No newline at end of file
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS goods_search_idx;
+DROP TRIGGER IF EXISTS projects_search_language_update ON projects;
+DROP FUNCTION IF EXISTS projects_search_language_update();
+DROP TRIGGER IF EXISTS goods_search_update ON goods;
+DROP FUNCTION IF EXISTS goods_search_update();
+DROP FUNCTION IF EXISTS goods_search_vector(REGCONFIG, TEXT, TEXT);
+ALTER TABLE goods DROP COLUMN IF EXISTS search;
+ALTER TABLE projects DROP COLUMN IF EXISTS search_language;
\ No newline at end of file
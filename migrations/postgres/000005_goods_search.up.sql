@@ -0,0 +1,45 @@
+ALTER TABLE projects ADD COLUMN search_language REGCONFIG NOT NULL DEFAULT 'simple';
+
+ALTER TABLE goods ADD COLUMN search TSVECTOR;
+
+CREATE FUNCTION goods_search_vector(language REGCONFIG, name TEXT, description TEXT) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector(language, coalesce(name, '')), 'A') ||
+        setweight(to_tsvector(language, coalesce(description, '')), 'B')
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE FUNCTION goods_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search := goods_search_vector(
+        (SELECT search_language FROM projects WHERE id = NEW.project_id),
+        NEW.name,
+        NEW.description
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER goods_search_update
+    BEFORE INSERT OR UPDATE OF project_id, name, description ON goods
+    FOR EACH ROW EXECUTE FUNCTION goods_search_update();
+
+CREATE FUNCTION projects_search_language_update() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE goods
+    SET search = goods_search_vector(NEW.search_language, name, description)
+    WHERE project_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER projects_search_language_update
+    AFTER UPDATE OF search_language ON projects
+    FOR EACH ROW
+    WHEN (OLD.search_language IS DISTINCT FROM NEW.search_language)
+    EXECUTE FUNCTION projects_search_language_update();
+
+UPDATE goods g
+SET search = goods_search_vector(p.search_language, g.name, g.description)
+FROM projects p
+WHERE p.id = g.project_id;
+
+CREATE INDEX goods_search_idx ON goods USING GIN (search);
\ No newline at end of file
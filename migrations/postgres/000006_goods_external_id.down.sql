@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS goods_external_id_idx;
+ALTER TABLE goods DROP COLUMN IF EXISTS external_id;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE goods ADD COLUMN external_id TEXT;
+
+CREATE UNIQUE INDEX goods_external_id_idx ON goods (project_id, external_id) WHERE external_id IS NOT NULL;
\ No newline at end of file
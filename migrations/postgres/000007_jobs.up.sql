@@ -0,0 +1,21 @@
+CREATE TABLE jobs(
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    project_id INT NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    input BYTEA,
+    status TEXT NOT NULL DEFAULT 'queued',
+    progress INT NOT NULL DEFAULT 0,
+    total INT NOT NULL DEFAULT 0,
+    result BYTEA,
+    result_type TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    cancel_requested BOOLEAN NOT NULL DEFAULT false,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    heartbeat_at TIMESTAMP
+);
+
+CREATE INDEX jobs_pending_idx ON jobs (id) WHERE status IN ('queued', 'running');
\ No newline at end of file
@@ -0,0 +1,32 @@
+CREATE TABLE webhooks(
+    id SERIAL PRIMARY KEY,
+    project_id INT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+
+    FOREIGN KEY (project_id) REFERENCES projects(id)
+);
+
+CREATE INDEX webhooks_project_id_idx ON webhooks (project_id);
+
+CREATE TABLE webhook_deliveries(
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id INT NOT NULL,
+    project_id INT NOT NULL,
+    event TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    response_status INT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMP,
+
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_project_id_idx ON webhook_deliveries (project_id, id);
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Goods change events are written here in the transaction of the change and
+-- relayed to NATS once it commits, so a change is never left without its event.
+CREATE TABLE outbox(
+    id BIGSERIAL PRIMARY KEY,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE jobs ADD COLUMN result BYTEA;
+
+DROP TABLE IF EXISTS job_result_chunks;
\ No newline at end of file
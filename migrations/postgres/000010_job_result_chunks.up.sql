@@ -0,0 +1,13 @@
+-- Job results are written in chunks while the job runs, so a large export is
+-- never held in memory. Chunks are kept per attempt: a job claimed again after
+-- its worker died writes its own, and only those of the attempt that finished
+-- are read.
+CREATE TABLE job_result_chunks(
+    job_id BIGINT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    seq INT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (job_id, attempt, seq)
+);
+
+ALTER TABLE jobs DROP COLUMN result;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE goods REPLICA IDENTITY FULL;
\ No newline at end of file
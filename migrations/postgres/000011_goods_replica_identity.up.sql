@@ -0,0 +1,3 @@
+-- Full replica identity is only needed by change data capture, which sets it
+-- when it starts, so writes do not pay for it while CDC is off.
+ALTER TABLE goods REPLICA IDENTITY DEFAULT;
\ No newline at end of file
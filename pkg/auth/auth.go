@@ -0,0 +1,48 @@
+// Package auth checks the bearer tokens shared by the HTTP and gRPC APIs.
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Tokens accepts any of the configured tokens. Without tokens authentication
+// is off and every caller is accepted.
+type Tokens struct {
+	tokens [][]byte
+}
+
+func New(tokens []string) *Tokens {
+	t := &Tokens{}
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			t.tokens = append(t.tokens, []byte(token))
+		}
+	}
+	return t
+}
+
+// Check validates an Authorization value of the form "Bearer <token>".
+func (t *Tokens) Check(authorization string) error {
+	if len(t.tokens) == 0 {
+		return nil
+	}
+
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ErrUnauthorized
+	}
+
+	// Every token is compared, so the time taken tells nothing about them.
+	var match int
+	for _, t := range t.tokens {
+		match |= subtle.ConstantTimeCompare([]byte(token), t)
+	}
+	if match == 0 {
+		return ErrUnauthorized
+	}
+	return nil
+}
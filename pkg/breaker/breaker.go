@@ -0,0 +1,185 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker guards calls to a dependency: each call gets a timeout and a few
+// retries, and after Threshold consecutive failures calls fail fast with
+// ErrOpen until OpenTimeout passes and a trial call succeeds.
+//
+// A nil *Breaker runs calls as is, so it can be left out where it isn't needed.
+type Breaker struct {
+	name   string
+	cfg    models.ConfigBreaker
+	ignore []error
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+	onChange func(from, to string)
+}
+
+// New creates a breaker. Errors listed in ignore (and wrapping them) are
+// returned to the caller but do not count as failures.
+func New(name string, cfg models.ConfigBreaker, ignore ...error) *Breaker {
+	return &Breaker{
+		name:   name,
+		cfg:    cfg,
+		ignore: ignore,
+		state:  StateClosed,
+	}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// OnChange registers a callback for state transitions. It runs in its own
+// goroutine.
+func (b *Breaker) OnChange(fn func(from, to string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onChange = fn
+}
+
+func (b *Breaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if b == nil {
+		return fn(ctx)
+	}
+
+	var err error
+	for attempt := 0; attempt <= b.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(b.cfg.Backoff << (attempt - 1)):
+			}
+		}
+
+		if !b.allow() {
+			return ErrOpen
+		}
+
+		err = b.call(ctx, fn)
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			// The caller gave up, which says nothing of the dependency.
+			b.release()
+			return err
+		}
+		if !b.failed(err) {
+			b.record(true)
+			return err
+		}
+		b.record(false)
+	}
+	return err
+}
+
+func (b *Breaker) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if b.cfg.CallTimeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.CallTimeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+func (b *Breaker) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, ignored := range b.ignore {
+		if errors.Is(err, ignored) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.setState(StateHalfOpen)
+		b.trial = true
+		return true
+	case StateHalfOpen:
+		// Only one trial call at a time while the dependency is recovering.
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// release ends a trial call without a result.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *Breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.setState(StateClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.cfg.Threshold {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+func (b *Breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		go b.onChange(from, state)
+	}
+}
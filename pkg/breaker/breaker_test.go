@@ -0,0 +1,54 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+var testConfig = models.ConfigBreaker{
+	Threshold:   2,
+	OpenTimeout: time.Minute,
+	Retries:     2,
+	Backoff:     time.Millisecond,
+}
+
+func TestFailuresOpenTheBreaker(t *testing.T) {
+	b := New("test", testConfig)
+	calls := 0
+	err := b.Do(context.Background(), func(context.Context) error {
+		calls++
+		return errors.New("down")
+	})
+	if err == nil || calls != testConfig.Threshold {
+		t.Fatalf("Do = %v after %d calls, want the error after %d", err, calls, testConfig.Threshold)
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+	if err = b.Do(context.Background(), func(context.Context) error { return nil }); !errors.Is(err, ErrOpen) {
+		t.Errorf("Do = %v while open, want ErrOpen", err)
+	}
+}
+
+func TestCallerCancellationIsNotAFailure(t *testing.T) {
+	b := New("test", testConfig)
+	for i := 0; i < testConfig.Threshold; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := b.Do(ctx, func(ctx context.Context) error {
+			calls++
+			cancel()
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) || calls != 1 {
+			t.Fatalf("Do = %v after %d calls, want canceled without retries", err, calls)
+		}
+	}
+	if b.State() != StateClosed {
+		t.Errorf("state = %s after canceled calls, want closed", b.State())
+	}
+}
@@ -0,0 +1,342 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	standbyTimeout = 10 * time.Second
+
+	// duplicate_object, returned when the replication slot already exists.
+	codeDuplicateObject = "42710"
+)
+
+// replicaIdentityQuery makes the goods log the old row of every update and
+// delete: updates are told apart by comparing it with the new one, and a
+// deleted good is published whole. The table is only altered when needed, as
+// that locks it.
+const replicaIdentityQuery = `
+	DO $$
+	BEGIN
+		IF (SELECT relreplident FROM pg_class WHERE oid = 'goods'::regclass) <> 'f' THEN
+			ALTER TABLE goods REPLICA IDENTITY FULL;
+		END IF;
+	END
+	$$`
+
+type publisher interface {
+	Publish(b []byte) error
+	PublishProject(b []byte) error
+}
+
+// CDC streams row changes of the goods and projects tables from a pgoutput
+// replication slot and republishes them as the same events the service emits.
+type CDC struct {
+	cfg       models.ConfigCDC
+	pgCfg     models.ConfigPostgres
+	publisher publisher
+
+	relations map[uint32]*pglogrepl.RelationMessage
+	typeMap   *pgtype.Map
+
+	// commitTime is when the transaction being decoded committed: its events
+	// carry it, so they are ordered like the commits.
+	commitTime time.Time
+}
+
+func NewCDC(pgCfg models.ConfigPostgres, cfg models.ConfigCDC, publisher publisher) *CDC {
+	return &CDC{
+		cfg:       cfg,
+		pgCfg:     pgCfg,
+		publisher: publisher,
+		relations: map[uint32]*pglogrepl.RelationMessage{},
+		typeMap:   pgtype.NewMap(),
+	}
+}
+
+func (c *CDC) Run(ctx context.Context) error {
+	conn, err := pgconn.Connect(ctx, fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?sslmode=disable&replication=database",
+		c.pgCfg.User, c.pgCfg.Password, c.pgCfg.Host, c.pgCfg.Port, c.pgCfg.Database,
+	))
+	if err != nil {
+		return fmt.Errorf("error to connect replication: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, replicaIdentityQuery).ReadAll(); err != nil {
+		return fmt.Errorf("error to set replica identity: %v", err)
+	}
+
+	if _, err = pglogrepl.CreateReplicationSlot(ctx, conn, c.cfg.Slot, "pgoutput", pglogrepl.CreateReplicationSlotOptions{}); err != nil {
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != codeDuplicateObject {
+			return fmt.Errorf("error to create replication slot: %v", err)
+		}
+	}
+
+	// Starting from LSN 0 makes the server resume from the slot's confirmed position.
+	if err = pglogrepl.StartReplication(ctx, conn, c.cfg.Slot, 0, pglogrepl.StartReplicationOptions{
+		PluginArgs: []string{
+			"proto_version '1'",
+			fmt.Sprintf("publication_names '%s'", c.cfg.Publication),
+		},
+	}); err != nil {
+		return fmt.Errorf("error to start replication: %v", err)
+	}
+	log.Infof("cdc started on slot %s", c.cfg.Slot)
+
+	var (
+		confirmed    pglogrepl.LSN
+		nextDeadline = time.Now().Add(standbyTimeout)
+	)
+	for {
+		if time.Now().After(nextDeadline) {
+			if err = pglogrepl.SendStandbyStatusUpdate(ctx, conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: confirmed}); err != nil {
+				return fmt.Errorf("error to send standby status: %v", err)
+			}
+			nextDeadline = time.Now().Add(standbyTimeout)
+		}
+
+		receiveCtx, cancel := context.WithDeadline(ctx, nextDeadline)
+		rawMsg, err := conn.ReceiveMessage(receiveCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return fmt.Errorf("error to receive message: %v", err)
+		}
+
+		if errMsg, ok := rawMsg.(*pgproto3.ErrorResponse); ok {
+			return fmt.Errorf("error from replication: %s", errMsg.Message)
+		}
+
+		msg, ok := rawMsg.(*pgproto3.CopyData)
+		if !ok {
+			continue
+		}
+
+		switch msg.Data[0] {
+		case pglogrepl.PrimaryKeepaliveMessageByteID:
+			pkm, err := pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:])
+			if err != nil {
+				return fmt.Errorf("error to parse keepalive: %v", err)
+			}
+			if pkm.ReplyRequested {
+				nextDeadline = time.Time{}
+			}
+
+		case pglogrepl.XLogDataByteID:
+			xld, err := pglogrepl.ParseXLogData(msg.Data[1:])
+			if err != nil {
+				return fmt.Errorf("error to parse xlog data: %v", err)
+			}
+
+			commitLSN, err := c.process(xld.WALData)
+			if err != nil {
+				return err
+			}
+			// Only acknowledge whole transactions, so a crash replays them instead of losing events.
+			if commitLSN > confirmed {
+				confirmed = commitLSN
+			}
+		}
+	}
+}
+
+func (c *CDC) process(walData []byte) (pglogrepl.LSN, error) {
+	msg, err := pglogrepl.Parse(walData)
+	if err != nil {
+		return 0, fmt.Errorf("error to parse logical message: %v", err)
+	}
+
+	switch msg := msg.(type) {
+	case *pglogrepl.RelationMessage:
+		c.relations[msg.RelationID] = msg
+
+	case *pglogrepl.BeginMessage:
+		c.commitTime = msg.CommitTime.UTC()
+
+	case *pglogrepl.CommitMessage:
+		return msg.TransactionEndLSN, nil
+
+	case *pglogrepl.InsertMessage:
+		return 0, c.publish(msg.RelationID, models.EventCreated, nil, msg.Tuple)
+
+	case *pglogrepl.UpdateMessage:
+		return 0, c.publish(msg.RelationID, models.EventUpdated, msg.OldTuple, msg.NewTuple)
+
+	case *pglogrepl.DeleteMessage:
+		return 0, c.publish(msg.RelationID, models.EventRemoved, msg.OldTuple, nil)
+	}
+	return 0, nil
+}
+
+func (c *CDC) publish(relationID uint32, event string, oldTuple, newTuple *pglogrepl.TupleData) error {
+	rel, ok := c.relations[relationID]
+	if !ok {
+		return fmt.Errorf("unknown relation %d", relationID)
+	}
+
+	oldValues, err := c.decode(rel, oldTuple, nil)
+	if err != nil {
+		return err
+	}
+	newValues, err := c.decode(rel, newTuple, oldValues)
+	if err != nil {
+		return err
+	}
+
+	switch rel.RelationName {
+	case "goods":
+		good := goodFromValues(newValues)
+		if newTuple == nil {
+			good = goodFromValues(oldValues)
+			good.Removed = true
+		}
+		if event == models.EventUpdated && oldValues != nil {
+			old := goodFromValues(oldValues)
+			// Changing the search language of the project rewrites the search
+			// vector of its goods, which is not an edit of the good.
+			if !goodChanged(old, good) {
+				return nil
+			}
+			event = updateEvent(old, good)
+		}
+
+		b, err := json.Marshal(models.Log{
+			Event:      event,
+			Good:       good,
+			Version:    good.Version,
+			OccurredAt: c.commitTime,
+		})
+		if err != nil {
+			return fmt.Errorf("error to marshal log: %v", err)
+		}
+		if err = c.publisher.Publish(b); err != nil {
+			return fmt.Errorf("error to publish log: %v", err)
+		}
+
+	case "projects":
+		values := newValues
+		if newTuple == nil {
+			values = oldValues
+		}
+
+		b, err := json.Marshal(models.ProjectLog{
+			Event:      event,
+			Project:    projectFromValues(values),
+			OccurredAt: c.commitTime,
+		})
+		if err != nil {
+			return fmt.Errorf("error to marshal project log: %v", err)
+		}
+		if err = c.publisher.PublishProject(b); err != nil {
+			return fmt.Errorf("error to publish project log: %v", err)
+		}
+	}
+	return nil
+}
+
+// decode turns a tuple into column values. Unchanged TOAST columns are not sent
+// by the server, so they are taken from the previous tuple when there is one.
+func (c *CDC) decode(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData, previous map[string]interface{}) (map[string]interface{}, error) {
+	if tuple == nil {
+		return nil, nil
+	}
+
+	values := map[string]interface{}{}
+	for idx, col := range tuple.Columns {
+		name := rel.Columns[idx].Name
+		switch col.DataType {
+		case 'n':
+			values[name] = nil
+		case 'u':
+			values[name] = previous[name]
+		case 't':
+			dataType := rel.Columns[idx].DataType
+			dt, ok := c.typeMap.TypeForOID(dataType)
+			if !ok {
+				values[name] = string(col.Data)
+				continue
+			}
+			v, err := dt.Codec.DecodeValue(c.typeMap, dataType, pgtype.TextFormatCode, col.Data)
+			if err != nil {
+				return nil, fmt.Errorf("error to decode column %s: %v", name, err)
+			}
+			values[name] = v
+		}
+	}
+	return values, nil
+}
+
+func goodChanged(old, new models.Good) bool {
+	return old.ProjectID != new.ProjectID ||
+		old.Name != new.Name ||
+		old.Description != new.Description ||
+		old.Priority != new.Priority ||
+		old.Removed != new.Removed
+}
+
+func updateEvent(old, new models.Good) string {
+	switch {
+	case !old.Removed && new.Removed:
+		return models.EventRemoved
+	case old.Priority != new.Priority &&
+		old.Name == new.Name &&
+		old.Description == new.Description &&
+		old.Removed == new.Removed:
+		return models.EventReprioritized
+	default:
+		return models.EventUpdated
+	}
+}
+
+func goodFromValues(values map[string]interface{}) models.Good {
+	var good models.Good
+	good.ID = intValue(values["id"])
+	good.ProjectID = intValue(values["project_id"])
+	good.Name, _ = values["name"].(string)
+	good.Description, _ = values["description"].(string)
+	good.Priority = intValue(values["priority"])
+	good.Removed, _ = values["removed"].(bool)
+	good.CreatedAt, _ = values["created_at"].(time.Time)
+	good.Version, _ = values["version"].(int64)
+	return good
+}
+
+func projectFromValues(values map[string]interface{}) models.Project {
+	var project models.Project
+	project.ID = strconv.Itoa(intValue(values["id"]))
+	project.Name, _ = values["name"].(string)
+	project.CreatedAt, _ = values["created_at"].(time.Time)
+	return project
+}
+
+func intValue(v interface{}) int {
+	switch v := v.(type) {
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
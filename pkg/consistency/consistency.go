@@ -0,0 +1,18 @@
+// Package consistency carries the read preference of a request down to the
+// repositories.
+package consistency
+
+import "context"
+
+type primaryKey struct{}
+
+// WithPrimary makes the reads done with ctx go to the primary, so a client
+// sees its own writes even while the replicas lag behind.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func Primary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
@@ -3,5 +3,8 @@ package custerrors
 import "errors"
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound  = errors.New("not found")
+	ErrStale     = errors.New("stale")
+	ErrInvalid   = errors.New("invalid")
+	ErrLeaseLost = errors.New("lease lost")
 )
\ No newline at end of file
@@ -0,0 +1,165 @@
+// Package goodsfile reads and writes goods as CSV or NDJSON files, for both
+// the HTTP endpoints and the background jobs.
+package goodsfile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+type Column struct {
+	name  string
+	value func(models.Good) interface{}
+}
+
+// columns are named after the JSON fields of a good, in their order.
+var columns = []Column{
+	{"id", func(g models.Good) interface{} { return g.ID }},
+	{"project", func(g models.Good) interface{} { return g.ProjectID }},
+	{"name", func(g models.Good) interface{} { return g.Name }},
+	{"description", func(g models.Good) interface{} { return g.Description }},
+	{"priority", func(g models.Good) interface{} { return g.Priority }},
+	{"removed", func(g models.Good) interface{} { return g.Removed }},
+	{"created_at", func(g models.Good) interface{} { return g.CreatedAt.Format(time.RFC3339) }},
+}
+
+// ParseFormat checks the format, defaulting to CSV.
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatCSV, nil
+	case FormatCSV, FormatNDJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("format must be one of %s, %s", FormatCSV, FormatNDJSON)
+}
+
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// ParseColumns picks the columns from a comma separated list, all of them if
+// it is empty.
+func ParseColumns(v string) ([]Column, error) {
+	if v == "" {
+		return columns, nil
+	}
+
+	var picked []Column
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		var found bool
+		for _, c := range columns {
+			if c.name == name {
+				picked = append(picked, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	return picked, nil
+}
+
+// Writer encodes goods one by one. Close must be called once all goods are
+// written, it also writes the CSV header of an empty file.
+type Writer struct {
+	format  string
+	columns []Column
+	csv     *csv.Writer
+	json    *json.Encoder
+	written int
+}
+
+func NewWriter(w io.Writer, format string, columns []Column) *Writer {
+	writer := &Writer{format: format, columns: columns}
+	if format == FormatNDJSON {
+		writer.json = json.NewEncoder(w)
+	} else {
+		writer.csv = csv.NewWriter(w)
+	}
+	return writer
+}
+
+func (w *Writer) Write(good models.Good) error {
+	defer func() { w.written++ }()
+
+	if w.json != nil {
+		line := make(map[string]interface{}, len(w.columns))
+		for _, c := range w.columns {
+			line[c.name] = c.value(good)
+		}
+		return w.json.Encode(line)
+	}
+
+	if w.written == 0 {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		record[i] = csvCell(c.value(good))
+	}
+	return w.csv.Write(record)
+}
+
+// csvCell formats a value for CSV. Spreadsheets run text starting like a
+// formula, so such text is prefixed with a quote to be shown as is.
+func csvCell(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Written is the number of goods written so far.
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Flush pushes the buffered goods to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func (w *Writer) Close() error {
+	if w.csv != nil && w.written == 0 {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (w *Writer) writeHeader() error {
+	header := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		header[i] = c.name
+	}
+	return w.csv.Write(header)
+}
@@ -0,0 +1,44 @@
+package goodsfile
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+func TestCSVQuotesFormulas(t *testing.T) {
+	columns, _ := ParseColumns("name,description,priority")
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatCSV, columns)
+	if err := w.Write(models.Good{Name: "=HYPERLINK(\"http://x\")", Description: "-1 left", Priority: -1}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	want := []string{"'=HYPERLINK(\"http://x\")", "'-1 left", "-1"}
+	for i, cell := range records[1] {
+		if cell != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, cell, want[i])
+		}
+	}
+}
+
+func TestNDJSONKeepsText(t *testing.T) {
+	columns, _ := ParseColumns("name")
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatNDJSON, columns)
+	if err := w.Write(models.Good{Name: "=1+1"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := buf.String(); got != "{\"name\":\"=1+1\"}\n" {
+		t.Errorf("line = %q, want the name unchanged", got)
+	}
+}
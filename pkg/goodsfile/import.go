@@ -0,0 +1,155 @@
+package goodsfile
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+// Line is a row of an import file numbered from 1, with the error that made
+// it unreadable, if any.
+type Line struct {
+	Row  int
+	Good models.ImportGood
+	Err  error
+}
+
+func Read(r io.Reader, format string) ([]Line, error) {
+	if format == FormatNDJSON {
+		return readNDJSON(r)
+	}
+	return readCSV(r)
+}
+
+// readCSV reads a file with a header naming the externalId, name and
+// description columns, in any order.
+func readCSV(r io.Reader) ([]Line, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error to read csv header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"externalid", "name"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv header has no %s column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var lines []Line
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+
+		line := Line{Row: n}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			line.Err = parseErr.Err
+		case err != nil:
+			return nil, fmt.Errorf("error to read csv: %v", err)
+		default:
+			line.Good = models.ImportGood{
+				ExternalID:  field(record, "externalid"),
+				Name:        field(record, "name"),
+				Description: field(record, "description"),
+			}
+		}
+		lines = append(lines, line)
+	}
+}
+
+func readNDJSON(r io.Reader) ([]Line, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var lines []Line
+	for n := 1; scanner.Scan(); n++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		line := Line{Row: n}
+		line.Err = json.Unmarshal(scanner.Bytes(), &line.Good)
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error to read ndjson: %v", err)
+	}
+	return lines, nil
+}
+
+// Prepare validates the lines and splits them into the rows to import and the
+// failures to report. An external id used twice fails on its later rows.
+func Prepare(lines []Line, validate func(interface{}) error) ([]models.ImportRow, []models.ImportRowResult) {
+	var (
+		valid  []models.ImportRow
+		failed []models.ImportRowResult
+		seen   = map[string]int{}
+	)
+	for _, line := range lines {
+		if line.Err == nil {
+			line.Err = validate(line.Good)
+		}
+		if line.Err == nil {
+			if first, ok := seen[line.Good.ExternalID]; ok {
+				line.Err = fmt.Errorf("externalId is already used by row %d", first)
+			} else {
+				seen[line.Good.ExternalID] = line.Row
+			}
+		}
+		if line.Err != nil {
+			failed = append(failed, models.ImportRowResult{
+				Row:        line.Row,
+				ExternalID: line.Good.ExternalID,
+				Status:     models.ImportFailed,
+				Error:      line.Err.Error(),
+			})
+			continue
+		}
+		valid = append(valid, models.ImportRow{Row: line.Row, Good: line.Good})
+	}
+	return valid, failed
+}
+
+// Report merges the results of the imported rows with the failed ones.
+func Report(dryRun bool, results ...[]models.ImportRowResult) models.ImportReport {
+	report := models.ImportReport{DryRun: dryRun, Rows: []models.ImportRowResult{}}
+	for _, r := range results {
+		report.Rows = append(report.Rows, r...)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool { return report.Rows[i].Row < report.Rows[j].Row })
+	for _, row := range report.Rows {
+		switch row.Status {
+		case models.ImportCreated:
+			report.Created++
+		case models.ImportUpdated:
+			report.Updated++
+		case models.ImportFailed:
+			report.Failed++
+		}
+	}
+	return report
+}
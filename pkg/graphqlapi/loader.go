@@ -0,0 +1,101 @@
+package graphqlapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// batchWait is how long a loader collects keys before fetching them, so
+	// the sibling resolvers running in parallel can add theirs.
+	batchWait = 2 * time.Millisecond
+
+	// maxBatch caps the keys fetched at once. It is also the resolver
+	// parallelism of a request, no more keys can be waiting than that.
+	maxBatch = 100
+)
+
+// loader batches the keys asked for by the resolvers of one request into one
+// fetch, like a dataloader. Keys are fetched once per request; a loader that
+// does not cache fetches every key again, for long lived subscriptions.
+type loader[K comparable, V any] struct {
+	ctx   context.Context
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+	cache bool
+
+	mu      sync.Mutex
+	pending *batch[K, V]
+	batches map[K]*batch[K, V]
+}
+
+type batch[K comparable, V any] struct {
+	keys   []K
+	done   chan struct{}
+	values map[K]V
+	err    error
+}
+
+func newLoader[K comparable, V any](ctx context.Context, cache bool, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{ctx: ctx, fetch: fetch, cache: cache, batches: map[K]*batch[K, V]{}}
+}
+
+// load returns the value of key, false if the fetch did not return one.
+func (l *loader[K, V]) load(ctx context.Context, key K) (V, bool, error) {
+	l.mu.Lock()
+	b, ok := l.batches[key]
+	if !ok {
+		if l.pending == nil {
+			pending := &batch[K, V]{done: make(chan struct{})}
+			l.pending = pending
+			time.AfterFunc(batchWait, func() { l.run(pending) })
+		}
+		b = l.pending
+		b.keys = append(b.keys, key)
+		l.batches[key] = b
+		// A full batch is fetched at once, the next keys start another.
+		if len(b.keys) == maxBatch {
+			l.pending = nil
+			go l.fetchBatch(b)
+		}
+	}
+	l.mu.Unlock()
+
+	var zero V
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return zero, false, ctx.Err()
+	}
+	if b.err != nil {
+		return zero, false, b.err
+	}
+	v, ok := b.values[key]
+	return v, ok, nil
+}
+
+// run fetches b on its timer, unless it was fetched when it got full.
+func (l *loader[K, V]) run(b *batch[K, V]) {
+	l.mu.Lock()
+	if l.pending != b {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = nil
+	l.mu.Unlock()
+
+	l.fetchBatch(b)
+}
+
+func (l *loader[K, V]) fetchBatch(b *batch[K, V]) {
+	b.values, b.err = l.fetch(l.ctx, b.keys)
+	close(b.done)
+
+	if !l.cache || b.err != nil {
+		l.mu.Lock()
+		for _, key := range b.keys {
+			delete(l.batches, key)
+		}
+		l.mu.Unlock()
+	}
+}
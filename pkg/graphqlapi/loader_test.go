@@ -0,0 +1,194 @@
+package graphqlapi
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+// countingFetch records the keys of every fetch.
+type countingFetch struct {
+	mu      sync.Mutex
+	fetches [][]int
+	err     error
+}
+
+func (f *countingFetch) fetch(_ context.Context, keys []int) (map[int]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetches = append(f.fetches, slices.Clone(keys))
+	if f.err != nil {
+		return nil, f.err
+	}
+	values := make(map[int]string, len(keys))
+	for _, key := range keys {
+		if key > 0 {
+			values[key] = "v"
+		}
+	}
+	return values, nil
+}
+
+// loadAll loads the keys in parallel, as sibling resolvers do.
+func loadAll(l *loader[int, string], keys ...int) []error {
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i, key int) {
+			defer wg.Done()
+			_, _, errs[i] = l.load(context.Background(), key)
+		}(i, key)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestLoaderBatchesParallelLoads(t *testing.T) {
+	f := &countingFetch{}
+	l := newLoader(context.Background(), true, f.fetch)
+
+	loadAll(l, 1, 2, 3, 2, 1)
+	if len(f.fetches) != 1 {
+		t.Fatalf("fetched %d times, want once", len(f.fetches))
+	}
+	keys := slices.Clone(f.fetches[0])
+	slices.Sort(keys)
+	if !slices.Equal(keys, []int{1, 2, 3}) {
+		t.Errorf("fetched %v, want each key once", keys)
+	}
+
+	// Cached keys are not fetched again, missing ones are reported.
+	v, ok, err := l.load(context.Background(), 2)
+	if err != nil || !ok || v != "v" {
+		t.Errorf("load(2) = %q, %t, %v; want the cached value", v, ok, err)
+	}
+	if _, ok, _ = l.load(context.Background(), -1); ok {
+		t.Error("load(-1) found a value the fetch did not return")
+	}
+	if len(f.fetches) != 2 {
+		t.Errorf("fetched %d times, want only the new key fetched", len(f.fetches))
+	}
+}
+
+func TestLoaderSplitsLargeBatches(t *testing.T) {
+	f := &countingFetch{}
+	l := newLoader(context.Background(), true, f.fetch)
+
+	keys := make([]int, maxBatch+1)
+	for i := range keys {
+		keys[i] = i + 1
+	}
+	loadAll(l, keys...)
+
+	var fetched int
+	for _, batch := range f.fetches {
+		if len(batch) > maxBatch {
+			t.Errorf("fetched %d keys at once, want at most %d", len(batch), maxBatch)
+		}
+		fetched += len(batch)
+	}
+	if fetched != len(keys) {
+		t.Errorf("fetched %d keys, want %d", fetched, len(keys))
+	}
+}
+
+func TestLoaderWithoutCacheFetchesAgain(t *testing.T) {
+	f := &countingFetch{}
+	l := newLoader(context.Background(), false, f.fetch)
+
+	loadAll(l, 1, 2)
+	loadAll(l, 1, 2)
+	if len(f.fetches) != 2 {
+		t.Errorf("fetched %d times, want once per round", len(f.fetches))
+	}
+}
+
+func TestLoaderDoesNotCacheErrors(t *testing.T) {
+	f := &countingFetch{err: errors.New("down")}
+	l := newLoader(context.Background(), true, f.fetch)
+
+	for _, err := range loadAll(l, 1, 2) {
+		if err == nil {
+			t.Fatal("load succeeded while the fetch fails")
+		}
+	}
+
+	f.err = nil
+	if _, ok, err := l.load(context.Background(), 1); err != nil || !ok {
+		t.Errorf("load(1) = %t, %v after the fetch recovered, want the value", ok, err)
+	}
+}
+
+// fakeService counts the calls the loaders make.
+type fakeService struct {
+	service
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *fakeService) called(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[name]++
+}
+
+func (f *fakeService) Projects(_ context.Context, projectIDs []int) ([]models.ProjectSummary, error) {
+	f.called("Projects")
+	projects := make([]models.ProjectSummary, 0, len(projectIDs))
+	for _, id := range projectIDs {
+		projects = append(projects, models.ProjectSummary{ID: id, Name: "project"})
+	}
+	return projects, nil
+}
+
+func (f *fakeService) ProjectsGoods(_ context.Context, projectIDs []int, limit int, _ *bool) ([]models.Good, error) {
+	f.called("ProjectsGoods")
+	var goods []models.Good
+	for _, id := range projectIDs {
+		for i := 1; i <= limit; i++ {
+			goods = append(goods, models.Good{ID: id*100 + i, ProjectID: id, Name: "good", Priority: i})
+		}
+	}
+	return goods, nil
+}
+
+func (f *fakeService) GoodsHistory(_ context.Context, goodIDs []int, _ int) ([]models.Log, error) {
+	f.called("GoodsHistory")
+	var logs []models.Log
+	for _, id := range goodIDs {
+		logs = append(logs, models.Log{Event: models.EventCreated, Good: models.Good{ID: id}})
+	}
+	return logs, nil
+}
+
+func TestNestedFieldsAreFetchedOncePerLevel(t *testing.T) {
+	service := &fakeService{calls: map[string]int{}}
+	schema := NewSchema(service, nil)
+
+	resp := schema.Exec(context.Background(), `{
+		projects(ids: [1, 2, 3]) {
+			goods(limit: 5) {
+				name
+				project { name }
+				history(limit: 2) { event }
+			}
+		}
+	}`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errors: %v", resp.Errors)
+	}
+
+	// The root field, then one fetch per nested field for the 15 goods.
+	want := map[string]int{"Projects": 2, "ProjectsGoods": 1, "GoodsHistory": 1}
+	for name, n := range want {
+		if service.calls[name] != n {
+			t.Errorf("%s called %d times, want %d", name, service.calls[name], n)
+		}
+	}
+}
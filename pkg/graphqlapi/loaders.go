@@ -0,0 +1,118 @@
+package graphqlapi
+
+import (
+	"context"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+type loadersKey struct{}
+
+// goodsKey is the goods of a project as asked for by Project.goods.
+type goodsKey struct {
+	projectID int
+	limit     int
+	// filtered tells whether removed applies.
+	filtered bool
+	removed  bool
+}
+
+// historyKey is the changes of a project or a good.
+type historyKey struct {
+	id    int
+	limit int
+}
+
+// loaders are the loaders of one request. A field asked for on every item of
+// a list costs one query for the whole list instead of one per item.
+type loaders struct {
+	projects       *loader[int, models.ProjectSummary]
+	projectGoods   *loader[goodsKey, []models.Good]
+	projectHistory *loader[historyKey, []models.Log]
+	goodHistory    *loader[historyKey, []models.Log]
+}
+
+func withLoaders(ctx context.Context, service service, cache bool) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		projects:       newLoader(ctx, cache, fetchProjects(service)),
+		projectGoods:   newLoader(ctx, cache, fetchProjectGoods(service)),
+		projectHistory: newLoader(ctx, cache, fetchHistory(service.ProjectsHistory, func(l models.Log) int { return l.ProjectID })),
+		goodHistory:    newLoader(ctx, cache, fetchHistory(service.GoodsHistory, func(l models.Log) int { return l.ID })),
+	})
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func fetchProjects(service service) func(context.Context, []int) (map[int]models.ProjectSummary, error) {
+	return func(ctx context.Context, projectIDs []int) (map[int]models.ProjectSummary, error) {
+		projects, err := service.Projects(ctx, projectIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[int]models.ProjectSummary, len(projects))
+		for _, project := range projects {
+			values[project.ID] = project
+		}
+		return values, nil
+	}
+}
+
+// fetchProjectGoods runs one query per distinct filter, usually there is one.
+func fetchProjectGoods(service service) func(context.Context, []goodsKey) (map[goodsKey][]models.Good, error) {
+	return func(ctx context.Context, keys []goodsKey) (map[goodsKey][]models.Good, error) {
+		groups := map[goodsKey][]int{}
+		for _, key := range keys {
+			filter := key
+			filter.projectID = 0
+			groups[filter] = append(groups[filter], key.projectID)
+		}
+
+		values := make(map[goodsKey][]models.Good, len(keys))
+		for filter, projectIDs := range groups {
+			var removed *bool
+			if filter.filtered {
+				removed = &filter.removed
+			}
+
+			goods, err := service.ProjectsGoods(ctx, projectIDs, filter.limit, removed)
+			if err != nil {
+				return nil, err
+			}
+			for _, good := range goods {
+				key := filter
+				key.projectID = good.ProjectID
+				values[key] = append(values[key], good)
+			}
+		}
+		return values, nil
+	}
+}
+
+// fetchHistory runs one query per distinct limit, usually there is one.
+func fetchHistory(
+	history func(ctx context.Context, ids []int, limit int) ([]models.Log, error),
+	idOf func(models.Log) int,
+) func(context.Context, []historyKey) (map[historyKey][]models.Log, error) {
+	return func(ctx context.Context, keys []historyKey) (map[historyKey][]models.Log, error) {
+		groups := map[int][]int{}
+		for _, key := range keys {
+			groups[key.limit] = append(groups[key.limit], key.id)
+		}
+
+		values := make(map[historyKey][]models.Log, len(keys))
+		for limit, ids := range groups {
+			logs, err := history(ctx, ids, limit)
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range logs {
+				key := historyKey{id: idOf(l), limit: limit}
+				values[key] = append(values[key], l)
+			}
+		}
+		return values, nil
+	}
+}
@@ -0,0 +1,474 @@
+package graphqlapi
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type resolver struct {
+	service service
+	stream  streamer
+}
+
+func (r *resolver) Project(ctx context.Context, args struct{ ID int32 }) (*projectResolver, error) {
+	project, ok, err := loadersFrom(ctx).projects.load(ctx, int(args.ID))
+	if err != nil {
+		return nil, toError(err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &projectResolver{project: project}, nil
+}
+
+// Projects skips the ids of projects that do not exist.
+func (r *resolver) Projects(ctx context.Context, args struct{ IDs []int32 }) ([]*projectResolver, error) {
+	if len(args.IDs) > maxLimit {
+		return nil, badInput("at most 100 ids can be asked for")
+	}
+
+	projectIDs := make([]int, 0, len(args.IDs))
+	for _, id := range args.IDs {
+		projectIDs = append(projectIDs, int(id))
+	}
+
+	projects, err := r.service.Projects(ctx, projectIDs)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resolvers := make([]*projectResolver, 0, len(projects))
+	for _, project := range projects {
+		resolvers = append(resolvers, &projectResolver{project: project})
+	}
+	return resolvers, nil
+}
+
+func (r *resolver) Good(ctx context.Context, args struct{ ID, ProjectID int32 }) (*goodResolver, error) {
+	goodResponse, err := r.service.Good(ctx, int(args.ID), int(args.ProjectID))
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, toError(err)
+	}
+	return &goodResolver{good: goodResponse.Good}, nil
+}
+
+func (r *resolver) Goods(ctx context.Context, args struct {
+	ProjectID     int32
+	Limit, Offset *int32
+}) (*goodsPageResolver, error) {
+	limit, err := limitArg(args.Limit, 10)
+	if err != nil {
+		return nil, err
+	}
+	var offset int
+	if args.Offset != nil {
+		offset = int(*args.Offset)
+	}
+	if offset < 0 {
+		return nil, badInput("offset must not be negative")
+	}
+
+	goodsResponse, err := r.service.Goods(ctx, int(args.ProjectID), limit, offset)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &goodsPageResolver{page: goodsResponse}, nil
+}
+
+func (r *resolver) CreateGood(ctx context.Context, args struct {
+	ProjectID int32
+	Name      string
+}) (*goodResolver, error) {
+	if args.Name == "" {
+		return nil, badInput("name is required")
+	}
+
+	good, err := r.service.CreateGood(ctx, int(args.ProjectID), args.Name)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &goodResolver{good: good}, nil
+}
+
+type goodInput struct {
+	Name        *string
+	Description *string
+	Priority    *int32
+	Removed     *bool
+}
+
+// UpdateGood changes the fields set in the input, as the HTTP API does with
+// the fields of the body.
+func (r *resolver) UpdateGood(ctx context.Context, args struct {
+	ID, ProjectID int32
+	Input         goodInput
+}) (*goodResolver, error) {
+	var data models.Good
+	if args.Input.Name != nil {
+		data.Name = *args.Input.Name
+	}
+	if args.Input.Description != nil {
+		data.Description = *args.Input.Description
+	}
+	if args.Input.Priority != nil {
+		data.Priority = int(*args.Input.Priority)
+	}
+	if args.Input.Removed != nil {
+		data.Removed = *args.Input.Removed
+	}
+
+	good, err := r.service.UpdateGood(ctx, data, int(args.ID), int(args.ProjectID))
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &goodResolver{good: good}, nil
+}
+
+func (r *resolver) DeleteGood(ctx context.Context, args struct{ ID, ProjectID int32 }) (*goodResolver, error) {
+	good, err := r.service.DeleteGood(ctx, int(args.ID), int(args.ProjectID))
+	if err != nil {
+		return nil, toError(err)
+	}
+	return &goodResolver{good: good}, nil
+}
+
+func (r *resolver) ReprioritizeGood(ctx context.Context, args struct {
+	ID, ProjectID, Priority int32
+}) ([]*priorityResolver, error) {
+	if args.Priority == 0 {
+		return nil, badInput("priority is required")
+	}
+
+	priorities, err := r.service.ReprioritizeGood(ctx, int(args.ID), int(args.ProjectID), int(args.Priority))
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resolvers := make([]*priorityResolver, 0, len(priorities))
+	for _, p := range priorities {
+		resolvers = append(resolvers, &priorityResolver{priority: p})
+	}
+	return resolvers, nil
+}
+
+func (r *resolver) SetSearchLanguage(ctx context.Context, args struct {
+	ProjectID int32
+	Language  string
+}) (*projectResolver, error) {
+	if args.Language == "" {
+		return nil, badInput("language is required")
+	}
+
+	if err := r.service.SetSearchLanguage(ctx, int(args.ProjectID), args.Language); err != nil {
+		return nil, toError(err)
+	}
+
+	// Read back past the loader, it may hold the project as it was.
+	projects, err := r.service.Projects(ctx, []int{int(args.ProjectID)})
+	if err != nil {
+		return nil, toError(err)
+	}
+	if len(projects) == 0 {
+		return nil, toError(custerrors.ErrNotFound)
+	}
+	return &projectResolver{project: projects[0]}, nil
+}
+
+func (r *resolver) GoodChanged(ctx context.Context, args struct {
+	ProjectID   *int32
+	LastEventID *string
+}) (<-chan *goodEventResolver, error) {
+	var (
+		projectID   int
+		lastEventID string
+	)
+	if args.ProjectID != nil {
+		projectID = int(*args.ProjectID)
+	}
+	if args.LastEventID != nil {
+		lastEventID = *args.LastEventID
+	}
+
+	sub, missed, complete := r.stream.Subscribe(projectID, lastEventID)
+	if !complete {
+		// The client reloads the goods, which already hold the missed changes.
+		missed = []models.StreamEvent{{Event: models.StreamReset}}
+	}
+
+	events := make(chan *goodEventResolver)
+	go func() {
+		defer close(events)
+		defer r.stream.Unsubscribe(sub)
+
+		send := func(e models.StreamEvent) bool {
+			select {
+			case events <- &goodEventResolver{event: e}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, e := range missed {
+			if !send(e) {
+				return
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.Overflow:
+				send(models.StreamEvent{Event: models.StreamOverflow})
+				return
+			case e := <-sub.Events:
+				if !send(e) {
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+type projectResolver struct {
+	project models.ProjectSummary
+}
+
+func (r *projectResolver) ID() int32 {
+	return int32(r.project.ID)
+}
+
+func (r *projectResolver) Name() string {
+	return r.project.Name
+}
+
+func (r *projectResolver) SearchLanguage() string {
+	return r.project.SearchLanguage
+}
+
+func (r *projectResolver) CreatedAt() graphql.Time {
+	return toTime(r.project.CreatedAt)
+}
+
+func (r *projectResolver) Counts() *countsResolver {
+	return &countsResolver{total: r.project.Total, removed: r.project.Removed}
+}
+
+func (r *projectResolver) Goods(ctx context.Context, args struct {
+	Limit   *int32
+	Removed *bool
+}) ([]*goodResolver, error) {
+	limit, err := limitArg(args.Limit, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	key := goodsKey{projectID: r.project.ID, limit: limit}
+	if args.Removed != nil {
+		key.filtered, key.removed = true, *args.Removed
+	}
+
+	goods, _, err := loadersFrom(ctx).projectGoods.load(ctx, key)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resolvers := make([]*goodResolver, 0, len(goods))
+	for _, good := range goods {
+		resolvers = append(resolvers, &goodResolver{good: good})
+	}
+	return resolvers, nil
+}
+
+func (r *projectResolver) History(ctx context.Context, args struct{ Limit *int32 }) ([]*changeResolver, error) {
+	return loadHistory(ctx, loadersFrom(ctx).projectHistory, r.project.ID, args.Limit)
+}
+
+type countsResolver struct {
+	total, removed int
+}
+
+func (r *countsResolver) Total() int32 {
+	return int32(r.total)
+}
+
+func (r *countsResolver) Removed() int32 {
+	return int32(r.removed)
+}
+
+type goodResolver struct {
+	good models.Good
+}
+
+func (r *goodResolver) ID() int32 {
+	return int32(r.good.ID)
+}
+
+func (r *goodResolver) ProjectID() int32 {
+	return int32(r.good.ProjectID)
+}
+
+func (r *goodResolver) Name() string {
+	return r.good.Name
+}
+
+func (r *goodResolver) Description() string {
+	return r.good.Description
+}
+
+func (r *goodResolver) Priority() int32 {
+	return int32(r.good.Priority)
+}
+
+func (r *goodResolver) Removed() bool {
+	return r.good.Removed
+}
+
+func (r *goodResolver) CreatedAt() graphql.Time {
+	return toTime(r.good.CreatedAt)
+}
+
+func (r *goodResolver) Project(ctx context.Context) (*projectResolver, error) {
+	project, ok, err := loadersFrom(ctx).projects.load(ctx, r.good.ProjectID)
+	if err != nil {
+		return nil, toError(err)
+	}
+	if !ok {
+		return nil, toError(custerrors.ErrNotFound)
+	}
+	return &projectResolver{project: project}, nil
+}
+
+func (r *goodResolver) History(ctx context.Context, args struct{ Limit *int32 }) ([]*changeResolver, error) {
+	return loadHistory(ctx, loadersFrom(ctx).goodHistory, r.good.ID, args.Limit)
+}
+
+func loadHistory(ctx context.Context, l *loader[historyKey, []models.Log], id int, limitArgument *int32) ([]*changeResolver, error) {
+	limit, err := limitArg(limitArgument, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, _, err := l.load(ctx, historyKey{id: id, limit: limit})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resolvers := make([]*changeResolver, 0, len(logs))
+	for _, l := range logs {
+		resolvers = append(resolvers, &changeResolver{log: l})
+	}
+	return resolvers, nil
+}
+
+type goodsPageResolver struct {
+	page models.GoodsResponse
+}
+
+func (r *goodsPageResolver) Limit() int32 {
+	return int32(r.page.Meta.Limit)
+}
+
+func (r *goodsPageResolver) Offset() int32 {
+	return int32(r.page.Meta.Offset)
+}
+
+func (r *goodsPageResolver) Total() int32 {
+	return int32(r.page.Meta.Total)
+}
+
+func (r *goodsPageResolver) Removed() int32 {
+	return int32(r.page.Meta.Removed)
+}
+
+func (r *goodsPageResolver) Goods() []*goodResolver {
+	resolvers := make([]*goodResolver, 0, len(r.page.Goods))
+	for _, good := range r.page.Goods {
+		resolvers = append(resolvers, &goodResolver{good: good})
+	}
+	return resolvers
+}
+
+type changeResolver struct {
+	log models.Log
+}
+
+func (r *changeResolver) Event() string {
+	return r.log.Event
+}
+
+func (r *changeResolver) GoodID() int32 {
+	return int32(r.log.ID)
+}
+
+func (r *changeResolver) ProjectID() int32 {
+	return int32(r.log.ProjectID)
+}
+
+func (r *changeResolver) Name() string {
+	return r.log.Name
+}
+
+func (r *changeResolver) Description() string {
+	return r.log.Description
+}
+
+func (r *changeResolver) Priority() int32 {
+	return int32(r.log.Priority)
+}
+
+func (r *changeResolver) Removed() bool {
+	return r.log.Removed
+}
+
+func (r *changeResolver) At() graphql.Time {
+	return toTime(r.log.OccurredAt)
+}
+
+type priorityResolver struct {
+	priority models.ReprioritizeGoodResponse
+}
+
+// ID is the good id, which the service returns as a string.
+func (r *priorityResolver) ID() (int32, error) {
+	id, err := strconv.Atoi(r.priority.ID)
+	if err != nil {
+		return 0, toError(err)
+	}
+	return int32(id), nil
+}
+
+func (r *priorityResolver) Priority() int32 {
+	return int32(r.priority.Priority)
+}
+
+type goodEventResolver struct {
+	event models.StreamEvent
+}
+
+func (r *goodEventResolver) ID() *string {
+	if r.event.ID == "" {
+		return nil
+	}
+	return &r.event.ID
+}
+
+func (r *goodEventResolver) Event() string {
+	return r.event.Event
+}
+
+func (r *goodEventResolver) Good() *goodResolver {
+	if r.event.Event == models.StreamReset || r.event.Event == models.StreamOverflow {
+		return nil
+	}
+	return &goodResolver{good: r.event.Good}
+}
@@ -0,0 +1,210 @@
+// Package graphqlapi serves the projects and goods over GraphQL with the same
+// service as the HTTP API.
+package graphqlapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/stream"
+	"github.com/graph-gophers/graphql-go"
+)
+
+const schema = `
+	schema {
+		query: Query
+		mutation: Mutation
+		subscription: Subscription
+	}
+
+	scalar Time
+
+	# Limits default to 10 and go up to 100.
+	type Query {
+		project(id: Int!): Project
+		projects(ids: [Int!]!): [Project!]!
+		good(id: Int!, projectId: Int!): Good
+		goods(projectId: Int!, limit: Int, offset: Int): GoodsPage!
+	}
+
+	type Mutation {
+		createGood(projectId: Int!, name: String!): Good!
+		updateGood(id: Int!, projectId: Int!, input: GoodInput!): Good!
+		deleteGood(id: Int!, projectId: Int!): Good!
+		reprioritizeGood(id: Int!, projectId: Int!, priority: Int!): [Priority!]!
+		setSearchLanguage(projectId: Int!, language: String!): Project!
+	}
+
+	type Subscription {
+		# Resumes after lastEventId. A "reset" event means the missed changes
+		# are not known anymore and the goods must be reloaded, an "overflow"
+		# event ends a subscription that could not keep up.
+		goodChanged(projectId: Int, lastEventId: String): GoodEvent!
+	}
+
+	type Project {
+		id: Int!
+		name: String!
+		searchLanguage: String!
+		createdAt: Time!
+		counts: Counts!
+		goods(limit: Int, removed: Boolean): [Good!]!
+		history(limit: Int): [Change!]!
+	}
+
+	type Counts {
+		total: Int!
+		removed: Int!
+	}
+
+	type Good {
+		id: Int!
+		projectId: Int!
+		name: String!
+		description: String!
+		priority: Int!
+		removed: Boolean!
+		createdAt: Time!
+		project: Project!
+		history(limit: Int): [Change!]!
+	}
+
+	type GoodsPage {
+		limit: Int!
+		offset: Int!
+		total: Int!
+		removed: Int!
+		goods: [Good!]!
+	}
+
+	# A change of a good as logged, removals and reprioritizations only carry
+	# the fields they change.
+	type Change {
+		event: String!
+		goodId: Int!
+		projectId: Int!
+		name: String!
+		description: String!
+		priority: Int!
+		removed: Boolean!
+		at: Time!
+	}
+
+	type Priority {
+		id: Int!
+		priority: Int!
+	}
+
+	type GoodEvent {
+		id: String
+		event: String!
+		good: Good
+	}
+
+	input GoodInput {
+		name: String
+		description: String
+		priority: Int
+		removed: Boolean
+	}
+`
+
+// maxLimit bounds the limit arguments.
+const maxLimit = 100
+
+type service interface {
+	Goods(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error)
+	Good(ctx context.Context, goodID, projectID int) (models.GoodResponse, error)
+	CreateGood(ctx context.Context, projectID int, name string) (models.Good, error)
+	UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error)
+	DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error)
+	ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.ReprioritizeGoodResponse, error)
+	SetSearchLanguage(ctx context.Context, projectID int, language string) error
+	Projects(ctx context.Context, projectIDs []int) ([]models.ProjectSummary, error)
+	ProjectsGoods(ctx context.Context, projectIDs []int, limit int, removed *bool) ([]models.Good, error)
+	ProjectsHistory(ctx context.Context, projectIDs []int, limit int) ([]models.Log, error)
+	GoodsHistory(ctx context.Context, goodIDs []int, limit int) ([]models.Log, error)
+}
+
+type streamer interface {
+	Subscribe(projectID int, lastEventID string) (*stream.Subscription, []models.StreamEvent, bool)
+	Unsubscribe(sub *stream.Subscription)
+}
+
+// Schema runs the operations, each with its own loaders.
+type Schema struct {
+	schema  *graphql.Schema
+	service service
+}
+
+func NewSchema(service service, stream streamer) *Schema {
+	return &Schema{
+		schema: graphql.MustParseSchema(
+			schema,
+			&resolver{service: service, stream: stream},
+			graphql.MaxParallelism(maxBatch),
+			graphql.MaxDepth(10),
+		),
+		service: service,
+	}
+}
+
+func (s *Schema) Exec(ctx context.Context, query, operationName string, variables map[string]interface{}) *graphql.Response {
+	return s.schema.Exec(withLoaders(ctx, s.service, true), query, operationName, variables)
+}
+
+// Subscribe runs any operation, a subscription sends a response per event.
+// Its loaders do not cache, the events would be resolved with stale projects.
+func (s *Schema) Subscribe(ctx context.Context, query, operationName string, variables map[string]interface{}) (<-chan interface{}, error) {
+	return s.schema.Subscribe(withLoaders(ctx, s.service, false), query, operationName, variables)
+}
+
+// apiError carries the kind of failure in the error extensions.
+type apiError struct {
+	err  error
+	code string
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// toError tags the service errors with codes matching the HTTP statuses of
+// the same failures.
+func toError(err error) error {
+	switch {
+	case errors.Is(err, custerrors.ErrNotFound):
+		return &apiError{err: err, code: "NOT_FOUND"}
+	case errors.Is(err, custerrors.ErrInvalid):
+		return &apiError{err: err, code: "BAD_USER_INPUT"}
+	case errors.Is(err, custerrors.ErrStale):
+		return &apiError{err: err, code: "CONFLICT"}
+	}
+	return &apiError{err: err, code: "INTERNAL"}
+}
+
+func badInput(msg string) error {
+	return &apiError{err: errors.New(msg), code: "BAD_USER_INPUT"}
+}
+
+// limitArg returns the limit argument, checked against maxLimit.
+func limitArg(limit *int32, def int) (int, error) {
+	if limit == nil {
+		return def, nil
+	}
+	if *limit < 1 || *limit > maxLimit {
+		return 0, badInput("limit must be between 1 and 100")
+	}
+	return int(*limit), nil
+}
+
+func toTime(t time.Time) graphql.Time {
+	return graphql.Time{Time: t}
+}
@@ -0,0 +1,135 @@
+package grpcapi
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type goodsServer struct {
+	pb.UnimplementedGoodsServer
+
+	service service
+	stream  streamer
+}
+
+func (s *goodsServer) ListGoods(req *pb.ListGoodsRequest, srv pb.Goods_ListGoodsServer) error {
+	return s.service.ExportGoods(srv.Context(), int(req.ProjectId), req.Removed, func(good models.Good) error {
+		return srv.Send(toGood(good))
+	})
+}
+
+func (s *goodsServer) GetGood(ctx context.Context, req *pb.GetGoodRequest) (*pb.Good, error) {
+	goodResponse, err := s.service.Good(ctx, int(req.Id), int(req.ProjectId))
+	if err != nil {
+		return nil, err
+	}
+	return toGood(goodResponse.Good), nil
+}
+
+func (s *goodsServer) CreateGood(ctx context.Context, req *pb.CreateGoodRequest) (*pb.Good, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	good, err := s.service.CreateGood(ctx, int(req.ProjectId), req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return toGood(good), nil
+}
+
+func (s *goodsServer) UpdateGood(ctx context.Context, req *pb.UpdateGoodRequest) (*pb.Good, error) {
+	good, err := s.service.UpdateGood(ctx, models.Good{
+		Name:        req.Name,
+		Description: req.Description,
+		Priority:    int(req.Priority),
+		Removed:     req.Removed,
+	}, int(req.Id), int(req.ProjectId))
+	if err != nil {
+		return nil, err
+	}
+	return toGood(good), nil
+}
+
+func (s *goodsServer) DeleteGood(ctx context.Context, req *pb.DeleteGoodRequest) (*pb.Good, error) {
+	good, err := s.service.DeleteGood(ctx, int(req.Id), int(req.ProjectId))
+	if err != nil {
+		return nil, err
+	}
+	return toGood(good), nil
+}
+
+func (s *goodsServer) ReprioritizeGood(ctx context.Context, req *pb.ReprioritizeGoodRequest) (*pb.ReprioritizeGoodResponse, error) {
+	if req.NewPriority == 0 {
+		return nil, status.Error(codes.InvalidArgument, "new_priority is required")
+	}
+
+	priorities, err := s.service.ReprioritizeGood(ctx, int(req.Id), int(req.ProjectId), int(req.NewPriority))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ReprioritizeGoodResponse{}
+	for _, p := range priorities {
+		id, _ := strconv.ParseInt(p.ID, 10, 64)
+		resp.Priorities = append(resp.Priorities, &pb.ReprioritizeGoodResponse_Priority{
+			Id:       id,
+			Priority: int64(p.Priority),
+		})
+	}
+	return resp, nil
+}
+
+func (s *goodsServer) WatchGoods(req *pb.WatchGoodsRequest, srv pb.Goods_WatchGoodsServer) error {
+	sub, missed, complete := s.stream.Subscribe(int(req.ProjectId), req.LastEventId)
+	defer s.stream.Unsubscribe(sub)
+
+	if !complete {
+		// The client reloads the goods, which already hold the missed changes.
+		missed = []models.StreamEvent{{Event: models.StreamReset}}
+	}
+	for _, e := range missed {
+		if err := srv.Send(toGoodEvent(e)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case <-sub.Overflow:
+			return status.Error(codes.ResourceExhausted, "client fell behind the changes, resume from the last event id")
+		case e := <-sub.Events:
+			if err := srv.Send(toGoodEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toGood(good models.Good) *pb.Good {
+	return &pb.Good{
+		Id:          int64(good.ID),
+		ProjectId:   int64(good.ProjectID),
+		Name:        good.Name,
+		Description: good.Description,
+		Priority:    int64(good.Priority),
+		Removed:     good.Removed,
+		CreatedAt:   timestamppb.New(good.CreatedAt),
+	}
+}
+
+func toGoodEvent(e models.StreamEvent) *pb.GoodEvent {
+	event := &pb.GoodEvent{Id: e.ID, Event: e.Event}
+	if e.Event != models.StreamReset {
+		event.Good = toGood(e.Good)
+	}
+	return event
+}
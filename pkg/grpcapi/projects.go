@@ -0,0 +1,26 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/Hymiside/hezzl-api/pkg/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type projectsServer struct {
+	pb.UnimplementedProjectsServer
+
+	service service
+}
+
+func (s *projectsServer) SetSearchLanguage(ctx context.Context, req *pb.SetSearchLanguageRequest) (*pb.Project, error) {
+	if req.Language == "" {
+		return nil, status.Error(codes.InvalidArgument, "language is required")
+	}
+
+	if err := s.service.SetSearchLanguage(ctx, int(req.ProjectId), req.Language); err != nil {
+		return nil, err
+	}
+	return &pb.Project{Id: req.ProjectId, SearchLanguage: req.Language}, nil
+}
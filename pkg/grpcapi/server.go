@@ -0,0 +1,147 @@
+// Package grpcapi serves the goods over gRPC with the same service as the
+// HTTP API.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/pb"
+	"github.com/Hymiside/hezzl-api/pkg/stream"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// shutdownTimeout is how long in-flight calls get to finish on shutdown,
+// streams that are still open after it are cut.
+const shutdownTimeout = 10 * time.Second
+
+type service interface {
+	Good(ctx context.Context, goodID, projectID int) (models.GoodResponse, error)
+	CreateGood(ctx context.Context, projectID int, name string) (models.Good, error)
+	UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error)
+	DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error)
+	ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.ReprioritizeGoodResponse, error)
+	ExportGoods(ctx context.Context, projectID int, removed *bool, fn func(models.Good) error) error
+	SetSearchLanguage(ctx context.Context, projectID int, language string) error
+}
+
+type streamer interface {
+	Subscribe(projectID int, lastEventID string) (*stream.Subscription, []models.StreamEvent, bool)
+	Unsubscribe(sub *stream.Subscription)
+}
+
+type authenticator interface {
+	Check(authorization string) error
+}
+
+// NewServer creates the gRPC server with the Goods and Projects services.
+func NewServer(service service, stream streamer, auth authenticator) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryInterceptor(auth)),
+		grpc.ChainStreamInterceptor(streamInterceptor(auth)),
+	)
+	pb.RegisterGoodsServer(server, &goodsServer{service: service, stream: stream})
+	pb.RegisterProjectsServer(server, &projectsServer{service: service})
+	return server
+}
+
+// StartServer serves until ctx is done, then stops gracefully.
+func StartServer(ctx context.Context, server *grpc.Server, cfg models.ConfigServer) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Host, cfg.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen grpc: %w", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+
+		done := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
+			server.Stop()
+		}
+	}()
+
+	log.Infof("grpc server started on %s:%s", cfg.Host, cfg.Port)
+	if err = server.Serve(lis); err != nil {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+func unaryInterceptor(auth authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authenticate(ctx, auth); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		return resp, toStatus(err)
+	}
+}
+
+func streamInterceptor(auth authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authenticate(ss.Context(), auth); err != nil {
+			return err
+		}
+		return toStatus(handler(srv, ss))
+	}
+}
+
+// authenticate takes the token from the authorization metadata, as the HTTP
+// API takes it from the header.
+func authenticate(ctx context.Context, auth authenticator) error {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	if err := auth.Check(authorization); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+// toStatus maps the service errors to the codes matching the HTTP statuses
+// of the same failures.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, custerrors.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, custerrors.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, custerrors.ErrStale):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultAnalyticsPeriod = 7 * 24 * time.Hour
+
+func (h *Handler) Activity(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	switch bucket {
+	case "":
+		bucket = "day"
+	case "hour", "day", "week":
+	default:
+		http.Error(w, "bucket must be one of hour, day, week", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activity, err := h.service.Activity(r.Context(), projectIDInt, bucket, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(
+		map[string]interface{}{"activity": activity},
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) TopEditedGoods(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limitInt := 10
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		limitInt, err = strconv.Atoi(limit)
+		if err != nil || limitInt <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	goods, err := h.service.TopEditedGoods(r.Context(), projectIDInt, limitInt, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(
+		map[string]interface{}{"goods": goods},
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseOptionalProjectID returns 0 when projectId is absent, which the
+// list and analytics queries treat as "all projects".
+func parseOptionalProjectID(r *http.Request) (int, error) {
+	projectID := r.URL.Query().Get("projectId")
+	if projectID == "" {
+		return 0, nil
+	}
+
+	projectIDInt, err := strconv.Atoi(projectID)
+	if err != nil {
+		return 0, errors.New("projectId must be an integer")
+	}
+	return projectIDInt, nil
+}
+
+// parsePeriod reads the from/to query parameters, defaulting to the last week.
+func parsePeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be an RFC 3339 timestamp")
+		}
+		to = t.UTC()
+	}
+
+	from := to.Add(-defaultAnalyticsPeriod)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be an RFC 3339 timestamp")
+		}
+		from = t.UTC()
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net/http"
+)
+
+// authenticate rejects requests without a valid bearer token. Browsers can't
+// set headers on an EventSource or a WebSocket, so the token may also come in
+// the access_token parameter.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				authorization = "Bearer " + token
+			}
+		}
+
+		if err := h.auth.Check(authorization); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/Hymiside/hezzl-api/pkg/consistency"
+)
+
+const primaryCookie = "read-primary"
+
+// readYourWrites pins a client to the primary for primaryWindow after each of
+// its writes, which is as long as a replica in rotation may lag behind. A
+// client can also ask for it explicitly with the X-Read-Primary header.
+func (h *Handler) readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if h.primaryWindow <= 0 {
+				break
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     primaryCookie,
+				Value:    "1",
+				Path:     "/",
+				MaxAge:   int(math.Ceil(h.primaryWindow.Seconds())),
+				HttpOnly: true,
+			})
+		}
+
+		if _, err := r.Cookie(primaryCookie); err == nil || r.Header.Get("X-Read-Primary") != "" {
+			r = r.WithContext(consistency.WithPrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
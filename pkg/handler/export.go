@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/goodsfile"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// flushEvery is how many goods are written between flushes to the client.
+const flushEvery = 100
+
+// ExportGoods streams the goods as CSV or NDJSON while they are read from the
+// database.
+//
+//	GET /goods/export?projectId=1&format=csv&columns=id,name&removed=false
+func (h *Handler) ExportGoods(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := goodsfile.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns, err := goodsfile.ParseColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	removed, err := parseRemoved(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", goodsfile.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="goods.%s"`, format))
+
+	var (
+		writer     = goodsfile.NewWriter(w, format, columns)
+		flusher, _ = w.(http.Flusher)
+	)
+	if err = h.service.ExportGoods(r.Context(), projectIDInt, removed, func(good models.Good) error {
+		if err := writer.Write(good); err != nil {
+			return err
+		}
+		if writer.Written()%flushEvery == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	}); err != nil {
+		if writer.Written() == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The status is already sent: abort the response so the client can tell
+		// the file is truncated.
+		log.Errorf("error to export goods: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err = writer.Close(); err != nil {
+		log.Errorf("error to export goods: %v", err)
+	}
+}
+
+// parseRemoved reads the optional removed filter, nil means any.
+func parseRemoved(r *http.Request) (*bool, error) {
+	v := r.URL.Query().Get("removed")
+	if v == "" {
+		return nil, nil
+	}
+
+	removed, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, errors.New("removed must be a boolean")
+	}
+	return &removed, nil
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+type graphqlSchema interface {
+	Exec(ctx context.Context, query, operationName string, variables map[string]interface{}) *graphql.Response
+	Subscribe(ctx context.Context, query, operationName string, variables map[string]interface{}) (<-chan interface{}, error)
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL runs a query or a mutation posted as JSON. Subscriptions are
+// served over Server-Sent Events to clients accepting text/event-stream:
+// every result is a "next" event and the end of the stream a "complete" one.
+//
+//	POST /graphql
+//	{"query": "{ project(id: 1) { name counts { total } goods(limit: 5) { name } } }"}
+//
+// An EventSource can only GET, so the operation may also come in the query,
+// operationName and variables parameters of a GET for the event stream.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	stream := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	var data graphqlRequest
+	switch {
+	case r.Method == http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case stream:
+		data.Query = r.URL.Query().Get("query")
+		data.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &data.Variables); err != nil {
+				http.Error(w, "variables must be a JSON object", http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		http.Error(w, "operations must be posted, GET is for event streams", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if data.Query == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+
+	if stream {
+		h.graphqlSSE(w, r, data)
+		return
+	}
+
+	response := h.graphql.Exec(r.Context(), data.Query, data.OperationName, data.Variables)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) graphqlSSE(w http.ResponseWriter, r *http.Request, data graphqlRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	responses, err := h.graphql.Subscribe(r.Context(), data.Query, data.OperationName, data.Variables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case response, ok := <-responses:
+			if !ok {
+				fmt.Fprint(w, "event: complete\ndata:\n\n")
+				flusher.Flush()
+				return
+			}
+			b, err := json.Marshal(response)
+			if err != nil {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "event: next\ndata: %s\n\n", b); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -4,43 +4,108 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Hymiside/hezzl-api/pkg/custerrors"
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type service interface {
-	Goods(ctx context.Context, limit, offset int) (models.GoodsResponse, error)
+	Goods(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error)
+	GoodsAsOf(ctx context.Context, projectID, limit, offset int, asOf time.Time) (models.GoodsResponse, error)
+	Good(ctx context.Context, goodID, projectID int) (models.GoodResponse, error)
+	GoodAsOf(ctx context.Context, goodID, projectID int, asOf time.Time) (models.GoodResponse, error)
 	CreateGood(ctx context.Context, projectID int, name string) (models.Good, error)
 	UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error)
 	DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error)
 	ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.ReprioritizeGoodResponse, error)
+	Activity(ctx context.Context, projectID int, bucket string, from, to time.Time) ([]models.ActivityBucket, error)
+	TopEditedGoods(ctx context.Context, projectID, limit int, from, to time.Time) ([]models.EditedGood, error)
+	SearchGoods(ctx context.Context, projectID int, query string, limit, offset int) (models.SearchResponse, error)
+	SetSearchLanguage(ctx context.Context, projectID int, language string) error
+	ExportGoods(ctx context.Context, projectID int, removed *bool, fn func(models.Good) error) error
+	ImportGoods(ctx context.Context, projectID int, rows []models.ImportRow, dryRun bool) ([]models.ImportRowResult, error)
+	SubmitJob(ctx context.Context, kind string, projectID int, params models.JobParams, input []byte) (models.Job, error)
+	Job(ctx context.Context, jobID int64, projectID int) (models.Job, error)
+	CancelJob(ctx context.Context, jobID int64, projectID int) (models.Job, error)
+	JobResult(ctx context.Context, jobID int64, projectID int) (models.JobResult, error)
+	WriteJobResult(ctx context.Context, jobID int64, projectID int, w io.Writer) error
+	CreateWebhook(ctx context.Context, projectID int, request models.WebhookRequest) (models.Webhook, error)
+	Webhooks(ctx context.Context, projectID int) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, webhookID, projectID int) error
+	WebhookDeliveries(ctx context.Context, projectID int, status string, limit, offset int) ([]models.WebhookDelivery, error)
+	RedeliverWebhook(ctx context.Context, deliveryID int64, projectID int) (models.WebhookDelivery, error)
+}
+
+type authenticator interface {
+	Check(authorization string) error
 }
 
 type Handler struct {
-	service  service
-	validate *validator.Validate
+	service      service
+	stream       streamer
+	graphql      graphqlSchema
+	spec         apiSpec
+	auth         authenticator
+	health       healthChecker
+	validate     *validator.Validate
+
+	// deprecations of the API versions, "" being the unversioned routes.
+	deprecations           map[string]models.ConfigDeprecation
+	deprecatedClients      sync.Map
+	deprecatedClientsCount atomic.Int64
+
+	// primaryWindow is how long a client reads from the primary after a write.
+	primaryWindow time.Duration
 }
 
-func NewHandler(service service) *Handler {
+func NewHandler(service service, stream streamer, graphql graphqlSchema, spec apiSpec, auth authenticator, primaryWindow time.Duration, deprecations map[string]models.ConfigDeprecation, health healthChecker) *Handler {
 	return &Handler{
-		service:  service,
-		validate: validator.New(),
+		service:       service,
+		stream:        stream,
+		graphql:       graphql,
+		spec:          spec,
+		auth:          auth,
+		health:        health,
+		validate:      validator.New(),
+		primaryWindow: primaryWindow,
+		deprecations:  deprecations,
 	}
 }
 
 func (h *Handler) NewRoutes() *chi.Mux {
 	mux := chi.NewRouter()
-	mux.Route("/goods/", func(r chi.Router) {
-		r.Get("/list", h.Goods)
-		r.Post("/create", h.CreateGood)
-		r.Patch("/update", h.UpdateGood)
-		r.Delete("/delete", h.DeleteGood)
-		r.Patch("/reprioritize", h.ReprioritizeGood)
+	mux.Use(h.instrument, h.readYourWrites)
+	// Probes, metrics and docs are left out of authentication.
+	mux.Get("/healthz", h.Healthz)
+	mux.Get("/readyz", h.Readyz)
+	mux.Method(http.MethodGet, "/metrics", promhttp.Handler())
+	mux.Get("/openapi.json", h.OpenAPI)
+	mux.Handle("/docs/*", h.spec.Docs())
+	mux.Group(func(r chi.Router) {
+		r.Use(h.authenticate)
+		r.Group(func(r chi.Router) {
+			r.Use(h.spec.Validate)
+			r.Get("/graphql", h.GraphQL)
+			r.Post("/graphql", h.GraphQL)
+		})
+		// The unversioned routes are the v1 ones, kept for the clients from
+		// before versioning.
+		r.With(h.version(""), h.spec.Validate).Group(h.routesV1)
+		r.Route("/v1", func(r chi.Router) {
+			r.With(h.version("v1"), h.spec.Validate).Group(h.routesV1)
+		})
+		r.Route("/v2", func(r chi.Router) {
+			r.With(h.version("v2"), h.spec.Validate).Group(h.routesV2)
+		})
 	})
 	return mux
 }
@@ -68,7 +133,24 @@ func (h *Handler) Goods(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	goodsResponse, err := h.service.Goods(r.Context(), limitInt, offsetInt)
+	asOf, historical, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var goodsResponse models.GoodsResponse
+	if historical {
+		goodsResponse, err = h.service.GoodsAsOf(r.Context(), projectIDInt, limitInt, offsetInt, asOf)
+	} else {
+		goodsResponse, err = h.service.Goods(r.Context(), projectIDInt, limitInt, offsetInt)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -82,6 +164,60 @@ func (h *Handler) Goods(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) Good(w http.ResponseWriter, r *http.Request) {
+	projectID := r.URL.Query().Get("projectId")
+	if projectID == "" {
+		http.Error(w, "projectId is required", http.StatusBadRequest)
+		return
+	}
+
+	goodID := r.URL.Query().Get("id")
+	if goodID == "" {
+		http.Error(w, "goodId is required", http.StatusBadRequest)
+		return
+	}
+
+	projectIDInt, err := strconv.Atoi(projectID)
+	if err != nil {
+		http.Error(w, "projectId must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	goodIDInt, err := strconv.Atoi(goodID)
+	if err != nil {
+		http.Error(w, "goodId must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	asOf, historical, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var goodResponse models.GoodResponse
+	if historical {
+		goodResponse, err = h.service.GoodAsOf(r.Context(), goodIDInt, projectIDInt, asOf)
+	} else {
+		goodResponse, err = h.service.Good(r.Context(), goodIDInt, projectIDInt)
+	}
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(goodResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (h *Handler) CreateGood(w http.ResponseWriter, r *http.Request) {
 	projectID := r.URL.Query().Get("projectId")
 	if projectID == "" {
@@ -274,3 +410,18 @@ func (h *Handler) ReprioritizeGood(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// parseAsOf reads the optional asOf query parameter. The second return value
+// reports whether the request asks for historical data at all.
+func parseAsOf(r *http.Request) (time.Time, bool, error) {
+	asOf := r.URL.Query().Get("asOf")
+	if asOf == "" {
+		return time.Time{}, false, nil
+	}
+
+	asOfTime, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		return time.Time{}, false, errors.New("asOf must be an RFC 3339 timestamp")
+	}
+	return asOfTime.UTC(), true, nil
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/health"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+type healthChecker interface {
+	Ready(ctx context.Context) models.Readiness
+}
+
+// started is when the process started, reported by the liveness probe.
+var started = time.Now().UTC()
+
+// Healthz tells the process is alive. It checks no dependency: restarting the
+// instance would not bring them back.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(models.Liveness{Status: health.StatusOK, Since: started}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Readyz checks the dependencies. The instance is unavailable, with a 503,
+// only while a critical one fails: without the optional ones it serves
+// degraded.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	readiness := h.health.Ready(r.Context())
+
+	status := http.StatusOK
+	if readiness.Status == health.StatusUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(readiness); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/goodsfile"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+const maxImportSize = 32 << 20
+
+// ImportGoods upserts the goods of a CSV or NDJSON file by external id and
+// reports every row. Rows that fail validation are reported and skipped.
+//
+//	POST /goods/import?projectId=1&format=csv&dryRun=true
+func (h *Handler) ImportGoods(w http.ResponseWriter, r *http.Request) {
+	projectID := r.URL.Query().Get("projectId")
+	if projectID == "" {
+		http.Error(w, "projectId is required", http.StatusBadRequest)
+		return
+	}
+
+	projectIDInt, err := strconv.Atoi(projectID)
+	if err != nil {
+		http.Error(w, "projectId must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := parseImportFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines, err := goodsfile.Read(http.MaxBytesReader(w, r.Body, maxImportSize), format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	valid, failed := goodsfile.Prepare(lines, h.validate.Struct)
+	var results []models.ImportRowResult
+	if len(valid) > 0 {
+		results, err = h.service.ImportGoods(r.Context(), projectIDInt, valid, dryRun)
+		if err != nil {
+			if errors.Is(err, custerrors.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(goodsfile.Report(dryRun, failed, results)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseDryRun(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dryRun")
+	if v == "" {
+		return false, nil
+	}
+
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("dryRun must be a boolean")
+	}
+	return dryRun, nil
+}
+
+// parseImportFormat takes the format from the query, then from the content
+// type of the body.
+func parseImportFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-ndjson" {
+			format = goodsfile.FormatNDJSON
+		}
+	}
+	return goodsfile.ParseFormat(format)
+}
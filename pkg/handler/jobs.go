@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/goodsfile"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// SubmitJob queues a job and answers with it at once. The options of each kind
+// are the ones of its synchronous endpoint:
+//
+//	POST /jobs/submit?kind=export&projectId=1&format=csv&columns=id,name&removed=false
+//	POST /jobs/submit?kind=import&projectId=1&format=csv&dryRun=true    (body: the file)
+//	POST /jobs/submit?kind=purge&projectId=1
+//	POST /jobs/submit?kind=reprioritize&projectId=1                     (body: {"priorities": [...]})
+func (h *Handler) SubmitJob(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		http.Error(w, "kind is required", http.StatusBadRequest)
+		return
+	}
+
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if kind != models.JobExport && projectIDInt == 0 {
+		http.Error(w, "projectId is required", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		params models.JobParams
+		input  []byte
+	)
+	switch kind {
+	case models.JobExport:
+		if params.Format, err = goodsfile.ParseFormat(r.URL.Query().Get("format")); err != nil {
+			break
+		}
+		params.Columns = r.URL.Query().Get("columns")
+		if _, err = goodsfile.ParseColumns(params.Columns); err != nil {
+			break
+		}
+		params.Removed, err = parseRemoved(r)
+	case models.JobImport:
+		if params.DryRun, err = parseDryRun(r); err != nil {
+			break
+		}
+		if params.Format, err = parseImportFormat(r); err != nil {
+			break
+		}
+		input, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	case models.JobPurge:
+	case models.JobReprioritize:
+		var data models.ReprioritizeGoodsRequest
+		if err = json.NewDecoder(r.Body).Decode(&data); err != nil {
+			break
+		}
+		if err = h.validate.Struct(data); err != nil {
+			break
+		}
+		params.Priorities = data.Priorities
+	default:
+		err = fmt.Errorf("kind must be one of %s, %s, %s, %s",
+			models.JobExport, models.JobImport, models.JobPurge, models.JobReprioritize)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.service.SubmitJob(r.Context(), kind, projectIDInt, params, input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Job reports the status and progress of a job.
+//
+//	GET /jobs/status?id=1&projectId=1
+func (h *Handler) Job(w http.ResponseWriter, r *http.Request) {
+	jobID, projectIDInt, err := parseJobID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.service.Job(r.Context(), jobID, projectIDInt)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CancelJob cancels a queued job, or asks a running one to stop.
+//
+//	POST /jobs/cancel?id=1&projectId=1
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	jobID, projectIDInt, err := parseJobID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.service.CancelJob(r.Context(), jobID, projectIDInt)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// JobResult downloads the result of a job that succeeded: the file of an
+// export, the report of the other kinds.
+//
+//	GET /jobs/result?id=1&projectId=1
+func (h *Handler) JobResult(w http.ResponseWriter, r *http.Request) {
+	jobID, projectIDInt, err := parseJobID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.JobResult(r.Context(), jobID, projectIDInt)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, "job not found or not succeeded", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", result.Type)
+	w.Header().Set("Content-Length", strconv.FormatInt(result.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	// The status is sent, a failure can only cut the body short.
+	if err = h.service.WriteJobResult(r.Context(), jobID, projectIDInt, w); err != nil {
+		log.Errorf("error to write job result %d: %v", jobID, err)
+	}
+}
+
+func parseJobID(r *http.Request) (int64, int, error) {
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		return 0, 0, errors.New("id is required")
+	}
+
+	jobIDInt, err := strconv.ParseInt(jobID, 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("id must be an integer")
+	}
+
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return jobIDInt, projectIDInt, nil
+}
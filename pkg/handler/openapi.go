@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"net/http"
+)
+
+type apiSpec interface {
+	JSON() []byte
+	Docs() http.Handler
+	Validate(next http.Handler) http.Handler
+}
+
+// OpenAPI serves the OpenAPI document of this API, which Swagger UI under
+// /docs/ reads.
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(h.spec.JSON()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/health"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/openapi"
+	"github.com/Hymiside/hezzl-api/pkg/stream"
+	"github.com/go-chi/chi/v5"
+	"github.com/graph-gophers/graphql-go"
+)
+
+var testTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testGood() models.Good {
+	return models.Good{ID: 1, ProjectID: 1, Name: "a", Description: "d", Priority: 1, CreatedAt: testTime}
+}
+
+// stubService answers every call with a plausible value, so the responses can
+// be checked against the document.
+type stubService struct{}
+
+func (stubService) Goods(_ context.Context, _, limit, offset int) (models.GoodsResponse, error) {
+	return models.GoodsResponse{
+		Meta:  models.Meta{Limit: limit, Offset: offset, Total: 1},
+		Goods: []models.Good{testGood()},
+	}, nil
+}
+
+func (s stubService) GoodsAsOf(ctx context.Context, projectID, limit, offset int, _ time.Time) (models.GoodsResponse, error) {
+	return s.Goods(ctx, projectID, limit, offset)
+}
+
+func (stubService) Good(context.Context, int, int) (models.GoodResponse, error) {
+	return models.GoodResponse{Good: testGood()}, nil
+}
+
+func (s stubService) GoodAsOf(ctx context.Context, goodID, projectID int, _ time.Time) (models.GoodResponse, error) {
+	return s.Good(ctx, goodID, projectID)
+}
+
+func (stubService) CreateGood(context.Context, int, string) (models.Good, error) {
+	return testGood(), nil
+}
+
+func (stubService) UpdateGood(context.Context, models.Good, int, int) (models.Good, error) {
+	return testGood(), nil
+}
+
+func (stubService) DeleteGood(context.Context, int, int) (models.Good, error) {
+	good := testGood()
+	good.Removed = true
+	return good, nil
+}
+
+func (stubService) ReprioritizeGood(_ context.Context, goodID, _, priority int) ([]models.ReprioritizeGoodResponse, error) {
+	return []models.ReprioritizeGoodResponse{{ID: strconv.Itoa(goodID), Priority: priority}}, nil
+}
+
+func (stubService) Activity(context.Context, int, string, time.Time, time.Time) ([]models.ActivityBucket, error) {
+	return []models.ActivityBucket{{ProjectID: 1, Event: models.EventCreated, Bucket: testTime, Count: 1}}, nil
+}
+
+func (stubService) TopEditedGoods(context.Context, int, int, time.Time, time.Time) ([]models.EditedGood, error) {
+	return []models.EditedGood{{ID: 1, ProjectID: 1, Edits: 2}}, nil
+}
+
+func (stubService) SearchGoods(_ context.Context, _ int, query string, limit, offset int) (models.SearchResponse, error) {
+	var response models.SearchResponse
+	response.Meta.Query, response.Meta.Limit, response.Meta.Offset, response.Meta.Total = query, limit, offset, 0
+	return response, nil
+}
+
+func (stubService) SetSearchLanguage(context.Context, int, string) error {
+	return nil
+}
+
+func (stubService) ExportGoods(_ context.Context, _ int, _ *bool, fn func(models.Good) error) error {
+	return fn(testGood())
+}
+
+func (stubService) ImportGoods(_ context.Context, _ int, rows []models.ImportRow, _ bool) ([]models.ImportRowResult, error) {
+	results := make([]models.ImportRowResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, models.ImportRowResult{Row: row.Row, ExternalID: row.Good.ExternalID, Status: models.ImportCreated, ID: 1})
+	}
+	return results, nil
+}
+
+func testJob(kind string, projectID int, params models.JobParams) models.Job {
+	return models.Job{ID: 1, Kind: kind, ProjectID: projectID, Params: params, Status: models.JobQueued, CreatedAt: testTime}
+}
+
+func (stubService) SubmitJob(_ context.Context, kind string, projectID int, params models.JobParams, _ []byte) (models.Job, error) {
+	return testJob(kind, projectID, params), nil
+}
+
+func (stubService) Job(_ context.Context, _ int64, projectID int) (models.Job, error) {
+	return testJob(models.JobPurge, projectID, models.JobParams{}), nil
+}
+
+func (stubService) CancelJob(_ context.Context, _ int64, projectID int) (models.Job, error) {
+	job := testJob(models.JobPurge, projectID, models.JobParams{})
+	job.Status = models.JobCanceled
+	return job, nil
+}
+
+const testJobResult = `{"purged":1}`
+
+func (stubService) JobResult(context.Context, int64, int) (models.JobResult, error) {
+	return models.JobResult{Type: "application/json", Size: int64(len(testJobResult))}, nil
+}
+
+func (stubService) WriteJobResult(_ context.Context, _ int64, _ int, w io.Writer) error {
+	_, err := io.WriteString(w, testJobResult)
+	return err
+}
+
+func testWebhook(projectID int) models.Webhook {
+	return models.Webhook{ID: 1, ProjectID: projectID, URL: "https://example.com/hook", Events: []string{models.EventCreated}, CreatedAt: testTime}
+}
+
+func testDelivery(projectID int) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		ID:            1,
+		WebhookID:     1,
+		ProjectID:     projectID,
+		Event:         models.EventCreated,
+		Payload:       json.RawMessage(`{"event":"created"}`),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: testTime,
+		CreatedAt:     testTime,
+	}
+}
+
+func (stubService) CreateWebhook(_ context.Context, projectID int, _ models.WebhookRequest) (models.Webhook, error) {
+	return testWebhook(projectID), nil
+}
+
+func (stubService) Webhooks(_ context.Context, projectID int) ([]models.Webhook, error) {
+	return []models.Webhook{testWebhook(projectID)}, nil
+}
+
+func (stubService) DeleteWebhook(context.Context, int, int) error {
+	return nil
+}
+
+func (stubService) WebhookDeliveries(_ context.Context, projectID int, _ string, _, _ int) ([]models.WebhookDelivery, error) {
+	return []models.WebhookDelivery{testDelivery(projectID)}, nil
+}
+
+func (stubService) RedeliverWebhook(_ context.Context, _ int64, projectID int) (models.WebhookDelivery, error) {
+	return testDelivery(projectID), nil
+}
+
+type stubGraphQL struct{}
+
+func (stubGraphQL) Exec(context.Context, string, string, map[string]interface{}) *graphql.Response {
+	return &graphql.Response{Data: json.RawMessage(`{"project":null}`)}
+}
+
+func (stubGraphQL) Subscribe(context.Context, string, string, map[string]interface{}) (<-chan interface{}, error) {
+	responses := make(chan interface{})
+	close(responses)
+	return responses, nil
+}
+
+type stubAuth struct{}
+
+func (stubAuth) Check(string) error {
+	return nil
+}
+
+type stubHealth struct{}
+
+func (stubHealth) Ready(context.Context) models.Readiness {
+	return models.Readiness{
+		Status:       health.StatusOK,
+		Dependencies: []models.DependencyStatus{{Name: "postgres", Critical: true, Status: health.StatusOK, LatencyMs: 1}},
+	}
+}
+
+// operationRequest is one request of an operation and the status it answers
+// with. Streams are sent canceled, so they end after their headers.
+type operationRequest struct {
+	method, path, query string
+	contentType, body   string
+	status              int
+	stream              bool
+}
+
+// unversionedRequests are the routes out of the API versions.
+var unversionedRequests = []operationRequest{
+	{method: http.MethodGet, path: "/healthz", status: http.StatusOK},
+	{method: http.MethodGet, path: "/readyz", status: http.StatusOK},
+	{method: http.MethodGet, path: "/metrics", status: http.StatusOK},
+	{method: http.MethodGet, path: "/openapi.json", status: http.StatusOK},
+	{method: http.MethodGet, path: "/graphql", query: "query=subscription{goodChanged{event}}", status: http.StatusOK, stream: true},
+	{method: http.MethodPost, path: "/graphql", contentType: "application/json", body: `{"query":"{ project(id: 1) { name } }"}`, status: http.StatusOK},
+}
+
+// versionedRequests are sent under every version, the unversioned one
+// included.
+var versionedRequests = []operationRequest{
+	{method: http.MethodGet, path: "/goods/list", query: "projectId=1&limit=5", status: http.StatusOK},
+	{method: http.MethodGet, path: "/goods/get", query: "projectId=1&id=1", status: http.StatusOK},
+	{method: http.MethodGet, path: "/goods/search", query: "q=a&projectId=1", status: http.StatusOK},
+	{method: http.MethodGet, path: "/goods/stream", query: "projectId=1", status: http.StatusOK, stream: true},
+	{method: http.MethodGet, path: "/goods/export", query: "projectId=1&format=csv", status: http.StatusOK},
+	{method: http.MethodPost, path: "/goods/import", query: "projectId=1", contentType: "text/csv", body: "externalId,name\nx1,a\n", status: http.StatusOK},
+	{method: http.MethodPost, path: "/goods/create", query: "projectId=1", contentType: "application/json", body: `{"name":"a"}`, status: http.StatusOK},
+	{method: http.MethodPatch, path: "/goods/update", query: "projectId=1&id=1", contentType: "application/json", body: `{"description":"d"}`, status: http.StatusOK},
+	{method: http.MethodDelete, path: "/goods/delete", query: "projectId=1&id=1", status: http.StatusOK},
+	{method: http.MethodPatch, path: "/goods/reprioritize", query: "projectId=1&id=1", contentType: "application/json", body: `{"newPriority":2}`, status: http.StatusOK},
+	{method: http.MethodPatch, path: "/projects/search-language", query: "projectId=1", contentType: "application/json", body: `{"language":"english"}`, status: http.StatusOK},
+	{method: http.MethodPost, path: "/jobs/submit", query: "kind=purge&projectId=1", status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/jobs/status", query: "id=1&projectId=1", status: http.StatusOK},
+	{method: http.MethodPost, path: "/jobs/cancel", query: "id=1&projectId=1", status: http.StatusOK},
+	{method: http.MethodGet, path: "/jobs/result", query: "id=1&projectId=1", status: http.StatusOK},
+	{method: http.MethodPost, path: "/webhooks/create", query: "projectId=1", contentType: "application/json", body: `{"url":"https://example.com/hook","secret":"0123456789abcdef"}`, status: http.StatusOK},
+	{method: http.MethodGet, path: "/webhooks/list", query: "projectId=1", status: http.StatusOK},
+	{method: http.MethodDelete, path: "/webhooks/delete", query: "projectId=1&id=1", status: http.StatusOK},
+	{method: http.MethodGet, path: "/webhooks/deliveries", query: "projectId=1&status=pending", status: http.StatusOK},
+	{method: http.MethodPost, path: "/webhooks/redeliver", query: "projectId=1&id=1", status: http.StatusOK},
+	{method: http.MethodGet, path: "/analytics/activity", query: "projectId=1&bucket=hour", status: http.StatusOK},
+	{method: http.MethodGet, path: "/analytics/top-edited", query: "projectId=1&limit=3", status: http.StatusOK},
+}
+
+// operationRequests lists a request per operation of the document.
+func operationRequests() []operationRequest {
+	requests := append([]operationRequest{}, unversionedRequests...)
+	for _, version := range []string{"", "/v1", "/v2"} {
+		for _, request := range versionedRequests {
+			request.path = version + request.path
+			if request.path == "/v2/goods/create" {
+				request.status = http.StatusCreated
+			}
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func newTestRoutes(t *testing.T) (*openapi.Spec, *chi.Mux) {
+	t.Helper()
+	spec, err := openapi.New(true)
+	if err != nil {
+		t.Fatalf("openapi.New: %v", err)
+	}
+	hub := stream.NewHub(models.ConfigStream{History: 10, Buffer: 10})
+	h := NewHandler(stubService{}, hub, stubGraphQL{}, spec, stubAuth{}, 0, nil, stubHealth{})
+	return spec, h.NewRoutes()
+}
+
+func TestRoutesMatchTheDocument(t *testing.T) {
+	spec, routes := newTestRoutes(t)
+	if err := spec.CheckRoutes(routes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOperationsMatchTheDocument(t *testing.T) {
+	spec, routes := newTestRoutes(t)
+	// The probes, metrics and document are not validated by the routes.
+	handler := spec.Validate(routes)
+
+	sent := map[string]bool{}
+	for _, request := range operationRequests() {
+		sent[request.method+" "+request.path] = true
+
+		t.Run(request.method+" "+request.path, func(t *testing.T) {
+			var body io.Reader
+			if request.body != "" {
+				body = strings.NewReader(request.body)
+			}
+			r := httptest.NewRequest(request.method, request.path+"?"+request.query, body)
+			if request.contentType != "" {
+				r.Header.Set("Content-Type", request.contentType)
+			}
+			if request.stream {
+				r.Header.Set("Accept", "text/event-stream")
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != request.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, request.status, w.Body)
+			}
+		})
+	}
+
+	var document struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec.JSON(), &document); err != nil {
+		t.Fatalf("unmarshal document: %v", err)
+	}
+	var missing []string
+	for path, item := range document.Paths {
+		for method := range item {
+			key := strings.ToUpper(method) + " " + path
+			if method != "parameters" && !strings.HasPrefix(method, "x-") && !sent[key] {
+				missing = append(missing, key)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("operations without a request: %s", strings.Join(missing, ", "))
+	}
+}
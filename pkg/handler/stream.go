@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/Hymiside/hezzl-api/pkg/stream"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// streamHeartbeat keeps idle streams open through proxies.
+	streamHeartbeat = 15 * time.Second
+
+	// streamWriteTimeout bounds a write to a WebSocket client, a stuck one is
+	// dropped like a slow one.
+	streamWriteTimeout = 10 * time.Second
+)
+
+type streamer interface {
+	Subscribe(projectID int, lastEventID string) (*stream.Subscription, []models.StreamEvent, bool)
+	Unsubscribe(sub *stream.Subscription)
+}
+
+// StreamGoods pushes the goods changes of the project as they happen, over
+// Server-Sent Events or, when the request is an upgrade, over a WebSocket.
+// Without projectId every project is streamed.
+//
+//	GET /goods/stream?projectId=1&lastEventId=...
+//
+// Clients resume with the Last-Event-ID header (sent by EventSource on its own)
+// or the lastEventId parameter. A "reset" event means the missed events are
+// not known anymore and the client must reload the goods; an "overflow" event
+// closes a client that could not keep up, it should reconnect and resume.
+func (h *Handler) StreamGoods(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{
+			// The stream is read only and scoped like the other endpoints, any
+			// origin may read it.
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				h.streamWebSocket(ws, projectIDInt, lastEventID)
+			},
+		}.ServeHTTP(w, r)
+		return
+	}
+	h.streamSSE(w, r, projectIDInt, lastEventID)
+}
+
+func (h *Handler) streamSSE(w http.ResponseWriter, r *http.Request, projectID int, lastEventID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, missed, complete := h.stream.Subscribe(projectID, lastEventID)
+	defer h.stream.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e models.StreamEvent) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if e.ID != "" {
+			if _, err = fmt.Fprintf(w, "id: %s\n", e.ID); err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, b)
+		return err
+	}
+
+	h.pump(r.Context().Done(), sub, missed, complete, write, func() error {
+		_, err := fmt.Fprint(w, ": ping\n\n")
+		return err
+	}, flusher.Flush)
+}
+
+func (h *Handler) streamWebSocket(ws *websocket.Conn, projectID int, lastEventID string) {
+	defer ws.Close()
+
+	sub, missed, complete := h.stream.Subscribe(projectID, lastEventID)
+	defer h.stream.Unsubscribe(sub)
+
+	// Nothing is expected from the client, reading only tells when it leaves.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+		}
+	}()
+
+	write := func(e models.StreamEvent) error {
+		ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return websocket.JSON.Send(ws, e)
+	}
+
+	h.pump(closed, sub, missed, complete, write, func() error {
+		return write(models.StreamEvent{Event: "ping"})
+	}, func() {})
+}
+
+// pump writes the missed events, then the live ones until done or overflow.
+func (h *Handler) pump(
+	done <-chan struct{},
+	sub *stream.Subscription,
+	missed []models.StreamEvent,
+	complete bool,
+	write func(models.StreamEvent) error,
+	ping func() error,
+	flush func(),
+) {
+	if !complete {
+		// The client reloads the goods, which already hold the missed changes.
+		missed = []models.StreamEvent{{Event: models.StreamReset}}
+	}
+	for _, e := range missed {
+		if err := write(e); err != nil {
+			return
+		}
+	}
+	flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-sub.Overflow:
+			log.Warnf("goods stream client of project %d fell behind, dropped", sub.ProjectID())
+			write(models.StreamEvent{Event: models.StreamOverflow})
+			flush()
+			return
+		case e := <-sub.Events:
+			err = write(e)
+		case <-heartbeat.C:
+			err = ping()
+		}
+		if err != nil {
+			return
+		}
+		flush()
+	}
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+// The v2 goods handlers take the requests of v1 and correct its responses:
+// goods are named like the other resources, a delete returns the removed
+// good, ids are numbers, a missing good is a 404 and a created one a 201.
+
+func (h *Handler) GoodsV2(w http.ResponseWriter, r *http.Request) {
+	limitInt, offsetInt, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asOf, historical, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	projectIDInt, err := parseOptionalProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var goodsResponse models.GoodsResponse
+	if historical {
+		goodsResponse, err = h.service.GoodsAsOf(r.Context(), projectIDInt, limitInt, offsetInt, asOf)
+	} else {
+		goodsResponse, err = h.service.Goods(r.Context(), projectIDInt, limitInt, offsetInt)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	response := models.GoodsResponseV2{Meta: goodsResponse.Meta, Goods: make([]models.GoodV2, 0, len(goodsResponse.Goods))}
+	for _, good := range goodsResponse.Goods {
+		response.Goods = append(response.Goods, toGoodV2(good))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GoodV2(w http.ResponseWriter, r *http.Request) {
+	goodIDInt, projectIDInt, err := parseGoodID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asOf, historical, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var goodResponse models.GoodResponse
+	if historical {
+		goodResponse, err = h.service.GoodAsOf(r.Context(), goodIDInt, projectIDInt, asOf)
+	} else {
+		goodResponse, err = h.service.Good(r.Context(), goodIDInt, projectIDInt)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(models.GoodResponseV2{Meta: goodResponse.Meta, Good: toGoodV2(goodResponse.Good)}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) CreateGoodV2(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data models.CreateGoodRequest
+	if err = json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = h.validate.Struct(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	good, err := h.service.CreateGood(r.Context(), projectIDInt, data.Name)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(toGoodV2(good)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) UpdateGoodV2(w http.ResponseWriter, r *http.Request) {
+	goodIDInt, projectIDInt, err := parseGoodID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data models.Good
+	if err = json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	goodUpdated, err := h.service.UpdateGood(r.Context(), data, goodIDInt, projectIDInt)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(toGoodV2(goodUpdated)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) DeleteGoodV2(w http.ResponseWriter, r *http.Request) {
+	goodIDInt, projectIDInt, err := parseGoodID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	good, err := h.service.DeleteGood(r.Context(), goodIDInt, projectIDInt)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(toGoodV2(good)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) ReprioritizeGoodV2(w http.ResponseWriter, r *http.Request) {
+	goodIDInt, projectIDInt, err := parseGoodID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data models.ReprioritizeGoodRequest
+	if err = json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = h.validate.Struct(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	priorities, err := h.service.ReprioritizeGood(r.Context(), goodIDInt, projectIDInt, data.Priority)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	response := models.ReprioritizeGoodResponseV2{Priorities: make([]models.PriorityV2, 0, len(priorities))}
+	for _, p := range priorities {
+		id, _ := strconv.Atoi(p.ID)
+		response.Priorities = append(response.Priorities, models.PriorityV2{ID: id, Priority: p.Priority})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func toGoodV2(good models.Good) models.GoodV2 {
+	return models.GoodV2{
+		ID:          good.ID,
+		ProjectID:   good.ProjectID,
+		Name:        good.Name,
+		Description: good.Description,
+		Priority:    good.Priority,
+		Removed:     good.Removed,
+		CreatedAt:   good.CreatedAt,
+	}
+}
+
+// statusOf is the status of a failed call to the service.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, custerrors.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, custerrors.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, custerrors.ErrStale):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func parseGoodID(r *http.Request) (int, int, error) {
+	goodID := r.URL.Query().Get("id")
+	if goodID == "" {
+		return 0, 0, errors.New("id is required")
+	}
+
+	goodIDInt, err := strconv.Atoi(goodID)
+	if err != nil {
+		return 0, 0, errors.New("id must be an integer")
+	}
+
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return goodIDInt, projectIDInt, nil
+}
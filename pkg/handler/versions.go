@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Hymiside/hezzl-api/pkg/metrics"
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// latestVersion is where the clients of a deprecated version are sent.
+const latestVersion = "v2"
+
+// maxDeprecatedClients bounds the clients remembered as logged for using a
+// deprecated version.
+const maxDeprecatedClients = 1000
+
+// routesV1 are the routes as they were before versioning. Their contracts are
+// frozen: a fix that changes a response goes to v2. Routes are not nested in
+// subrouters, so the middlewares of a version run once the route is known.
+func (h *Handler) routesV1(r chi.Router) {
+	r.Get("/goods/list", h.Goods)
+	r.Get("/goods/get", h.Good)
+	r.Get("/goods/search", h.SearchGoods)
+	r.Get("/goods/stream", h.StreamGoods)
+	r.Get("/goods/export", h.ExportGoods)
+	r.Post("/goods/import", h.ImportGoods)
+	r.Post("/goods/create", h.CreateGood)
+	r.Patch("/goods/update", h.UpdateGood)
+	r.Delete("/goods/delete", h.DeleteGood)
+	r.Patch("/goods/reprioritize", h.ReprioritizeGood)
+	h.routesShared(r)
+}
+
+// routesV2 correct the contracts of the goods and share the other v1 routes.
+func (h *Handler) routesV2(r chi.Router) {
+	r.Get("/goods/list", h.GoodsV2)
+	r.Get("/goods/get", h.GoodV2)
+	r.Get("/goods/search", h.SearchGoods)
+	r.Get("/goods/stream", h.StreamGoods)
+	r.Get("/goods/export", h.ExportGoods)
+	r.Post("/goods/import", h.ImportGoods)
+	r.Post("/goods/create", h.CreateGoodV2)
+	r.Patch("/goods/update", h.UpdateGoodV2)
+	r.Delete("/goods/delete", h.DeleteGoodV2)
+	r.Patch("/goods/reprioritize", h.ReprioritizeGoodV2)
+	h.routesShared(r)
+}
+
+// routesShared are the same in every version.
+func (h *Handler) routesShared(r chi.Router) {
+	r.Patch("/projects/search-language", h.SetSearchLanguage)
+
+	r.Post("/jobs/submit", h.SubmitJob)
+	r.Get("/jobs/status", h.Job)
+	r.Post("/jobs/cancel", h.CancelJob)
+	r.Get("/jobs/result", h.JobResult)
+
+	r.Post("/webhooks/create", h.CreateWebhook)
+	r.Get("/webhooks/list", h.Webhooks)
+	r.Delete("/webhooks/delete", h.DeleteWebhook)
+	r.Get("/webhooks/deliveries", h.WebhookDeliveries)
+	r.Post("/webhooks/redeliver", h.RedeliverWebhook)
+
+	r.Get("/analytics/activity", h.Activity)
+	r.Get("/analytics/top-edited", h.TopEditedGoods)
+}
+
+// version counts the requests per version and route. The responses of a
+// deprecated version carry the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers, with a link to the same route in the latest version.
+func (h *Handler) version(version string) func(http.Handler) http.Handler {
+	label := version
+	if label == "" {
+		label = "unversioned"
+	}
+	deprecation, deprecated := h.deprecations[version]
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if deprecated {
+				w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.Since.Unix()))
+				if !deprecation.Sunset.IsZero() {
+					w.Header().Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+				}
+				w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor(version, r.URL.Path)))
+				h.logDeprecated(label, r)
+			}
+
+			next.ServeHTTP(w, r)
+			metrics.APIRequests.WithLabelValues(label, chi.RouteContext(r.Context()).RoutePattern()).Inc()
+		})
+	}
+}
+
+// logDeprecated logs the first request of each client, told apart by its
+// User-Agent, to a deprecated version, so they can be reached before the
+// sunset.
+func (h *Handler) logDeprecated(version string, r *http.Request) {
+	key := version + " " + r.UserAgent()
+	if _, ok := h.deprecatedClients.Load(key); ok || h.deprecatedClientsCount.Load() >= maxDeprecatedClients {
+		return
+	}
+	if _, ok := h.deprecatedClients.LoadOrStore(key, struct{}{}); ok {
+		return
+	}
+	h.deprecatedClientsCount.Add(1)
+
+	log.Warnf("deprecated %s api used by %q from %s: %s %s", version, r.UserAgent(), r.RemoteAddr, r.Method, r.URL.Path)
+}
+
+// successor is the path of a route in the latest version.
+func successor(version, path string) string {
+	if version != "" {
+		path = strings.TrimPrefix(path, "/"+version)
+	}
+	return "/" + latestVersion + path
+}
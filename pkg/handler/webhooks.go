@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+// CreateWebhook subscribes a URL to the goods events of the project. Every
+// delivery is signed with the secret, see webhook.Sign.
+//
+//	POST /webhooks/create?projectId=1
+//	{"url": "https://example.com/hook", "secret": "...", "events": ["created", "removed"]}
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data models.WebhookRequest
+	if err = json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = h.validate.Struct(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.service.CreateWebhook(r.Context(), projectIDInt, data)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(webhook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhooks, err := h.service.Webhooks(r.Context(), projectIDInt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": webhooks}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhookID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.service.DeleteWebhook(r.Context(), webhookID, projectIDInt); err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(
+		map[string]interface{}{"id": webhookID, "projectId": projectIDInt, "removed": true},
+	); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// WebhookDeliveries lists the delivery log of the project, newest first.
+//
+//	GET /webhooks/deliveries?projectId=1&status=failed&limit=10&offset=0
+func (h *Handler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed:
+	default:
+		http.Error(w, "status must be one of pending, succeeded, failed", http.StatusBadRequest)
+		return
+	}
+
+	limitInt, offsetInt, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.service.WebhookDeliveries(r.Context(), projectIDInt, status, limitInt, offsetInt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": deliveries}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RedeliverWebhook sends a failed delivery again.
+//
+//	POST /webhooks/redeliver?projectId=1&id=42
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	projectIDInt, err := parseProjectID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := h.service.RedeliverWebhook(r.Context(), deliveryID, projectIDInt)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			http.Error(w, "failed delivery not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(delivery); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseProjectID(r *http.Request) (int, error) {
+	projectID := r.URL.Query().Get("projectId")
+	if projectID == "" {
+		return 0, errors.New("projectId is required")
+	}
+
+	projectIDInt, err := strconv.Atoi(projectID)
+	if err != nil {
+		return 0, errors.New("projectId must be an integer")
+	}
+	return projectIDInt, nil
+}
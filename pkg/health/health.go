@@ -0,0 +1,121 @@
+// Package health checks the dependencies of the service, for the readiness
+// probe and the check subcommand.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+const (
+	StatusOK = "ok"
+	// StatusDegraded means an optional dependency is failing: the service
+	// still serves, without what that dependency brings.
+	StatusDegraded = "degraded"
+	// StatusUnavailable means a critical dependency is failing.
+	StatusUnavailable = "unavailable"
+
+	StatusFailing = "failing"
+)
+
+// Check is a dependency and how to check it.
+type Check struct {
+	Name string
+	// Critical dependencies make the service unavailable when they fail, the
+	// others degrade it.
+	Critical bool
+	Check    func(ctx context.Context) error
+	// State reports the state of the breaker of the dependency, if it has one.
+	State func() string
+}
+
+// Checker runs all the checks at once, each bounded by the timeout. The
+// result is reused for cacheFor, so probes can't pile up on the dependencies.
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	cacheFor time.Duration
+
+	mu        sync.Mutex
+	last      models.Readiness
+	checkedAt time.Time
+}
+
+func New(cfg models.ConfigHealth, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: cfg.Timeout, cacheFor: cfg.CacheFor}
+}
+
+// Ready runs the checks, or returns their last result if it is recent enough.
+// Concurrent callers wait for a single run.
+func (c *Checker) Ready(ctx context.Context) models.Readiness {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.cacheFor {
+		return c.last
+	}
+
+	// The result is shared, a caller going away must not fail it for the others.
+	c.last = c.run(context.WithoutCancel(ctx))
+	c.checkedAt = time.Now()
+	return c.last
+}
+
+func (c *Checker) run(ctx context.Context) models.Readiness {
+	readiness := models.Readiness{Status: StatusOK, Dependencies: make([]models.DependencyStatus, len(c.checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			readiness.Dependencies[i] = c.check(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, dependency := range readiness.Dependencies {
+		switch {
+		case dependency.Status == StatusOK:
+		case dependency.Critical:
+			readiness.Status = StatusUnavailable
+		case readiness.Status == StatusOK:
+			readiness.Status = StatusDegraded
+		}
+	}
+	return readiness
+}
+
+func (c *Checker) check(ctx context.Context, check Check) models.DependencyStatus {
+	status := models.DependencyStatus{Name: check.Name, Critical: check.Critical, Status: StatusOK}
+	if check.State != nil {
+		status.State = check.State()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// The check runs aside, so the timeout holds even if it ignores ctx.
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no answer within %s", c.timeout)
+	}
+	status.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		status.Status = StatusFailing
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,62 @@
+// Package metrics holds the Prometheus metrics of the service, served at
+// /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// APIRequests counts the requests to each route of each API version, to see
+// what the clients of the deprecated versions still use.
+var APIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "api_requests_total",
+	Help: "Requests to the HTTP API by version and route.",
+}, []string{"version", "route"})
+
+// HTTP requests by route pattern, so that ids in paths don't make new series.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of the HTTP requests by method and route, streams included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// GoodsCache counts the lookups of Service.Goods in the cache. A miss builds
+// the index of the project, an error falls back to Postgres.
+var GoodsCache = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "goods_cache_requests_total",
+	Help: "Lookups of goods pages in the cache by result: hit, miss or error.",
+}, []string{"result"})
+
+var PublishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "queue_publish_errors_total",
+	Help: "Messages that could not be published to NATS by subject.",
+}, []string{"subject"})
+
+// The log batcher buffers the goods events and writes them to ClickHouse in
+// batches.
+var (
+	LogBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "log_batcher_backlog",
+		Help: "Logs waiting to be written to ClickHouse.",
+	})
+
+	LogFlushDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "log_batcher_flush_duration_seconds",
+		Help:    "Duration of the writes of log batches to ClickHouse by result: ok or error.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"result"})
+
+	LogFlushSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "log_batcher_flush_size",
+		Help:    "Logs per batch written to ClickHouse.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+)
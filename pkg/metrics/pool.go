@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolLabels = []string{"pool"}
+
+	poolTotalConns = prometheus.NewDesc("postgres_pool_total_conns",
+		"Connections of the pool, idle, acquired or being opened.", poolLabels, nil)
+	poolAcquiredConns = prometheus.NewDesc("postgres_pool_acquired_conns",
+		"Connections in use.", poolLabels, nil)
+	poolIdleConns = prometheus.NewDesc("postgres_pool_idle_conns",
+		"Idle connections.", poolLabels, nil)
+	poolMaxConns = prometheus.NewDesc("postgres_pool_max_conns",
+		"Maximum connections of the pool.", poolLabels, nil)
+	poolAcquires = prometheus.NewDesc("postgres_pool_acquires_total",
+		"Connections acquired from the pool.", poolLabels, nil)
+	poolEmptyAcquires = prometheus.NewDesc("postgres_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection.", poolLabels, nil)
+	poolCanceledAcquires = prometheus.NewDesc("postgres_pool_canceled_acquires_total",
+		"Acquires canceled before getting a connection.", poolLabels, nil)
+	poolAcquireDuration = prometheus.NewDesc("postgres_pool_acquire_duration_seconds_total",
+		"Time spent acquiring connections.", poolLabels, nil)
+)
+
+// poolCollector reads the stats of pgx pools when scraped. They are the pgx
+// counterpart of the database/sql DBStats.
+type poolCollector struct {
+	pools map[string]*pgxpool.Pool
+}
+
+// NewPoolCollector collects the stats of the pools, labeled by their names.
+func NewPoolCollector(pools map[string]*pgxpool.Pool) prometheus.Collector {
+	return poolCollector{pools: pools}
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolTotalConns
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolMaxConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolCanceledAcquires
+	ch <- poolAcquireDuration
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, pool := range c.pools {
+		stat := pool.Stat()
+		ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolCanceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds(), name)
+	}
+}
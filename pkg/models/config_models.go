@@ -1,17 +1,38 @@
 package models
 
+import "time"
+
 type ConfigPostgres struct {
 	Host     string
 	Port     string
 	User     string
 	Password string
 	Database string
+
+	MaxConns               int32
+	MinConns               int32
+	MaxConnIdleTime        time.Duration
+	MaxConnLifetime        time.Duration
+	QueryTimeout           time.Duration
+	StatementCacheCapacity int
+}
+
+type ConfigReplicas struct {
+	// Hosts are host:port addresses of the replicas; the other settings are
+	// taken from the primary.
+	Hosts         []string
+	MaxLag        time.Duration
+	CheckInterval time.Duration
 }
 
 type ConfigClickhouse struct {
 	Host string
 	Port string
 	Database string
+
+	// AnalyticsTimeout bounds the analytics queries, which run outside the
+	// breaker of the log writes.
+	AnalyticsTimeout time.Duration
 }
 
 type ConfigServer struct {
@@ -24,7 +45,73 @@ type ConfigRedis struct {
 	Port string
 }
 
+type ConfigLocalCache struct {
+	Enabled bool
+	Size    int
+	TTL     time.Duration
+}
+
 type ConfigNats struct {
 	Host string
 	Port string
 }
+
+type ConfigCDC struct {
+	Enabled     bool
+	Slot        string
+	Publication string
+}
+
+type ConfigBreaker struct {
+	Threshold   int
+	Retries     int
+	OpenTimeout time.Duration
+	CallTimeout time.Duration
+	Backoff     time.Duration
+}
+
+type ConfigJobs struct {
+	Workers      int
+	PollInterval time.Duration
+	// StaleAfter is how long a running job may go without a heartbeat before
+	// another worker takes it over.
+	StaleAfter time.Duration
+}
+
+type ConfigWebhooks struct {
+	Workers      int
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+	// Backoff is the delay before the first retry, doubled for each next one
+	// up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// AllowPrivate lets webhooks reach loopback and private addresses, which
+	// are refused by default so subscribers can't probe internal services.
+	AllowPrivate bool
+}
+
+type ConfigStream struct {
+	// History is how many events are kept for clients that resume.
+	History int
+	// Buffer is how many events a client may lag behind before it is dropped.
+	Buffer int
+}
+
+// ConfigDeprecation deprecates an API version. Its routes keep working until
+// Sunset, a zero Sunset meaning no date is set yet.
+type ConfigDeprecation struct {
+	Since  time.Time
+	Sunset time.Time
+}
+
+type ConfigHealth struct {
+	// Timeout bounds each dependency check.
+	Timeout time.Duration
+	// CacheFor is how long the result of the checks is reused.
+	CacheFor time.Duration
+	// MaxLogBacklog is how many logs may wait for ClickHouse before the log
+	// batcher counts as backed up.
+	MaxLogBacklog int
+}
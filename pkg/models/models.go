@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Project struct {
 	ID        string    `json:"id,omitempty"`
@@ -9,13 +12,14 @@ type Project struct {
 }
 
 type Good struct {
-	ID          int    	  `json:"id"`
-	ProjectID   int   	  `json:"project"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Priority    int       `json:"priority"`
-	Removed     bool      `json:"removed"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          int    	  `json:"id" db:"id"`
+	ProjectID   int   	  `json:"project" db:"project_id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Priority    int       `json:"priority" db:"priority"`
+	Removed     bool      `json:"removed" db:"removed"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	Version     int64     `json:"-" db:"version"`
 }
 
 type CreateGoodRequest struct {
@@ -32,13 +36,386 @@ type ReprioritizeGoodResponse struct {
 }
 
 type Meta struct {
-	Limit   int `json:"limit"`
-	Ofset   int `json:"offset"`
-	Total   int `json:"total"`
-	Removed int `json:"removed"`
+	Limit      int        `json:"limit"`
+	Offset     int        `json:"offset"`
+	Total      int        `json:"total"`
+	Removed    int        `json:"removed"`
+	Historical bool       `json:"historical,omitempty"`
+	AsOf       *time.Time `json:"asOf,omitempty"`
+}
+
+type GoodMeta struct {
+	Historical bool       `json:"historical"`
+	AsOf       *time.Time `json:"asOf,omitempty"`
 }
 
 type GoodsResponse struct {
 	Meta  Meta	`json:"meta"`
 	Goods []Good `json:"goods"`
 }
+
+type GoodResponse struct {
+	Meta GoodMeta `json:"meta"`
+	Good Good     `json:"good"`
+}
+
+// GoodV2 is a good in the v2 API, with its fields named like the ones of every
+// other resource.
+type GoodV2 struct {
+	ID          int       `json:"id"`
+	ProjectID   int       `json:"projectId"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Priority    int       `json:"priority"`
+	Removed     bool      `json:"removed"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type GoodsResponseV2 struct {
+	Meta  Meta     `json:"meta"`
+	Goods []GoodV2 `json:"goods"`
+}
+
+type GoodResponseV2 struct {
+	Meta GoodMeta `json:"meta"`
+	Good GoodV2   `json:"good"`
+}
+
+type PriorityV2 struct {
+	ID       int `json:"id"`
+	Priority int `json:"priority"`
+}
+
+type ReprioritizeGoodResponseV2 struct {
+	Priorities []PriorityV2 `json:"priorities"`
+}
+
+const (
+	EventCreated       = "created"
+	EventUpdated       = "updated"
+	EventRemoved       = "removed"
+	EventReprioritized = "reprioritized"
+)
+
+// Log is a change of a good. OccurredAt is set when the change is made, not
+// when the log reaches ClickHouse, and Version orders the changes made within
+// the same microsecond.
+type Log struct {
+	Event string `json:"event"`
+	Good
+	Version    int64     `json:"version"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// ProjectLog is a change of a project, published by change data capture.
+type ProjectLog struct {
+	Event string `json:"event"`
+	Project
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+type ActivityBucket struct {
+	ProjectID int       `json:"projectId"`
+	Event     string    `json:"event"`
+	Bucket    time.Time `json:"bucket"`
+	Count     int       `json:"count"`
+}
+
+type EditedGood struct {
+	ID        int `json:"id"`
+	ProjectID int `json:"projectId"`
+	Edits     int `json:"edits"`
+}
+
+const (
+	DiffMissing = "missing"
+	DiffExtra   = "extra"
+	DiffChanged = "changed"
+)
+
+type GoodDiff struct {
+	ID      int      `json:"id"`
+	Kind    string   `json:"kind"`
+	Fields  []string `json:"fields,omitempty"`
+	Current *Good    `json:"current,omitempty"`
+	Rebuilt *Good    `json:"rebuilt,omitempty"`
+}
+
+// Reasons a good of the log is not restored.
+const (
+	SkipNoName    = "no name"
+	SkipNoProject = "no project"
+)
+
+// SkippedGood is a good of the log that can't be restored: none of its events
+// has its name, or its project is neither in the log nor in Postgres.
+type SkippedGood struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"projectId"`
+	Reason    string `json:"reason"`
+}
+
+type RebuildReport struct {
+	AsOf     time.Time     `json:"asOf"`
+	DryRun   bool          `json:"dryRun"`
+	Pruned   bool          `json:"pruned"`
+	Projects int           `json:"projects"`
+	Goods    int           `json:"goods"`
+	Missing  int           `json:"missing"`
+	Extra    int           `json:"extra"`
+	Changed  int           `json:"changed"`
+	Diffs    []GoodDiff    `json:"diffs"`
+	Skipped  []SkippedGood `json:"skipped"`
+}
+
+type CacheTierStats struct {
+	Hits   int64   `json:"hits"`
+	Misses int64   `json:"misses"`
+	Ratio  float64 `json:"ratio"`
+}
+
+type CacheStats struct {
+	Local CacheTierStats `json:"local"`
+	Redis CacheTierStats `json:"redis"`
+}
+
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Critical  bool    `json:"critical"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	// State is the state of the breaker of the dependency, if it has one.
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+type Liveness struct {
+	Status string    `json:"status"`
+	Since  time.Time `json:"since"`
+}
+
+// CounterDrift is a project whose maintained counters did not match its goods.
+type CounterDrift struct {
+	ProjectID       int `json:"projectId"`
+	Total           int `json:"total"`
+	Removed         int `json:"removed"`
+	ExpectedTotal   int `json:"expectedTotal"`
+	ExpectedRemoved int `json:"expectedRemoved"`
+}
+
+type SearchHighlight struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type SearchHit struct {
+	Good      Good            `json:"good"`
+	Rank      float32         `json:"rank"`
+	Highlight SearchHighlight `json:"highlight"`
+}
+
+type SearchMeta struct {
+	Query  string `json:"query"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Total  int    `json:"total"`
+}
+
+type SearchResponse struct {
+	Meta SearchMeta  `json:"meta"`
+	Hits []SearchHit `json:"hits"`
+}
+
+type SearchLanguageRequest struct {
+	Language string `json:"language" validate:"required"`
+}
+
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportFailed  = "failed"
+)
+
+// ImportGood is a row of an import file. Goods are matched by ExternalID
+// within the project.
+type ImportGood struct {
+	ExternalID  string `json:"externalId" validate:"required,max=255"`
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+}
+
+type ImportRow struct {
+	Row  int
+	Good ImportGood
+}
+
+type ImportRowResult struct {
+	Row        int    `json:"row"`
+	ExternalID string `json:"externalId,omitempty"`
+	Status     string `json:"status"`
+	ID         int    `json:"id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+type UpsertedGood struct {
+	Good    Good
+	Created bool
+}
+
+const (
+	JobExport       = "export"
+	JobImport       = "import"
+	JobPurge        = "purge"
+	JobReprioritize = "reprioritize"
+)
+
+// Job statuses, also the events of its lifecycle log.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// JobParams holds the options of every kind of job, each kind reads its own.
+type JobParams struct {
+	Format     string         `json:"format,omitempty"`
+	Columns    string         `json:"columns,omitempty"`
+	Removed    *bool          `json:"removed,omitempty"`
+	DryRun     bool           `json:"dryRun,omitempty"`
+	Priorities []GoodPriority `json:"priorities,omitempty"`
+}
+
+type GoodPriority struct {
+	ID       int `json:"id" validate:"required"`
+	Priority int `json:"priority" validate:"required,min=1"`
+}
+
+type ReprioritizeGoodsRequest struct {
+	Priorities []GoodPriority `json:"priorities" validate:"required,min=1,dive"`
+}
+
+type Job struct {
+	ID              int64      `json:"id" db:"id"`
+	Kind            string     `json:"kind" db:"kind"`
+	ProjectID       int        `json:"projectId" db:"project_id"`
+	Params          JobParams  `json:"params" db:"params"`
+	Status          string     `json:"status" db:"status"`
+	Progress        int        `json:"progress" db:"progress"`
+	Total           int        `json:"total" db:"total"`
+	ResultType      string     `json:"resultType,omitempty" db:"result_type"`
+	Error           string     `json:"error,omitempty" db:"error"`
+	CancelRequested bool       `json:"cancelRequested" db:"cancel_requested"`
+	Attempts        int        `json:"attempts" db:"attempts"`
+	CreatedAt       time.Time  `json:"createdAt" db:"created_at"`
+	StartedAt       *time.Time `json:"startedAt,omitempty" db:"started_at"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty" db:"finished_at"`
+}
+
+// JobResult describes the stored result of a job, its chunks are streamed.
+type JobResult struct {
+	Type string
+	Size int64
+}
+
+type JobLog struct {
+	Event string `json:"event"`
+	Job
+}
+
+type PurgeReport struct {
+	Purged int `json:"purged"`
+}
+
+type ReprioritizeReport struct {
+	Updated int   `json:"updated"`
+	Missing []int `json:"missing"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook subscribes a URL to the goods events of a project. No events means
+// every event.
+type Webhook struct {
+	ID        int       `json:"id" db:"id"`
+	ProjectID int       `json:"projectId" db:"project_id"`
+	URL       string    `json:"url" db:"url"`
+	Secret    string    `json:"-" db:"secret"`
+	Events    []string  `json:"events" db:"events"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url" validate:"required,http_url"`
+	Secret string   `json:"secret" validate:"required,min=16"`
+	Events []string `json:"events" validate:"dive,oneof=created updated removed reprioritized"`
+}
+
+// WebhookEvent is the body POSTed to the subscribers.
+type WebhookEvent struct {
+	Event      string    `json:"event"`
+	Good       Good      `json:"good"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+type WebhookDelivery struct {
+	ID             int64           `json:"id" db:"id"`
+	WebhookID      int             `json:"webhookId" db:"webhook_id"`
+	ProjectID      int             `json:"projectId" db:"project_id"`
+	Event          string          `json:"event" db:"event"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	ResponseStatus int             `json:"responseStatus,omitempty" db:"response_status"`
+	Error          string          `json:"error,omitempty" db:"error"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" db:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty" db:"delivered_at"`
+}
+
+// DueDelivery is a delivery claimed by a worker with where to send it.
+type DueDelivery struct {
+	WebhookDelivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+// Stream events that are not goods changes.
+const (
+	StreamReset    = "reset"
+	StreamOverflow = "overflow"
+)
+
+type StreamEvent struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event"`
+	Good  Good   `json:"good"`
+}
+
+// ProjectSummary is a project with its goods counters.
+type ProjectSummary struct {
+	ID             int       `json:"id" db:"id"`
+	Name           string    `json:"name" db:"name"`
+	SearchLanguage string    `json:"searchLanguage" db:"search_language"`
+	CreatedAt      time.Time `json:"createdAt" db:"created_at"`
+	Total          int       `json:"total" db:"total"`
+	Removed        int       `json:"removed" db:"removed"`
+}
@@ -0,0 +1,257 @@
+// Package openapi holds the OpenAPI document of the HTTP API, serves it with
+// Swagger UI and checks the requests, and the responses in test mode, against
+// it.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:embed openapi.yaml swagger-initializer.js
+var files embed.FS
+
+func init() {
+	// Uploaded files are not validated here but row by row on import.
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+}
+
+type Spec struct {
+	doc    *openapi3.T
+	router routers.Router
+	json   []byte
+
+	// validateResponses turns a response that does not match the document
+	// into a 500, to catch drift in tests. Streamed responses are not checked.
+	validateResponses bool
+}
+
+func New(validateResponses bool) (*Spec, error) {
+	b, err := files.ReadFile("openapi.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("error to read openapi document: %v", err)
+	}
+
+	doc, err := openapi3.NewLoader().LoadFromData(b)
+	if err != nil {
+		return nil, fmt.Errorf("error to load openapi document: %v", err)
+	}
+	versionPaths(doc)
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %v", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error to route openapi document: %v", err)
+	}
+
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error to marshal openapi document: %v", err)
+	}
+
+	return &Spec{doc: doc, router: router, json: j, validateResponses: validateResponses}, nil
+}
+
+// JSON is the document served at /openapi.json.
+func (s *Spec) JSON() []byte {
+	return s.json
+}
+
+// Docs serves Swagger UI under /docs/, reading the document next to it.
+func (s *Spec) Docs() http.Handler {
+	assets := http.FileServer(http.FS(swaggerFiles.FS))
+	return http.StripPrefix("/docs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/swagger-initializer.js" {
+			b, _ := files.ReadFile("swagger-initializer.js")
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Write(b)
+			return
+		}
+		assets.ServeHTTP(w, r)
+	}))
+}
+
+// Validate rejects the requests that do not match the document with a 400.
+// Authentication is left to its own middleware, as are file uploads to the
+// handlers reading them.
+func (s *Spec) Validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := s.router.FindRoute(r)
+		if err != nil {
+			// Routes missing from the document are reported by CheckRoutes.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				ExcludeRequestBody:  mediaType != "application/json",
+				AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+				SkipSettingDefaults: true,
+			},
+		}
+		if err = openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !s.validateResponses || streamed(route.Operation) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.status,
+			Header:                 w.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+		}); err != nil {
+			log.Errorf("response of %s %s does not match the openapi document: %v", r.Method, r.URL.Path, err)
+			http.Error(w, fmt.Sprintf("response does not match the openapi document: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(rec.status)
+		if _, err = w.Write(rec.body.Bytes()); err != nil {
+			log.Errorf("error to write response: %v", err)
+		}
+	})
+}
+
+// CheckRoutes compares the routes served with the operations of the
+// document, both ways, so the two can't drift apart unnoticed. Wildcard
+// routes, like the docs, are not operations.
+func (s *Spec) CheckRoutes(routes chi.Routes) error {
+	documented := map[string]bool{}
+	for path, item := range s.doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+
+	var drift []string
+	if err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasSuffix(route, "*") {
+			return nil
+		}
+		key := method + " " + route
+		if !documented[key] {
+			drift = append(drift, key+" is served but not documented")
+		}
+		delete(documented, key)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error to walk routes: %v", err)
+	}
+	for key := range documented {
+		drift = append(drift, key+" is documented but not served")
+	}
+
+	if len(drift) > 0 {
+		sort.Strings(drift)
+		return fmt.Errorf("routes drifted from the openapi document: %s", strings.Join(drift, "; "))
+	}
+	return nil
+}
+
+// versionPaths serves the paths written without a version, the v1 ones,
+// under /v1 and under /v2 where v2 has no path of its own. The unversioned
+// paths stay, deprecated. Paths marked x-unversioned are not versioned.
+func versionPaths(doc *openapi3.T) {
+	paths := make([]string, 0, doc.Paths.Len())
+	for path := range doc.Paths.Map() {
+		paths = append(paths, path)
+	}
+
+	for _, path := range paths {
+		item := doc.Paths.Value(path)
+		if unversioned, _ := item.Extensions["x-unversioned"].(bool); unversioned {
+			continue
+		}
+		if strings.HasPrefix(path, "/v2/") {
+			for _, operation := range item.Operations() {
+				operation.Tags = []string{"v2"}
+			}
+			continue
+		}
+
+		doc.Paths.Set("/v1"+path, versioned(item, "v1"))
+		if doc.Paths.Value("/v2"+path) == nil {
+			doc.Paths.Set("/v2"+path, versioned(item, "v2"))
+		}
+		for _, operation := range item.Operations() {
+			operation.Tags = []string{"unversioned"}
+			operation.Deprecated = true
+		}
+	}
+}
+
+// versioned copies a path item for a version, with its own operation ids.
+func versioned(item *openapi3.PathItem, version string) *openapi3.PathItem {
+	copied := *item
+	for method, operation := range item.Operations() {
+		op := *operation
+		op.OperationID += strings.ToUpper(version)
+		op.Tags = []string{version}
+		copied.SetOperation(method, &op)
+	}
+	return &copied
+}
+
+// streamed tells whether a successful response of the operation may be
+// something else than JSON, which is then streamed and not checked.
+func streamed(operation *openapi3.Operation) bool {
+	for status, response := range operation.Responses.Map() {
+		if !strings.HasPrefix(status, "2") && status != "101" {
+			continue
+		}
+		for contentType := range response.Value.Content {
+			if contentType != "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recorder holds the response back until it is validated. Headers go
+// straight to the ResponseWriter, they are sent with the status.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
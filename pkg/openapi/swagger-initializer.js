@@ -0,0 +1,17 @@
+window.onload = function() {
+  // The document is served next to /docs/, under the same prefix.
+  window.ui = SwaggerUIBundle({
+    url: "../openapi.json",
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    persistAuthorization: true,
+    presets: [
+      SwaggerUIBundle.presets.apis,
+      SwaggerUIStandalonePreset
+    ],
+    plugins: [
+      SwaggerUIBundle.plugins.DownloadUrl
+    ],
+    layout: "StandaloneLayout"
+  });
+};
@@ -0,0 +1,486 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: goods.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Goods_ListGoods_FullMethodName        = "/hezzl.goods.v1.Goods/ListGoods"
+	Goods_GetGood_FullMethodName          = "/hezzl.goods.v1.Goods/GetGood"
+	Goods_CreateGood_FullMethodName       = "/hezzl.goods.v1.Goods/CreateGood"
+	Goods_UpdateGood_FullMethodName       = "/hezzl.goods.v1.Goods/UpdateGood"
+	Goods_DeleteGood_FullMethodName       = "/hezzl.goods.v1.Goods/DeleteGood"
+	Goods_ReprioritizeGood_FullMethodName = "/hezzl.goods.v1.Goods/ReprioritizeGood"
+	Goods_WatchGoods_FullMethodName       = "/hezzl.goods.v1.Goods/WatchGoods"
+)
+
+// GoodsClient is the client API for Goods service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GoodsClient interface {
+	// ListGoods streams the goods of a project, or of every project for 0,
+	// ordered by id, as they are read from the database.
+	ListGoods(ctx context.Context, in *ListGoodsRequest, opts ...grpc.CallOption) (Goods_ListGoodsClient, error)
+	GetGood(ctx context.Context, in *GetGoodRequest, opts ...grpc.CallOption) (*Good, error)
+	CreateGood(ctx context.Context, in *CreateGoodRequest, opts ...grpc.CallOption) (*Good, error)
+	UpdateGood(ctx context.Context, in *UpdateGoodRequest, opts ...grpc.CallOption) (*Good, error)
+	DeleteGood(ctx context.Context, in *DeleteGoodRequest, opts ...grpc.CallOption) (*Good, error)
+	ReprioritizeGood(ctx context.Context, in *ReprioritizeGoodRequest, opts ...grpc.CallOption) (*ReprioritizeGoodResponse, error)
+	// WatchGoods streams the changes of a project as they happen, like
+	// /goods/stream. It ends with RESOURCE_EXHAUSTED when the client falls
+	// behind; it should then call again with the last event id it got.
+	WatchGoods(ctx context.Context, in *WatchGoodsRequest, opts ...grpc.CallOption) (Goods_WatchGoodsClient, error)
+}
+
+type goodsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGoodsClient(cc grpc.ClientConnInterface) GoodsClient {
+	return &goodsClient{cc}
+}
+
+func (c *goodsClient) ListGoods(ctx context.Context, in *ListGoodsRequest, opts ...grpc.CallOption) (Goods_ListGoodsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Goods_ServiceDesc.Streams[0], Goods_ListGoods_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &goodsListGoodsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Goods_ListGoodsClient interface {
+	Recv() (*Good, error)
+	grpc.ClientStream
+}
+
+type goodsListGoodsClient struct {
+	grpc.ClientStream
+}
+
+func (x *goodsListGoodsClient) Recv() (*Good, error) {
+	m := new(Good)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *goodsClient) GetGood(ctx context.Context, in *GetGoodRequest, opts ...grpc.CallOption) (*Good, error) {
+	out := new(Good)
+	err := c.cc.Invoke(ctx, Goods_GetGood_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goodsClient) CreateGood(ctx context.Context, in *CreateGoodRequest, opts ...grpc.CallOption) (*Good, error) {
+	out := new(Good)
+	err := c.cc.Invoke(ctx, Goods_CreateGood_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goodsClient) UpdateGood(ctx context.Context, in *UpdateGoodRequest, opts ...grpc.CallOption) (*Good, error) {
+	out := new(Good)
+	err := c.cc.Invoke(ctx, Goods_UpdateGood_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goodsClient) DeleteGood(ctx context.Context, in *DeleteGoodRequest, opts ...grpc.CallOption) (*Good, error) {
+	out := new(Good)
+	err := c.cc.Invoke(ctx, Goods_DeleteGood_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goodsClient) ReprioritizeGood(ctx context.Context, in *ReprioritizeGoodRequest, opts ...grpc.CallOption) (*ReprioritizeGoodResponse, error) {
+	out := new(ReprioritizeGoodResponse)
+	err := c.cc.Invoke(ctx, Goods_ReprioritizeGood_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goodsClient) WatchGoods(ctx context.Context, in *WatchGoodsRequest, opts ...grpc.CallOption) (Goods_WatchGoodsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Goods_ServiceDesc.Streams[1], Goods_WatchGoods_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &goodsWatchGoodsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Goods_WatchGoodsClient interface {
+	Recv() (*GoodEvent, error)
+	grpc.ClientStream
+}
+
+type goodsWatchGoodsClient struct {
+	grpc.ClientStream
+}
+
+func (x *goodsWatchGoodsClient) Recv() (*GoodEvent, error) {
+	m := new(GoodEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GoodsServer is the server API for Goods service.
+// All implementations must embed UnimplementedGoodsServer
+// for forward compatibility
+type GoodsServer interface {
+	// ListGoods streams the goods of a project, or of every project for 0,
+	// ordered by id, as they are read from the database.
+	ListGoods(*ListGoodsRequest, Goods_ListGoodsServer) error
+	GetGood(context.Context, *GetGoodRequest) (*Good, error)
+	CreateGood(context.Context, *CreateGoodRequest) (*Good, error)
+	UpdateGood(context.Context, *UpdateGoodRequest) (*Good, error)
+	DeleteGood(context.Context, *DeleteGoodRequest) (*Good, error)
+	ReprioritizeGood(context.Context, *ReprioritizeGoodRequest) (*ReprioritizeGoodResponse, error)
+	// WatchGoods streams the changes of a project as they happen, like
+	// /goods/stream. It ends with RESOURCE_EXHAUSTED when the client falls
+	// behind; it should then call again with the last event id it got.
+	WatchGoods(*WatchGoodsRequest, Goods_WatchGoodsServer) error
+	mustEmbedUnimplementedGoodsServer()
+}
+
+// UnimplementedGoodsServer must be embedded to have forward compatible implementations.
+type UnimplementedGoodsServer struct {
+}
+
+func (UnimplementedGoodsServer) ListGoods(*ListGoodsRequest, Goods_ListGoodsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListGoods not implemented")
+}
+func (UnimplementedGoodsServer) GetGood(context.Context, *GetGoodRequest) (*Good, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGood not implemented")
+}
+func (UnimplementedGoodsServer) CreateGood(context.Context, *CreateGoodRequest) (*Good, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateGood not implemented")
+}
+func (UnimplementedGoodsServer) UpdateGood(context.Context, *UpdateGoodRequest) (*Good, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateGood not implemented")
+}
+func (UnimplementedGoodsServer) DeleteGood(context.Context, *DeleteGoodRequest) (*Good, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteGood not implemented")
+}
+func (UnimplementedGoodsServer) ReprioritizeGood(context.Context, *ReprioritizeGoodRequest) (*ReprioritizeGoodResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReprioritizeGood not implemented")
+}
+func (UnimplementedGoodsServer) WatchGoods(*WatchGoodsRequest, Goods_WatchGoodsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchGoods not implemented")
+}
+func (UnimplementedGoodsServer) mustEmbedUnimplementedGoodsServer() {}
+
+// UnsafeGoodsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GoodsServer will
+// result in compilation errors.
+type UnsafeGoodsServer interface {
+	mustEmbedUnimplementedGoodsServer()
+}
+
+func RegisterGoodsServer(s grpc.ServiceRegistrar, srv GoodsServer) {
+	s.RegisterService(&Goods_ServiceDesc, srv)
+}
+
+func _Goods_ListGoods_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListGoodsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoodsServer).ListGoods(m, &goodsListGoodsServer{stream})
+}
+
+type Goods_ListGoodsServer interface {
+	Send(*Good) error
+	grpc.ServerStream
+}
+
+type goodsListGoodsServer struct {
+	grpc.ServerStream
+}
+
+func (x *goodsListGoodsServer) Send(m *Good) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Goods_GetGood_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGoodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoodsServer).GetGood(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Goods_GetGood_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoodsServer).GetGood(ctx, req.(*GetGoodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Goods_CreateGood_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateGoodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoodsServer).CreateGood(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Goods_CreateGood_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoodsServer).CreateGood(ctx, req.(*CreateGoodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Goods_UpdateGood_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateGoodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoodsServer).UpdateGood(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Goods_UpdateGood_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoodsServer).UpdateGood(ctx, req.(*UpdateGoodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Goods_DeleteGood_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteGoodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoodsServer).DeleteGood(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Goods_DeleteGood_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoodsServer).DeleteGood(ctx, req.(*DeleteGoodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Goods_ReprioritizeGood_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReprioritizeGoodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoodsServer).ReprioritizeGood(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Goods_ReprioritizeGood_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoodsServer).ReprioritizeGood(ctx, req.(*ReprioritizeGoodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Goods_WatchGoods_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchGoodsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoodsServer).WatchGoods(m, &goodsWatchGoodsServer{stream})
+}
+
+type Goods_WatchGoodsServer interface {
+	Send(*GoodEvent) error
+	grpc.ServerStream
+}
+
+type goodsWatchGoodsServer struct {
+	grpc.ServerStream
+}
+
+func (x *goodsWatchGoodsServer) Send(m *GoodEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Goods_ServiceDesc is the grpc.ServiceDesc for Goods service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Goods_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hezzl.goods.v1.Goods",
+	HandlerType: (*GoodsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetGood",
+			Handler:    _Goods_GetGood_Handler,
+		},
+		{
+			MethodName: "CreateGood",
+			Handler:    _Goods_CreateGood_Handler,
+		},
+		{
+			MethodName: "UpdateGood",
+			Handler:    _Goods_UpdateGood_Handler,
+		},
+		{
+			MethodName: "DeleteGood",
+			Handler:    _Goods_DeleteGood_Handler,
+		},
+		{
+			MethodName: "ReprioritizeGood",
+			Handler:    _Goods_ReprioritizeGood_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListGoods",
+			Handler:       _Goods_ListGoods_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchGoods",
+			Handler:       _Goods_WatchGoods_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "goods.proto",
+}
+
+const (
+	Projects_SetSearchLanguage_FullMethodName = "/hezzl.goods.v1.Projects/SetSearchLanguage"
+)
+
+// ProjectsClient is the client API for Projects service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ProjectsClient interface {
+	SetSearchLanguage(ctx context.Context, in *SetSearchLanguageRequest, opts ...grpc.CallOption) (*Project, error)
+}
+
+type projectsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProjectsClient(cc grpc.ClientConnInterface) ProjectsClient {
+	return &projectsClient{cc}
+}
+
+func (c *projectsClient) SetSearchLanguage(ctx context.Context, in *SetSearchLanguageRequest, opts ...grpc.CallOption) (*Project, error) {
+	out := new(Project)
+	err := c.cc.Invoke(ctx, Projects_SetSearchLanguage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ProjectsServer is the server API for Projects service.
+// All implementations must embed UnimplementedProjectsServer
+// for forward compatibility
+type ProjectsServer interface {
+	SetSearchLanguage(context.Context, *SetSearchLanguageRequest) (*Project, error)
+	mustEmbedUnimplementedProjectsServer()
+}
+
+// UnimplementedProjectsServer must be embedded to have forward compatible implementations.
+type UnimplementedProjectsServer struct {
+}
+
+func (UnimplementedProjectsServer) SetSearchLanguage(context.Context, *SetSearchLanguageRequest) (*Project, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSearchLanguage not implemented")
+}
+func (UnimplementedProjectsServer) mustEmbedUnimplementedProjectsServer() {}
+
+// UnsafeProjectsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ProjectsServer will
+// result in compilation errors.
+type UnsafeProjectsServer interface {
+	mustEmbedUnimplementedProjectsServer()
+}
+
+func RegisterProjectsServer(s grpc.ServiceRegistrar, srv ProjectsServer) {
+	s.RegisterService(&Projects_ServiceDesc, srv)
+}
+
+func _Projects_SetSearchLanguage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSearchLanguageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProjectsServer).SetSearchLanguage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Projects_SetSearchLanguage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProjectsServer).SetSearchLanguage(ctx, req.(*SetSearchLanguageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Projects_ServiceDesc is the grpc.ServiceDesc for Projects service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Projects_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hezzl.goods.v1.Projects",
+	HandlerType: (*ProjectsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetSearchLanguage",
+			Handler:    _Projects_SetSearchLanguage_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "goods.proto",
+}
@@ -3,13 +3,21 @@ package queue
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	"github.com/nats-io/nats.go"
 )
 
 func NewNats(ctx context.Context, c models.ConfigNats) (*nats.Conn, error) {
-	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%s", c.Host, c.Port))
+	// The connection keeps reconnecting in the background, so the service
+	// starts and keeps running while NATS is down.
+	nc, err := nats.Connect(
+		fmt.Sprintf("nats://%s:%s", c.Host, c.Port),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Second),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect nats: %w", err)
 	}
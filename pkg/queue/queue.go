@@ -4,33 +4,45 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/Hymiside/hezzl-api/pkg/breaker"
+	"github.com/Hymiside/hezzl-api/pkg/metrics"
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
 )
 
 type clickhouse interface {
-	CreateLogs(ctx context.Context, logs []models.Good) error
+	CreateLogs(ctx context.Context, logs []models.Log) error
+	CreateJobLogs(ctx context.Context, logs []models.JobLog) error
+	CreateProjectLogs(ctx context.Context, logs []models.ProjectLog) error
 }
 
 type Queue struct {
 	nats       *nats.Conn
 	clickhouse clickhouse
+	breaker    *breaker.Breaker
 
-	logs      []models.Good
+	logs      []models.Log
 	numOfLogs int
+	// backlog mirrors len(logs), which only the subscription goroutine reads.
+	backlog atomic.Int64
 }
 
 func NewQueue(
 	nc *nats.Conn,
 	clickhouse clickhouse,
 	numOfLogs int,
+	b *breaker.Breaker,
 ) *Queue {
 	return &Queue{
 		nats:       nc,
 		clickhouse: clickhouse,
-		logs:       []models.Good{},
+		breaker:    b,
+		logs:       []models.Log{},
 		numOfLogs:  numOfLogs,
 	}
 }
@@ -44,29 +56,148 @@ func (q *Queue) Subscribe() error {
 		return fmt.Errorf("error to subscribe: %v", err)
 	}
 
+	if _, err := q.nats.Subscribe("jobs", func(m *nats.Msg) {
+		if err := q.readJob(m.Data); err != nil {
+			log.Errorf("error to read job: %v", err)
+		}
+	}); err != nil {
+		return fmt.Errorf("error to subscribe: %v", err)
+	}
+
+	if _, err := q.nats.Subscribe("projects", func(m *nats.Msg) {
+		if err := q.readProject(m.Data); err != nil {
+			log.Errorf("error to read project: %v", err)
+		}
+	}); err != nil {
+		return fmt.Errorf("error to subscribe: %v", err)
+	}
+
+	return nil
+}
+
+// SubscribeEvents hands the goods events to fn. Subscribers of the same group
+// share the events, each one goes to a single member; without a group every
+// subscriber gets all of them.
+func (q *Queue) SubscribeEvents(group string, fn func(b []byte)) error {
+	handler := func(m *nats.Msg) {
+		fn(m.Data)
+	}
+
+	var err error
+	if group == "" {
+		_, err = q.nats.Subscribe("logs", handler)
+	} else {
+		_, err = q.nats.QueueSubscribe("logs", group, handler)
+	}
+	if err != nil {
+		return fmt.Errorf("error to subscribe: %v", err)
+	}
 	return nil
 }
 
 func (q *Queue) Publish(b []byte) error {
-	if err := q.nats.Publish("logs", b); err != nil {
+	return q.publish("logs", b)
+}
+
+func (q *Queue) PublishProject(b []byte) error {
+	return q.publish("projects", b)
+}
+
+func (q *Queue) PublishJob(b []byte) error {
+	return q.publish("jobs", b)
+}
+
+func (q *Queue) PublishInvalidation(projectID int) error {
+	return q.publish("goods.invalidate", []byte(strconv.Itoa(projectID)))
+}
+
+func (q *Queue) publish(subject string, b []byte) error {
+	if err := q.breaker.Do(context.Background(), func(context.Context) error {
+		return q.nats.Publish(subject, b)
+	}); err != nil {
+		metrics.PublishErrors.WithLabelValues(subject).Inc()
 		return fmt.Errorf("error to publish: %v", err)
 	}
 	return nil
 }
 
-func (q *Queue) read(b []byte) error {
-	if len(q.logs) == q.numOfLogs {
-		if err := q.clickhouse.CreateLogs(context.Background(), q.logs); err != nil {
-			return fmt.Errorf("error to create logs: %v", err)
+func (q *Queue) SubscribeInvalidations(evict func(projectID int)) error {
+	if _, err := q.nats.Subscribe("goods.invalidate", func(m *nats.Msg) {
+		projectID, err := strconv.Atoi(string(m.Data))
+		if err != nil {
+			log.Errorf("error to parse invalidation: %v", err)
+			return
 		}
-		q.logs = []models.Good{}
-		return nil
+		evict(projectID)
+	}); err != nil {
+		return fmt.Errorf("error to subscribe: %v", err)
 	}
+	return nil
+}
 
-	log := models.Good{}
+func (q *Queue) read(b []byte) error {
+	log := models.Log{}
 	if err := json.Unmarshal(b, &log); err != nil {
 		return fmt.Errorf("error to unmarshal: %v", err)
 	}
 	q.logs = append(q.logs, log)
+	defer func() {
+		q.backlog.Store(int64(len(q.logs)))
+		metrics.LogBacklog.Set(float64(len(q.logs)))
+	}()
+
+	if len(q.logs) >= q.numOfLogs {
+		if err := q.flush(); err != nil {
+			return fmt.Errorf("error to create logs: %v", err)
+		}
+		q.logs = []models.Log{}
+	}
+	return nil
+}
+
+func (q *Queue) flush() error {
+	start := time.Now()
+	err := q.clickhouse.CreateLogs(context.Background(), q.logs)
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.LogFlushDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	metrics.LogFlushSize.Observe(float64(len(q.logs)))
+	return err
+}
+
+// Backlog is the number of logs waiting to be written to ClickHouse. It grows
+// past a batch while the writes fail.
+func (q *Queue) Backlog() int {
+	return int(q.backlog.Load())
+}
+
+// readJob stores job events as they come: there are few of them and their
+// order matters more than batching.
+func (q *Queue) readJob(b []byte) error {
+	jobLog := models.JobLog{}
+	if err := json.Unmarshal(b, &jobLog); err != nil {
+		return fmt.Errorf("error to unmarshal: %v", err)
+	}
+
+	if err := q.clickhouse.CreateJobLogs(context.Background(), []models.JobLog{jobLog}); err != nil {
+		return fmt.Errorf("error to create job logs: %v", err)
+	}
+	return nil
+}
+
+// readProject stores project events as they come, like job events. Only change
+// data capture publishes them.
+func (q *Queue) readProject(b []byte) error {
+	projectLog := models.ProjectLog{}
+	if err := json.Unmarshal(b, &projectLog); err != nil {
+		return fmt.Errorf("error to unmarshal: %v", err)
+	}
+
+	if err := q.clickhouse.CreateProjectLogs(context.Background(), []models.ProjectLog{projectLog}); err != nil {
+		return fmt.Errorf("error to create project logs: %v", err)
+	}
 	return nil
 }
@@ -10,6 +10,20 @@ import (
 )
 
 func NewClickhouseDB(ctx context.Context, cfg models.ConfigClickhouse) (*sql.DB, error) {
+	db, err := OpenClickhouseDB(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("connection test error: %w", err)
+	}
+
+	return db, nil
+}
+
+// OpenClickhouseDB creates the pool without waiting for the server to answer.
+func OpenClickhouseDB(ctx context.Context, cfg models.ConfigClickhouse) (*sql.DB, error) {
 	psqlInfo := fmt.Sprintf("http://%s:%s/%s", cfg.Host, cfg.Port, cfg.Database)
 	db, err := sql.Open("chhttp", psqlInfo)
 	if err != nil {
@@ -20,9 +34,5 @@ func NewClickhouseDB(ctx context.Context, cfg models.ConfigClickhouse) (*sql.DB,
 		db.Close()
 	}(ctx)
 
-	if err = db.Ping(); err != nil {
-		return nil, fmt.Errorf("connection test error: %w", err)
-	}
-
 	return db, nil
 }
\ No newline at end of file
@@ -3,20 +3,171 @@ package clickhouse
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/Hymiside/hezzl-api/pkg/breaker"
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
 	"github.com/Hymiside/hezzl-api/pkg/models"
+	chdriver "github.com/mailru/go-clickhouse/v2"
 )
 
+// goodsAsOfQuery folds the change log into the last known state of every good
+// up to the given moment. Remove and reprioritize events carry only part of the
+// good, so each column is taken from the latest event that actually has it.
+// Events of the same microsecond are ordered by the version of the good.
+//
+// The aliases don't reuse the column names: ClickHouse would resolve those
+// names to the aggregates everywhere else in the query.
+const goodsAsOfQuery = `
+	SELECT
+		id,
+		argMax(project_id, (occurred_at, version)) AS last_project_id,
+		argMaxIf(name, (occurred_at, version), name != '') AS last_name,
+		argMaxIf(description, (occurred_at, version), name != '') AS last_description,
+		argMaxIf(priority, (occurred_at, version), name != '' OR event = 'reprioritized') AS last_priority,
+		argMax(removed, (occurred_at, version)) AS last_removed,
+		min(occurred_at) AS first_seen
+	FROM logs
+	WHERE occurred_at <= toDateTime64(?, 6, 'UTC')
+	GROUP BY id`
+
+// goodsAsOfColumns are the folded columns in the order of the fields of a good
+// scanned from them.
+const goodsAsOfColumns = `
+	id,
+	last_project_id,
+	last_name,
+	last_description,
+	last_priority,
+	last_removed,
+	first_seen`
+
+// projectsAsOfQuery folds the project change log like goodsAsOfQuery, leaving
+// out the projects deleted by then.
+const projectsAsOfQuery = `
+	SELECT
+		id,
+		argMaxIf(name, occurred_at, name != '') AS last_name,
+		argMax(project_created_at, occurred_at) AS last_created_at
+	FROM project_logs
+	WHERE occurred_at <= toDateTime64(?, 6, 'UTC')
+	GROUP BY id
+	HAVING argMax(event, occurred_at) != 'removed'`
+
+// dateTime64 formats t for a DateTime64(6) in UTC: the driver drops the
+// fraction of the seconds and the time zone of time.Time parameters.
+func dateTime64(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000000")
+}
+
 type RepositoryClickhouse struct {
-	db *sql.DB
+	db      *sql.DB
+	breaker *breaker.Breaker
+
+	// analyticsTimeout bounds the analytics queries, no timeout when zero.
+	analyticsTimeout time.Duration
+}
+
+func NewRepositoryClickhouse(db *sql.DB, b *breaker.Breaker, analyticsTimeout time.Duration) *RepositoryClickhouse {
+	return &RepositoryClickhouse{db: db, breaker: b, analyticsTimeout: analyticsTimeout}
+}
+
+func (r *RepositoryClickhouse) CreateLogs(ctx context.Context, logs []models.Log) error {
+	return r.breaker.Do(ctx, func(ctx context.Context) error {
+		return r.createLogs(ctx, logs)
+	})
 }
 
-func NewRepositoryClickhouse(db *sql.DB) *RepositoryClickhouse {
-	return &RepositoryClickhouse{db: db}
+func (r *RepositoryClickhouse) CreateJobLogs(ctx context.Context, logs []models.JobLog) error {
+	return r.breaker.Do(ctx, func(ctx context.Context) error {
+		return r.createJobLogs(ctx, logs)
+	})
 }
 
-func (r *RepositoryClickhouse) CreateLogs(ctx context.Context, logs []models.Good) error {
+func (r *RepositoryClickhouse) CreateProjectLogs(ctx context.Context, logs []models.ProjectLog) error {
+	return r.breaker.Do(ctx, func(ctx context.Context) error {
+		return r.createProjectLogs(ctx, logs)
+	})
+}
+
+func (r *RepositoryClickhouse) GoodsAsOf(ctx context.Context, projectID, limit, offset int, asOf time.Time) (goods models.GoodsResponse, err error) {
+	err = r.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		goods, err = r.goodsAsOf(ctx, projectID, limit, offset, asOf)
+		return err
+	})
+	return goods, err
+}
+
+func (r *RepositoryClickhouse) GoodsState(ctx context.Context, asOf time.Time) (goods []models.Good, err error) {
+	err = r.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		goods, err = r.goodsState(ctx, asOf)
+		return err
+	})
+	return goods, err
+}
+
+func (r *RepositoryClickhouse) ProjectsState(ctx context.Context, asOf time.Time) (projects []models.Project, err error) {
+	err = r.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		projects, err = r.projectsState(ctx, asOf)
+		return err
+	})
+	return projects, err
+}
+
+func (r *RepositoryClickhouse) GoodAsOf(ctx context.Context, goodID, projectID int, asOf time.Time) (good models.Good, err error) {
+	err = r.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		good, err = r.goodAsOf(ctx, goodID, projectID, asOf)
+		return err
+	})
+	return good, err
+}
+
+// Activity runs outside the breaker: an aggregation over a long period can take
+// longer than a log write may, and must not open the breaker of the writes.
+func (r *RepositoryClickhouse) Activity(ctx context.Context, projectID int, bucket string, from, to time.Time) ([]models.ActivityBucket, error) {
+	ctx, cancel := r.analyticsContext(ctx)
+	defer cancel()
+	return r.activity(ctx, projectID, bucket, from, to)
+}
+
+// TopEditedGoods runs outside the breaker, like Activity.
+func (r *RepositoryClickhouse) TopEditedGoods(ctx context.Context, projectID, limit int, from, to time.Time) ([]models.EditedGood, error) {
+	ctx, cancel := r.analyticsContext(ctx)
+	defer cancel()
+	return r.topEditedGoods(ctx, projectID, limit, from, to)
+}
+
+func (r *RepositoryClickhouse) analyticsContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.analyticsTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.analyticsTimeout)
+}
+
+// ProjectsHistory returns the latest limit changes of each of the projects,
+// newest first, in one query.
+func (r *RepositoryClickhouse) ProjectsHistory(ctx context.Context, projectIDs []int, limit int) (logs []models.Log, err error) {
+	err = r.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		logs, err = r.history(ctx, "project_id", projectIDs, limit)
+		return err
+	})
+	return logs, err
+}
+
+// GoodsHistory returns the latest limit changes of each of the goods, newest
+// first, in one query.
+func (r *RepositoryClickhouse) GoodsHistory(ctx context.Context, goodIDs []int, limit int) (logs []models.Log, err error) {
+	err = r.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		logs, err = r.history(ctx, "id", goodIDs, limit)
+		return err
+	})
+	return logs, err
+}
+
+func (r *RepositoryClickhouse) createLogs(ctx context.Context, logs []models.Log) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error to begin transaction: %v", err)
@@ -24,20 +175,21 @@ func (r *RepositoryClickhouse) CreateLogs(ctx context.Context, logs []models.Goo
 	defer tx.Rollback()
 
 	for _, v := range logs {
-
-		fmt.Printf("v: %v\n", v)
 		if _, err := tx.ExecContext(
 			ctx, 
 			`INSERT INTO 
-				logs (id, project_id, name, description, priority, removed, created_at) 
+				logs (id, event, project_id, name, description, priority, removed, version, occurred_at, created_at) 
 			VALUES
-				(?, ?, ?, ?, ?, ?, now())`, 
+				(?, ?, ?, ?, ?, ?, ?, ?, toDateTime64(?, 6, 'UTC'), now())`, 
 			v.ID, 
+			v.Event, 
 			v.ProjectID, 
 			v.Name, 
 			v.Description, 
 			v.Priority, 
 			v.Removed, 
+			v.Version,
+			dateTime64(v.OccurredAt),
 		); err != nil {
 			return fmt.Errorf("error to create logs: %v", err)
 		}
@@ -47,4 +199,368 @@ func (r *RepositoryClickhouse) CreateLogs(ctx context.Context, logs []models.Goo
 		return fmt.Errorf("error to commit transaction: %v", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (r *RepositoryClickhouse) createJobLogs(ctx context.Context, logs []models.JobLog) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, v := range logs {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO
+				job_logs (id, event, kind, project_id, progress, total, error, created_at)
+			VALUES
+				(?, ?, ?, ?, ?, ?, ?, now())`,
+			v.ID,
+			v.Event,
+			v.Kind,
+			v.ProjectID,
+			v.Progress,
+			v.Total,
+			v.Error,
+		); err != nil {
+			return fmt.Errorf("error to create job logs: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error to commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (r *RepositoryClickhouse) createProjectLogs(ctx context.Context, logs []models.ProjectLog) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, v := range logs {
+		projectID, err := strconv.Atoi(v.ID)
+		if err != nil {
+			return fmt.Errorf("error to parse project id %q: %v", v.ID, err)
+		}
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO
+				project_logs (id, event, name, project_created_at, occurred_at, created_at)
+			VALUES
+				(?, ?, ?, ?, toDateTime64(?, 6, 'UTC'), now())`,
+			projectID,
+			v.Event,
+			v.Name,
+			v.CreatedAt,
+			dateTime64(v.OccurredAt),
+		); err != nil {
+			return fmt.Errorf("error to create project logs: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error to commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (r *RepositoryClickhouse) goodsAsOf(ctx context.Context, projectID, limit, offset int, asOf time.Time) (models.GoodsResponse, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT`+goodsAsOfColumns+`
+		FROM (`+goodsAsOfQuery+`)
+		WHERE ? = 0 OR last_project_id = ?
+		ORDER BY id
+		LIMIT ? OFFSET ?`,
+		dateTime64(asOf), projectID, projectID, limit, offset)
+	if err != nil {
+		return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
+	}
+	defer rows.Close()
+
+	var goods = []models.Good{}
+	for rows.Next() {
+		var good models.Good
+		if err = rows.Scan(
+			&good.ID,
+			&good.ProjectID,
+			&good.Name,
+			&good.Description,
+			&good.Priority,
+			&good.Removed,
+			&good.CreatedAt,
+		); err != nil {
+			return models.GoodsResponse{}, fmt.Errorf("error to scan good: %v", err)
+		}
+		goods = append(goods, good)
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.GoodsResponse{}, fmt.Errorf("error rows goods: %v", err)
+	}
+
+	row := r.db.QueryRowContext(
+		ctx,
+		`SELECT
+			count(),
+			countIf(last_removed)
+		FROM (`+goodsAsOfQuery+`)
+		WHERE ? = 0 OR last_project_id = ?`,
+		dateTime64(asOf), projectID, projectID)
+
+	var total, removed int
+	if err = row.Scan(&total, &removed); err != nil {
+		return models.GoodsResponse{}, fmt.Errorf("error to scan total goods: %v", err)
+	}
+
+	goodsResponse := models.GoodsResponse{
+		Goods: goods,
+		Meta: models.Meta{
+			Limit:      limit,
+			Offset:     offset,
+			Total:      total,
+			Removed:    removed,
+			Historical: true,
+			AsOf:       &asOf,
+		},
+	}
+	return goodsResponse, nil
+}
+
+func (r *RepositoryClickhouse) goodsState(ctx context.Context, asOf time.Time) ([]models.Good, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT`+goodsAsOfColumns+`
+		FROM (`+goodsAsOfQuery+`)
+		ORDER BY id`,
+		dateTime64(asOf))
+	if err != nil {
+		return nil, fmt.Errorf("error to get goods: %v", err)
+	}
+	defer rows.Close()
+
+	var goods []models.Good
+	for rows.Next() {
+		var good models.Good
+		if err = rows.Scan(
+			&good.ID,
+			&good.ProjectID,
+			&good.Name,
+			&good.Description,
+			&good.Priority,
+			&good.Removed,
+			&good.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("error to scan good: %v", err)
+		}
+		goods = append(goods, good)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error rows goods: %v", err)
+	}
+	return goods, nil
+}
+
+func (r *RepositoryClickhouse) projectsState(ctx context.Context, asOf time.Time) ([]models.Project, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			last_name,
+			last_created_at
+		FROM (`+projectsAsOfQuery+`)
+		ORDER BY id`,
+		dateTime64(asOf))
+	if err != nil {
+		return nil, fmt.Errorf("error to get projects: %v", err)
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		var (
+			project   models.Project
+			projectID int
+		)
+		if err = rows.Scan(
+			&projectID,
+			&project.Name,
+			&project.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("error to scan project: %v", err)
+		}
+		project.ID = strconv.Itoa(projectID)
+		projects = append(projects, project)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error rows projects: %v", err)
+	}
+	return projects, nil
+}
+
+func (r *RepositoryClickhouse) goodAsOf(ctx context.Context, goodID, projectID int, asOf time.Time) (models.Good, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		`SELECT`+goodsAsOfColumns+`
+		FROM (`+goodsAsOfQuery+`)
+		WHERE id = ? AND last_project_id = ?`,
+		dateTime64(asOf), goodID, projectID)
+
+	var good models.Good
+	if err := row.Scan(
+		&good.ID,
+		&good.ProjectID,
+		&good.Name,
+		&good.Description,
+		&good.Priority,
+		&good.Removed,
+		&good.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Good{}, custerrors.ErrNotFound
+		}
+		return models.Good{}, fmt.Errorf("error to scan good: %v", err)
+	}
+	return good, nil
+}
+
+var activityBuckets = map[string]string{
+	"hour": "bucket",
+	"day":  "toStartOfDay(bucket)",
+	"week": "toDateTime(toMonday(bucket))",
+}
+
+func (r *RepositoryClickhouse) activity(ctx context.Context, projectID int, bucket string, from, to time.Time) ([]models.ActivityBucket, error) {
+	bucketExpr, ok := activityBuckets[bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket %q", bucket)
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			project_id,
+			event,
+			`+bucketExpr+` AS period,
+			sum(events) AS events
+		FROM logs_activity_hourly
+		WHERE bucket >= toDateTime64(?, 6, 'UTC') AND bucket < toDateTime64(?, 6, 'UTC') AND (? = 0 OR project_id = ?)
+		GROUP BY project_id, event, period
+		ORDER BY project_id, period, event`,
+		dateTime64(from), dateTime64(to), projectID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error to get activity: %v", err)
+	}
+	defer rows.Close()
+
+	var activity = []models.ActivityBucket{}
+	for rows.Next() {
+		var a models.ActivityBucket
+		if err = rows.Scan(
+			&a.ProjectID,
+			&a.Event,
+			&a.Bucket,
+			&a.Count,
+		); err != nil {
+			return nil, fmt.Errorf("error to scan activity: %v", err)
+		}
+		activity = append(activity, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error rows activity: %v", err)
+	}
+	return activity, nil
+}
+
+func (r *RepositoryClickhouse) topEditedGoods(ctx context.Context, projectID, limit int, from, to time.Time) ([]models.EditedGood, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			project_id,
+			sum(edits) AS edits
+		FROM logs_edits_daily
+		WHERE day >= toDate(toDateTime64(?, 6, 'UTC')) AND day <= toDate(toDateTime64(?, 6, 'UTC')) AND (? = 0 OR project_id = ?)
+		GROUP BY project_id, id
+		ORDER BY edits DESC, id
+		LIMIT ?`,
+		dateTime64(from), dateTime64(to), projectID, projectID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error to get top edited goods: %v", err)
+	}
+	defer rows.Close()
+
+	var goods = []models.EditedGood{}
+	for rows.Next() {
+		var good models.EditedGood
+		if err = rows.Scan(
+			&good.ID,
+			&good.ProjectID,
+			&good.Edits,
+		); err != nil {
+			return nil, fmt.Errorf("error to scan edited good: %v", err)
+		}
+		goods = append(goods, good)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error rows edited goods: %v", err)
+	}
+	return goods, nil
+}
+
+// history takes the latest changes of each id in column, which is set by the
+// callers above and never comes from user input.
+func (r *RepositoryClickhouse) history(ctx context.Context, column string, ids []int, limit int) ([]models.Log, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			project_id,
+			event,
+			name,
+			description,
+			priority,
+			removed,
+			version,
+			occurred_at
+		FROM logs
+		WHERE has(?, `+column+`)
+		ORDER BY occurred_at DESC, version DESC
+		LIMIT ? BY `+column,
+		chdriver.Array(ids), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error to get history: %v", err)
+	}
+	defer rows.Close()
+
+	var logs = []models.Log{}
+	for rows.Next() {
+		var l models.Log
+		if err = rows.Scan(
+			&l.ID,
+			&l.ProjectID,
+			&l.Event,
+			&l.Name,
+			&l.Description,
+			&l.Priority,
+			&l.Removed,
+			&l.Version,
+			&l.OccurredAt,
+		); err != nil {
+			return nil, fmt.Errorf("error to scan history: %v", err)
+		}
+		logs = append(logs, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error rows history: %v", err)
+	}
+	return logs, nil
+}
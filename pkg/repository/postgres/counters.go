@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// countersQuery sums the maintained goods counters of the project, or of all
+// projects for 0, instead of counting the goods table.
+const countersQuery = `
+	SELECT
+		COALESCE(SUM(total), 0),
+		COALESCE(SUM(removed), 0)
+	FROM goods_counters
+	WHERE $1 = 0 OR project_id = $1`
+
+// AddCounters shifts the counters of the project. It must run in the
+// transaction of the change it accounts for.
+func (r *RepositoryPostgres) AddCounters(ctx context.Context, projectID, total, removed int) error {
+	if _, err := r.conn(ctx).Exec(
+		ctx,
+		`INSERT INTO goods_counters (project_id, total, removed)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (project_id) DO UPDATE SET
+			total = goods_counters.total + EXCLUDED.total,
+			removed = goods_counters.removed + EXCLUDED.removed`,
+		projectID, total, removed,
+	); err != nil {
+		return fmt.Errorf("error to add goods counters: %v", err)
+	}
+	return nil
+}
+
+// ReconcileCounters recounts the goods of every project, overwrites the
+// counters that drifted and returns them as they were.
+func (r *RepositoryPostgres) ReconcileCounters(ctx context.Context) ([]models.CounterDrift, error) {
+	var drifts []models.CounterDrift
+	err := r.trManager.Do(ctx, func(ctx context.Context) error {
+		// Writers update the counters after their goods, so once they are done
+		// with the table the count below can't miss or double a change.
+		if _, err := r.conn(ctx).Exec(ctx, `LOCK TABLE goods_counters IN EXCLUSIVE MODE`); err != nil {
+			return fmt.Errorf("error to lock goods counters: %v", err)
+		}
+
+		rows, err := r.conn(ctx).Query(
+			ctx,
+			`SELECT
+				COALESCE(a.project_id, c.project_id) AS project_id,
+				COALESCE(c.total, 0) AS total,
+				COALESCE(c.removed, 0) AS removed,
+				COALESCE(a.total, 0) AS expected_total,
+				COALESCE(a.removed, 0) AS expected_removed
+			FROM (
+				SELECT project_id, COUNT(*)::INT AS total, (COUNT(*) FILTER (WHERE removed))::INT AS removed
+				FROM goods
+				GROUP BY project_id
+			) a
+			FULL JOIN goods_counters c ON c.project_id = a.project_id
+			WHERE COALESCE(c.total, 0) <> COALESCE(a.total, 0)
+				OR COALESCE(c.removed, 0) <> COALESCE(a.removed, 0)
+			ORDER BY project_id`)
+		if err != nil {
+			return fmt.Errorf("error to count goods: %v", err)
+		}
+
+		if drifts, err = pgx.CollectRows(rows, pgx.RowToStructByPos[models.CounterDrift]); err != nil {
+			return fmt.Errorf("error to scan counter drift: %v", err)
+		}
+
+		for _, d := range drifts {
+			if _, err = r.conn(ctx).Exec(
+				ctx,
+				`INSERT INTO goods_counters (project_id, total, removed)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (project_id) DO UPDATE SET
+					total = EXCLUDED.total,
+					removed = EXCLUDED.removed`,
+				d.ProjectID, d.ExpectedTotal, d.ExpectedRemoved,
+			); err != nil {
+				return fmt.Errorf("error to fix goods counters: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return drifts, nil
+}
@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+const exportBatch = 500
+
+// ExportGoods walks the goods of the project through a server-side cursor and
+// hands them to fn one by one, so memory use doesn't grow with the catalog.
+// removed filters on the removed state when it is not nil.
+func (r *RepositoryPostgres) ExportGoods(ctx context.Context, projectID int, removed *bool, fn func(models.Good) error) error {
+	tx, err := r.read(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(
+		ctx,
+		`DECLARE goods_export NO SCROLL CURSOR FOR
+		SELECT `+goodColumns+`
+		FROM goods
+		WHERE ($1 = 0 OR project_id = $1)
+			AND ($2::BOOLEAN IS NULL OR removed = $2)
+		ORDER BY id`,
+		projectID, removed,
+	); err != nil {
+		return fmt.Errorf("error to declare cursor: %v", err)
+	}
+
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH %d FROM goods_export", exportBatch))
+		if err != nil {
+			return fmt.Errorf("error to fetch goods: %v", err)
+		}
+
+		var fetched int
+		for rows.Next() {
+			good, err := rowToGood(rows)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("error to scan good: %v", err)
+			}
+			if err = fn(good); err != nil {
+				rows.Close()
+				return err
+			}
+			fetched++
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("error rows goods: %v", err)
+		}
+
+		if fetched < exportBatch {
+			return nil
+		}
+	}
+}
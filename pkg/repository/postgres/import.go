@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// foreign_key_violation, returned when the project does not exist.
+const codeForeignKeyViolation = "23503"
+
+type upsertRow struct {
+	models.Good
+	Search     pgtype.Text `db:"search"`
+	ExternalID pgtype.Text `db:"external_id"`
+	Inserted   bool        `db:"inserted"`
+}
+
+// UpsertGoods creates or updates the goods by external id in one batch. They
+// get priorities after every existing good, in the order of rows.
+func (r *RepositoryPostgres) UpsertGoods(ctx context.Context, projectID int, rows []models.ImportRow) ([]models.UpsertedGood, error) {
+	var upserted []models.UpsertedGood
+	err := r.trManager.Do(ctx, func(ctx context.Context) error {
+		var base int
+		if err := r.conn(ctx).QueryRow(ctx, `SELECT COALESCE(MAX(priority), 0) FROM goods`).Scan(&base); err != nil {
+			return fmt.Errorf("error to get max priority: %v", err)
+		}
+
+		batch := &pgx.Batch{}
+		for i, row := range rows {
+			batch.Queue(
+				`INSERT INTO
+					goods (project_id, external_id, name, description, priority)
+				VALUES
+					($1, $2, $3, $4, $5)
+				ON CONFLICT (project_id, external_id) WHERE external_id IS NOT NULL DO UPDATE SET
+					name = EXCLUDED.name,
+					description = EXCLUDED.description,
+					priority = EXCLUDED.priority,
+					version = goods.version + 1
+				RETURNING *, xmax = 0 AS inserted`,
+				projectID, row.Good.ExternalID, row.Good.Name, row.Good.Description, base+i+1)
+		}
+
+		results := r.conn(ctx).SendBatch(ctx, batch)
+		defer results.Close()
+
+		upserted = make([]models.UpsertedGood, 0, len(rows))
+		for range rows {
+			res, err := results.Query()
+			if err != nil {
+				return upsertError(err)
+			}
+			u, err := pgx.CollectOneRow(res, pgx.RowToStructByName[upsertRow])
+			if err != nil {
+				return upsertError(err)
+			}
+			upserted = append(upserted, models.UpsertedGood{Good: u.Good, Created: u.Inserted})
+		}
+		return results.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return upserted, nil
+}
+
+func upsertError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == codeForeignKeyViolation {
+		return fmt.Errorf("project %w", custerrors.ErrNotFound)
+	}
+	return fmt.Errorf("error to upsert good: %v", err)
+}
@@ -0,0 +1,311 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// jobColumns lists the jobs columns models.Job is made of, the input and the
+// result are only read by the worker and the download.
+const jobColumns = `id, kind, project_id, params, status, progress, total, result_type, error,
+	cancel_requested, attempts, created_at, started_at, finished_at`
+
+func (r *RepositoryPostgres) CreateJob(ctx context.Context, kind string, projectID int, params models.JobParams, input []byte) (models.Job, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`INSERT INTO jobs (kind, project_id, params, input)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+jobColumns,
+		kind, projectID, params, input)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("error to create job: %v", err)
+	}
+	return collectJob(rows)
+}
+
+// Job always reads the primary: a job is polled right after it is submitted.
+func (r *RepositoryPostgres) Job(ctx context.Context, jobID int64, projectID int) (models.Job, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`SELECT `+jobColumns+` FROM jobs WHERE id = $1 AND project_id = $2`,
+		jobID, projectID)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("error to get job: %v", err)
+	}
+	return collectJob(rows)
+}
+
+// ClaimJob marks the oldest queued job as running and returns it with its
+// input. Running jobs whose heartbeat is older than staleAfter are claimed
+// again, their worker is gone. SKIP LOCKED lets workers claim concurrently.
+func (r *RepositoryPostgres) ClaimJob(ctx context.Context, staleAfter time.Duration) (models.Job, []byte, bool, error) {
+	var (
+		job   models.Job
+		input []byte
+	)
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`UPDATE jobs
+		SET status = 'running',
+			attempts = attempts + 1,
+			started_at = now(),
+			heartbeat_at = now()
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE status = 'queued'
+				OR (status = 'running' AND heartbeat_at < now() - make_interval(secs => $1))
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+jobColumns+`, input`,
+		staleAfter.Seconds())
+	if err != nil {
+		return models.Job{}, nil, false, fmt.Errorf("error to claim job: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return models.Job{}, nil, false, fmt.Errorf("error to claim job: %v", err)
+		}
+		return models.Job{}, nil, false, nil
+	}
+	if err = rows.Scan(
+		&job.ID, &job.Kind, &job.ProjectID, &job.Params, &job.Status, &job.Progress, &job.Total,
+		&job.ResultType, &job.Error, &job.CancelRequested, &job.Attempts, &job.CreatedAt,
+		&job.StartedAt, &job.FinishedAt, &input,
+	); err != nil {
+		return models.Job{}, nil, false, fmt.Errorf("error to scan job: %v", err)
+	}
+	return job, input, true, nil
+}
+
+// HeartbeatJob records the progress of a running job and reports whether its
+// cancellation was requested. The attempt is the one the worker claimed: once
+// the job was claimed again it returns custerrors.ErrLeaseLost.
+func (r *RepositoryPostgres) HeartbeatJob(ctx context.Context, jobID int64, attempt, progress, total int) (bool, error) {
+	var cancelRequested bool
+	if err := r.conn(ctx).QueryRow(
+		ctx,
+		`UPDATE jobs
+		SET heartbeat_at = now(), progress = $3, total = $4
+		WHERE id = $1 AND status = 'running' AND attempts = $2
+		RETURNING cancel_requested`,
+		jobID, attempt, progress, total,
+	).Scan(&cancelRequested); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, custerrors.ErrLeaseLost
+		}
+		return false, fmt.Errorf("error to heartbeat job: %v", err)
+	}
+	return cancelRequested, nil
+}
+
+// AppendJobResult stores the next chunk of the result of a running job. It
+// returns custerrors.ErrLeaseLost once the job was claimed again.
+func (r *RepositoryPostgres) AppendJobResult(ctx context.Context, jobID int64, attempt, seq int, data []byte) error {
+	tag, err := r.conn(ctx).Exec(
+		ctx,
+		`INSERT INTO job_result_chunks (job_id, attempt, seq, data)
+		SELECT id, attempts, $3, $4
+		FROM jobs
+		WHERE id = $1 AND status = 'running' AND attempts = $2`,
+		jobID, attempt, seq, data)
+	if err != nil {
+		return fmt.Errorf("error to append job result: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return custerrors.ErrLeaseLost
+	}
+	return nil
+}
+
+// FinishJob stores the outcome of a running job if the worker still holds the
+// attempt it claimed, otherwise it returns custerrors.ErrLeaseLost and leaves
+// the job to the worker that claimed it again. The input is dropped, it is not
+// needed anymore, and so are the result chunks of the other attempts, or all
+// of them unless the job succeeded.
+func (r *RepositoryPostgres) FinishJob(ctx context.Context, jobID int64, attempt int, status string, progress, total int, resultType, errMsg string) (models.Job, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`WITH finished AS (
+			UPDATE jobs
+			SET status = $3,
+				progress = $4,
+				total = $5,
+				result_type = $6,
+				error = $7,
+				input = NULL,
+				finished_at = now()
+			WHERE id = $1 AND status = 'running' AND attempts = $2
+			RETURNING `+jobColumns+`
+		), dropped AS (
+			DELETE FROM job_result_chunks
+			WHERE job_id IN (SELECT id FROM finished)
+				AND (attempt <> $2 OR $3 <> 'succeeded')
+		)
+		SELECT `+jobColumns+` FROM finished`,
+		jobID, attempt, status, progress, total, resultType, errMsg)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("error to finish job: %v", err)
+	}
+
+	job, err := collectJob(rows)
+	if errors.Is(err, custerrors.ErrNotFound) {
+		return models.Job{}, custerrors.ErrLeaseLost
+	}
+	return job, err
+}
+
+// RequeueJob gives a running job back to the queue, when its worker stops
+// before the job is done. The attempt is not counted, only those that stalled
+// are, and the result it stored is dropped as the next claim reuses its
+// number. A job claimed again since is left alone.
+func (r *RepositoryPostgres) RequeueJob(ctx context.Context, jobID int64, attempt int) error {
+	if _, err := r.conn(ctx).Exec(
+		ctx,
+		`WITH requeued AS (
+			UPDATE jobs
+			SET status = 'queued', attempts = attempts - 1, started_at = NULL, heartbeat_at = NULL
+			WHERE id = $1 AND status = 'running' AND attempts = $2
+			RETURNING id
+		)
+		DELETE FROM job_result_chunks
+		WHERE job_id IN (SELECT id FROM requeued) AND attempt = $2`,
+		jobID, attempt,
+	); err != nil {
+		return fmt.Errorf("error to requeue job: %v", err)
+	}
+	return nil
+}
+
+// canceledJob tells whether CancelJob canceled the job itself.
+type canceledJob struct {
+	models.Job
+	Previous string `db:"previous"`
+}
+
+// CancelJob cancels a queued job at once; a running one is flagged and stopped
+// by its worker at the next heartbeat. Finished jobs are returned unchanged.
+// The flag reports whether the job went from queued to canceled.
+func (r *RepositoryPostgres) CancelJob(ctx context.Context, jobID int64, projectID int) (models.Job, bool, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`UPDATE jobs
+		SET cancel_requested = cancel_requested OR status IN ('queued', 'running'),
+			input = CASE WHEN status = 'queued' THEN NULL ELSE input END,
+			finished_at = CASE WHEN status = 'queued' THEN now() ELSE finished_at END,
+			status = CASE WHEN status = 'queued' THEN 'canceled' ELSE status END
+		FROM (
+			SELECT id AS job_id, status AS previous
+			FROM jobs
+			WHERE id = $1 AND project_id = $2
+			FOR UPDATE
+		) p
+		WHERE id = p.job_id
+		RETURNING `+jobColumns+`, p.previous`,
+		jobID, projectID)
+	if err != nil {
+		return models.Job{}, false, fmt.Errorf("error to cancel job: %v", err)
+	}
+
+	job, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[canceledJob])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Job{}, false, custerrors.ErrNotFound
+		}
+		return models.Job{}, false, fmt.Errorf("error to scan job: %v", err)
+	}
+	return job.Job, job.Previous == models.JobQueued, nil
+}
+
+// JobResult returns the type and the size of the result of a job that
+// succeeded, WriteJobResult streams it.
+func (r *RepositoryPostgres) JobResult(ctx context.Context, jobID int64, projectID int) (models.JobResult, error) {
+	var result models.JobResult
+	if err := r.conn(ctx).QueryRow(
+		ctx,
+		`SELECT j.result_type, COALESCE(sum(length(c.data)), 0)
+		FROM jobs j
+		LEFT JOIN job_result_chunks c ON c.job_id = j.id AND c.attempt = j.attempts
+		WHERE j.id = $1 AND j.project_id = $2 AND j.status = 'succeeded'
+		GROUP BY j.id`,
+		jobID, projectID,
+	).Scan(&result.Type, &result.Size); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.JobResult{}, custerrors.ErrNotFound
+		}
+		return models.JobResult{}, fmt.Errorf("error to get job result: %v", err)
+	}
+	return result, nil
+}
+
+// WriteJobResult writes the result chunks of a job that succeeded to w as they
+// are read, the result is never held whole.
+func (r *RepositoryPostgres) WriteJobResult(ctx context.Context, jobID int64, projectID int, w io.Writer) error {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`SELECT c.data
+		FROM job_result_chunks c
+		JOIN jobs j ON j.id = c.job_id AND j.attempts = c.attempt
+		WHERE j.id = $1 AND j.project_id = $2 AND j.status = 'succeeded'
+		ORDER BY c.seq`,
+		jobID, projectID)
+	if err != nil {
+		return fmt.Errorf("error to read job result: %v", err)
+	}
+	defer rows.Close()
+
+	var data []byte
+	for rows.Next() {
+		if err = rows.Scan(&data); err != nil {
+			return fmt.Errorf("error to scan job result: %v", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error to read job result: %v", err)
+	}
+	return nil
+}
+
+// PurgeGoods deletes the removed goods of the project for good and returns
+// them, so their removal can be logged.
+func (r *RepositoryPostgres) PurgeGoods(ctx context.Context, projectID int) ([]models.Good, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`DELETE FROM goods WHERE project_id = $1 AND removed = true
+		RETURNING `+goodColumns,
+		projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error to purge goods: %v", err)
+	}
+
+	goods, err := pgx.CollectRows(rows, rowToGood)
+	if err != nil {
+		return nil, fmt.Errorf("error to scan goods: %v", err)
+	}
+	return goods, nil
+}
+
+func collectJob(rows pgx.Rows) (models.Job, error) {
+	job, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Job])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Job{}, custerrors.ErrNotFound
+		}
+		return models.Job{}, fmt.Errorf("error to scan job: %v", err)
+	}
+	return job, nil
+}
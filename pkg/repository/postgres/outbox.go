@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type outboxEvent struct {
+	ID      int64  `db:"id"`
+	Payload []byte `db:"payload"`
+}
+
+// CreateOutbox queues goods events for the relay. It must run in the
+// transaction of the changes they describe.
+func (r *RepositoryPostgres) CreateOutbox(ctx context.Context, payloads [][]byte) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	if _, err := r.conn(ctx).Exec(
+		ctx,
+		`INSERT INTO outbox (payload) SELECT unnest($1::BYTEA[])`,
+		payloads,
+	); err != nil {
+		return fmt.Errorf("error to create outbox events: %v", err)
+	}
+	return nil
+}
+
+// RelayOutbox hands up to limit committed events to publish, oldest first, and
+// deletes them. If publish fails they are kept for the next relay, so an event
+// may be published twice but never lost. Concurrent relays skip the events
+// another one holds.
+func (r *RepositoryPostgres) RelayOutbox(ctx context.Context, limit int, publish func(payload []byte) error) (int, error) {
+	var relayed int
+	err := r.trManager.Do(ctx, func(ctx context.Context) error {
+		rows, err := r.conn(ctx).Query(
+			ctx,
+			`DELETE FROM outbox
+			WHERE id IN (
+				SELECT id
+				FROM outbox
+				ORDER BY id
+				FOR UPDATE SKIP LOCKED
+				LIMIT $1
+			)
+			RETURNING id, payload`,
+			limit)
+		if err != nil {
+			return fmt.Errorf("error to take outbox events: %v", err)
+		}
+
+		events, err := pgx.CollectRows(rows, pgx.RowToStructByName[outboxEvent])
+		if err != nil {
+			return fmt.Errorf("error to scan outbox event: %v", err)
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+		for _, event := range events {
+			if err = publish(event.Payload); err != nil {
+				return err
+			}
+		}
+		relayed = len(events)
+		return nil
+	})
+	return relayed, err
+}
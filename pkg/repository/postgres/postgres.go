@@ -2,16 +2,64 @@ package postgres
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/Hymiside/hezzl-api/pkg/models"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewPostgresDB(ctx context.Context, cfg models.ConfigPostgres) (*sql.DB, error) {
+const connectTimeout = 5 * time.Second
+
+func NewPostgresDB(ctx context.Context, cfg models.ConfigPostgres) (*pgxpool.Pool, error) {
+	db, err := OpenPostgresDB(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connection test error: %w", err)
+	}
+
+	return db, nil
+}
+
+// OpenPostgresDB creates the pool without waiting for the server to answer.
+func OpenPostgresDB(ctx context.Context, cfg models.ConfigPostgres) (*pgxpool.Pool, error) {
 	psqlInfo := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database)
-	db, err := sql.Open("postgres", psqlInfo)
+	poolCfg, err := pgxpool.ParseConfig(psqlInfo)
+	if err != nil {
+		return nil, fmt.Errorf("error to parse postgres config: %v", err)
+	}
+
+	if poolCfg.ConnConfig.ConnectTimeout == 0 {
+		poolCfg.ConnConfig.ConnectTimeout = connectTimeout
+	}
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+	poolCfg.MinConns = cfg.MinConns
+	if cfg.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+
+	// Every query is prepared once per connection and then reused by name.
+	poolCfg.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if cfg.StatementCacheCapacity > 0 {
+		poolCfg.ConnConfig.StatementCacheCapacity = cfg.StatementCacheCapacity
+	}
+	// The server cancels any single statement running longer than the timeout.
+	if cfg.QueryTimeout > 0 {
+		poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.QueryTimeout.Milliseconds(), 10)
+	}
+
+	db, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, fmt.Errorf("error to connection postgres: %v", err)
 	}
@@ -20,9 +68,5 @@ func NewPostgresDB(ctx context.Context, cfg models.ConfigPostgres) (*sql.DB, err
 		db.Close()
 	}(ctx)
 
-	if err = db.Ping(); err != nil {
-		return nil, fmt.Errorf("connection test error: %w", err)
-	}
-
 	return db, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Projects returns the projects with the given ids that exist, with their
+// counters, in one query.
+func (r *RepositoryPostgres) Projects(ctx context.Context, projectIDs []int) ([]models.ProjectSummary, error) {
+	rows, err := r.read(ctx).Query(
+		ctx,
+		`SELECT
+			p.id,
+			p.name,
+			p.search_language::TEXT AS search_language,
+			p.created_at,
+			COALESCE(c.total, 0) AS total,
+			COALESCE(c.removed, 0) AS removed
+		FROM projects p
+		LEFT JOIN goods_counters c ON c.project_id = p.id
+		WHERE p.id = ANY($1)
+		ORDER BY p.id`,
+		projectIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error to get projects: %v", err)
+	}
+
+	projects, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.ProjectSummary])
+	if err != nil {
+		return nil, fmt.Errorf("error to scan project: %v", err)
+	}
+	return projects, nil
+}
+
+// ProjectsGoods returns the first limit goods of each of the projects, in
+// priority order, in one query. removed filters on the flag when set.
+func (r *RepositoryPostgres) ProjectsGoods(ctx context.Context, projectIDs []int, limit int, removed *bool) ([]models.Good, error) {
+	rows, err := r.read(ctx).Query(
+		ctx,
+		`SELECT `+goodColumns+`
+		FROM (
+			SELECT
+				`+goodColumns+`,
+				row_number() OVER (PARTITION BY project_id ORDER BY priority, id) AS n
+			FROM goods
+			WHERE project_id = ANY($1) AND ($3::BOOLEAN IS NULL OR removed = $3)
+		) g
+		WHERE n <= $2
+		ORDER BY project_id, priority, id`,
+		projectIDs, limit, removed)
+	if err != nil {
+		return nil, fmt.Errorf("error to get goods: %v", err)
+	}
+
+	goods, err := pgx.CollectRows(rows, rowToGood)
+	if err != nil {
+		return nil, fmt.Errorf("error to scan good: %v", err)
+	}
+	return goods, nil
+}
@@ -0,0 +1,142 @@
+package postgres
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+	log "github.com/sirupsen/logrus"
+)
+
+// lagQuery is zero when the replica has replayed everything it received, so an
+// idle primary does not make its replicas look stale. It is NULL when the node
+// is not a replica or is not streaming from the primary, since then it may
+// have received nothing new for any time.
+const lagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN NULL
+		WHEN NOT EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming') THEN NULL
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+	END`
+
+type replica struct {
+	addr    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// Replicas balances reads over the replicas that answer and are no further
+// than the max lag behind the primary.
+type Replicas struct {
+	replicas []*replica
+	cfg      models.ConfigReplicas
+	next     atomic.Uint64
+}
+
+// NewReplicas opens a pool per replica with the settings of the primary and
+// checks them every interval until ctx is done.
+func NewReplicas(ctx context.Context, primary models.ConfigPostgres, cfg models.ConfigReplicas) (*Replicas, error) {
+	r := &Replicas{cfg: cfg}
+	for _, addr := range cfg.Hosts {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			host, port = addr, primary.Port
+		}
+
+		replicaCfg := primary
+		replicaCfg.Host, replicaCfg.Port = host, port
+		pool, err := OpenPostgresDB(ctx, replicaCfg)
+		if err != nil {
+			return nil, err
+		}
+		r.replicas = append(r.replicas, &replica{addr: addr, pool: pool})
+	}
+
+	r.check(ctx)
+	go r.run(ctx)
+	return r, nil
+}
+
+// reader returns a healthy replica in turn, or nil if there is none.
+func (r *Replicas) reader() *pgxpool.Pool {
+	if r == nil {
+		return nil
+	}
+
+	n := len(r.replicas)
+	start := r.next.Add(1)
+	for i := 0; i < n; i++ {
+		rep := r.replicas[(start+uint64(i))%uint64(n)]
+		if rep.healthy.Load() {
+			return rep.pool
+		}
+	}
+	return nil
+}
+
+// InRotation counts the replicas reads are balanced over, out of all of them.
+func (r *Replicas) InRotation() (int, int) {
+	var n int
+	for _, rep := range r.replicas {
+		if rep.healthy.Load() {
+			n++
+		}
+	}
+	return n, len(r.replicas)
+}
+
+// Pools returns the pool of each replica by its address.
+func (r *Replicas) Pools() map[string]*pgxpool.Pool {
+	pools := make(map[string]*pgxpool.Pool, len(r.replicas))
+	for _, rep := range r.replicas {
+		pools[rep.addr] = rep.pool
+	}
+	return pools
+}
+
+func (r *Replicas) run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.check(ctx)
+		}
+	}
+}
+
+func (r *Replicas) check(ctx context.Context) {
+	for _, rep := range r.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, r.cfg.CheckInterval)
+		var lag *float64
+		err := rep.pool.QueryRow(checkCtx, lagQuery).Scan(&lag)
+		cancel()
+
+		var lagDuration time.Duration
+		if lag != nil {
+			lagDuration = time.Duration(*lag * float64(time.Second))
+		}
+		healthy := err == nil && lag != nil && lagDuration <= r.cfg.MaxLag
+		if rep.healthy.Swap(healthy) == healthy {
+			continue
+		}
+
+		switch {
+		case healthy:
+			log.Infof("postgres replica %s is in rotation", rep.addr)
+		case err != nil:
+			log.Warnf("postgres replica %s is out of rotation: %v", rep.addr, err)
+		case lag == nil:
+			log.Warnf("postgres replica %s is out of rotation: not streaming from the primary", rep.addr)
+		default:
+			log.Warnf("postgres replica %s is out of rotation: lag %s over %s", rep.addr, lagDuration, r.cfg.MaxLag)
+		}
+	}
+}
@@ -2,109 +2,143 @@ package postgres
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 
+	"github.com/Hymiside/hezzl-api/pkg/consistency"
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	trmpgx "github.com/avito-tech/go-transaction-manager/drivers/pgxv5/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// goodColumns lists the goods columns models.Good is made of.
+const goodColumns = "id, project_id, name, description, priority, removed, created_at, version"
+
+// goodRow also holds the columns of goods that are not part of models.Good, so
+// RETURNING * scans into it.
+type goodRow struct {
+	models.Good
+	Search     pgtype.Text `db:"search"`
+	ExternalID pgtype.Text `db:"external_id"`
+}
+
+func rowToGood(row pgx.CollectableRow) (models.Good, error) {
+	good, err := pgx.RowToStructByNameLax[goodRow](row)
+	return good.Good, err
+}
+
+// RepositoryPostgres runs every query in the transaction carried by ctx, if
+// there is one. Methods made of several statements open their own transaction
+// with trManager, which joins the caller's one when it is already started.
 type RepositoryPostgres struct {
-	db *sql.DB
+	db        *pgxpool.Pool
+	replicas  *Replicas
+	getter    *trmpgx.CtxGetter
+	trManager *manager.Manager
+}
+
+// NewRepositoryPostgres creates the repository. replicas may be nil, then
+// every read goes to db.
+func NewRepositoryPostgres(db *pgxpool.Pool, trManager *manager.Manager, replicas *Replicas) *RepositoryPostgres {
+	return &RepositoryPostgres{
+		db:        db,
+		replicas:  replicas,
+		getter:    trmpgx.DefaultCtxGetter,
+		trManager: trManager,
+	}
 }
 
-func NewRepositoryPostgres(db *sql.DB) *RepositoryPostgres {
-	return &RepositoryPostgres{db: db}
+func (r *RepositoryPostgres) conn(ctx context.Context) trmpgx.Tr {
+	return r.getter.DefaultTrOrDB(ctx, r.db)
+}
+
+// read is conn for queries that may see a slightly stale state: outside of a
+// transaction they go to a replica unless ctx asks for the primary.
+func (r *RepositoryPostgres) read(ctx context.Context) trmpgx.Tr {
+	if consistency.Primary(ctx) || r.getter.DefaultTrOrDB(ctx, nil) != nil {
+		return r.conn(ctx)
+	}
+	if replica := r.replicas.reader(); replica != nil {
+		return replica
+	}
+	return r.db
 }
 
 func (r *RepositoryPostgres) Goods(ctx context.Context) ([]models.Good, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT id, name, description, priority, removed, created_at FROM goods")
+	rows, err := r.read(ctx).Query(ctx, `SELECT `+goodColumns+` FROM goods ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("error to get goods: %v", err)
 	}
 
-	var goods []models.Good
-	for rows.Next() {
-		var good models.Good
-		err = rows.Scan(
-			&good.ID,
-			&good.Name,
-			&good.Description,
-			&good.Priority,
-			&good.Removed,
-			&good.CreatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error to scan goods: %v", err)
-		}
-		goods = append(goods, good)
+	goods, err := pgx.CollectRows(rows, rowToGood)
+	if err != nil {
+		return nil, fmt.Errorf("error to scan goods: %v", err)
 	}
 	return goods, nil
 }
 
-func (r *RepositoryPostgres) GoodsWithLimitAndOffset(ctx context.Context, limit, offset int) (models.GoodsResponse, error) {
-	tx, err := r.db.BeginTx(ctx, nil)
+// ProjectGoods always reads the primary: the goods are loaded into the cache,
+// which must not go back in time.
+func (r *RepositoryPostgres) ProjectGoods(ctx context.Context, projectID int) ([]models.Good, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`SELECT `+goodColumns+`
+		FROM goods
+		WHERE $1 = 0 OR project_id = $1
+		ORDER BY priority, id`,
+		projectID)
 	if err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error to begin transaction: %v", err)
+		return nil, fmt.Errorf("error to get goods: %v", err)
 	}
-	defer tx.Rollback()
 
-	rows, err := tx.QueryContext(
-		ctx, 
-		`SELECT 
-			id,  
-			name,
-			description,
-			priority,
-			removed,
-			created_at
-		FROM goods LIMIT $1 OFFSET $2`,
-		limit, offset)
+	goods, err := pgx.CollectRows(rows, rowToGood)
 	if err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
-	}
-
-	var goods = []models.Good{}
-	for rows.Next() {
-		var good models.Good
-		err = rows.Scan(
-			&good.ID,
-			&good.Name,
-			&good.Description,
-			&good.Priority,
-			&good.Removed,
-			&good.CreatedAt,
-		)
-		if err != nil {
-			return models.GoodsResponse{}, fmt.Errorf("error to scan good: %v", err)
-		}
-		goods = append(goods, good)
-	}
-
-	if err = rows.Err(); err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error rows goods: %v", err)
+		return nil, fmt.Errorf("error to scan good: %v", err)
 	}
+	return goods, nil
+}
 
-	totalGoods, err := r.totalGoods(ctx, tx)
+func (r *RepositoryPostgres) GoodsWithLimitAndOffset(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error) {
+	// The page and the counters are sent in one batch, so they run in one
+	// implicit transaction on the same server.
+	batch := &pgx.Batch{}
+	batch.Queue(
+		`SELECT `+goodColumns+`
+		FROM goods
+		WHERE $3 = 0 OR project_id = $3
+		ORDER BY priority, id
+		LIMIT $1 OFFSET $2`,
+		limit, offset, projectID)
+	batch.Queue(countersQuery, projectID)
+
+	results := r.read(ctx).SendBatch(ctx, batch)
+	defer results.Close()
+
+	rows, err := results.Query()
 	if err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error to get total goods: %v", err)
+		return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
 	}
 
-	totalRemovedGoods, err := r.totalRemovedGoods(ctx, tx)
+	goods, err := pgx.CollectRows(rows, rowToGood)
 	if err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error to get total removed goods: %v", err)
+		return models.GoodsResponse{}, fmt.Errorf("error to scan good: %v", err)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error to commit transaction: %v", err)
+	var totalGoods, totalRemovedGoods int
+	if err = results.QueryRow().Scan(&totalGoods, &totalRemovedGoods); err != nil {
+		return models.GoodsResponse{}, fmt.Errorf("error to get goods counters: %v", err)
 	}
 
 	goodsResponse := models.GoodsResponse{
 		Goods: goods,
 		Meta: models.Meta{
 			Limit:   limit,
-			Ofset:   offset,
+			Offset:  offset,
 			Total:   totalGoods,
 			Removed: totalRemovedGoods,
 		},
@@ -112,257 +146,184 @@ func (r *RepositoryPostgres) GoodsWithLimitAndOffset(ctx context.Context, limit,
 	return goodsResponse, nil
 }
 
-func (r *RepositoryPostgres) totalGoods(ctx context.Context, tx *sql.Tx) (int, error) {
-	row := r.db.QueryRowContext(ctx, `SELECT COUNT(id) FROM goods`)
-	if row.Err() != nil {
-		return 0, fmt.Errorf("error to get total goods: %v", row.Err())
-	}
-
-	var total int
-	if err := row.Scan(&total); err != nil {
-		return 0, fmt.Errorf("error to scan total goods: %v", err)
-	}
-	return total, nil
-}
-
-func (r *RepositoryPostgres) totalRemovedGoods(ctx context.Context, tx *sql.Tx) (int, error) {
-	row := r.db.QueryRowContext(ctx, `SELECT COUNT(id) FROM goods WHERE removed = true`)
-	if err := row.Err(); err != nil {
-		return 0, fmt.Errorf("error to get total removed goods: %v", err)
-	}
-
-	var total int
-	if err := row.Scan(&total); err != nil {
-		return 0, fmt.Errorf("error to scan total removed goods: %v", err)
+func (r *RepositoryPostgres) Good(ctx context.Context, goodID, projectID int) (models.Good, error) {
+	rows, err := r.read(ctx).Query(
+		ctx,
+		`SELECT `+goodColumns+`
+		FROM goods
+		WHERE id = $1 AND project_id = $2`,
+		goodID, projectID)
+	if err != nil {
+		return models.Good{}, fmt.Errorf("error to get good: %v", err)
 	}
-	return total, nil
+	return collectGood(rows)
 }
 
 func (r *RepositoryPostgres) CreateGood(ctx context.Context, projectID int, name string) (models.Good, error) {
-	row := r.db.QueryRowContext(
-		ctx, 
-		`INSERT INTO 
-			goods (project_id, name, priority) 
-		VALUES 
-			($1, $2, (SELECT COALESCE(MAX(priority), 0) + 1 FROM goods)) 
-		RETURNING id`, 
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`INSERT INTO
+			goods (project_id, name, priority)
+		VALUES
+			($1, $2, (SELECT COALESCE(MAX(priority), 0) + 1 FROM goods))
+		RETURNING *`,
 		projectID, name)
-	if err := row.Err(); err != nil {
+	if err != nil {
 		return models.Good{}, fmt.Errorf("error to create good: %v", err)
 	}
-
-	var goodID int
-	if err := row.Scan(&goodID); err != nil {
-		return models.Good{}, fmt.Errorf("error to scan id: %v", err)
-	}
-
-	row = r.db.QueryRowContext(
-		ctx, 
-		`SELECT 
-			id, 
-			project_id,
-			name, 
-			description, 
-			priority, 
-			removed, 
-			created_at 
-		FROM goods 
-		WHERE id = $1`, 
-		goodID)
-	if err := row.Err(); err != nil {
-		return models.Good{}, fmt.Errorf("error to get good: %v", err)
-	}
-
-	var good models.Good
-	if err := row.Scan(
-		&good.ID,
-		&good.ProjectID,
-		&good.Name,
-		&good.Description,
-		&good.Priority,
-		&good.Removed,
-		&good.CreatedAt,
-	); err != nil {
-		return models.Good{}, fmt.Errorf("error to scan good: %v", err)
-	}
-
-	return good, nil
+	return collectGood(rows)
 }
 
 func (r *RepositoryPostgres) UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error) {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return models.Good{}, err
-	}
-
-	row := tx.QueryRowContext(
+	rows, err := r.conn(ctx).Query(
 		ctx,
-		`UPDATE 
-			goods 
-		SET 
-			name = CASE WHEN $1 = '' THEN name ELSE $1 END, 
+		`UPDATE
+			goods
+		SET
+			name = CASE WHEN $1 = '' THEN name ELSE $1 END,
 			description = CASE WHEN $2 = '' THEN description ELSE $2 END,
 			priority = CASE WHEN $3 = 0 THEN priority ELSE $3 END,
-			removed = CASE WHEN $4 = false THEN removed ELSE $4 END
-		WHERE 
-			id = $5 AND project_id = $6 AND removed = false 
-		RETURNING id`,
+			removed = CASE WHEN $4 = false THEN removed ELSE $4 END,
+			version = version + 1
+		WHERE
+			id = $5 AND project_id = $6 AND removed = false
+		RETURNING *`,
 		good.Name,
 		good.Description,
 		good.Priority,
 		good.Removed,
 		goodID,
 		projectID)
-	if err := row.Err(); err != nil {
+	if err != nil {
 		return models.Good{}, fmt.Errorf("error to update good: %v", err)
 	}
-
-	var id int
-	if err := row.Scan(&id); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return models.Good{}, custerrors.ErrNotFound
-		}
-		return models.Good{}, fmt.Errorf("error to scan id: %v", err)
-	}
-
-	row = tx.QueryRowContext(
-		ctx,
-		`SELECT 
-			id, 
-			project_id,
-			name, 
-			description, 
-			priority, 
-			removed, 
-			created_at 
-		FROM goods 
-		WHERE id = $1`, 
-		id)
-	if err := row.Err(); err != nil {
-		return models.Good{}, fmt.Errorf("error to get good: %v", err)
-	}
-
-	var updatedGood models.Good
-	if err := row.Scan(
-		&updatedGood.ID,
-		&updatedGood.ProjectID,
-		&updatedGood.Name,
-		&updatedGood.Description,
-		&updatedGood.Priority,
-		&updatedGood.Removed,
-		&updatedGood.CreatedAt,
-	); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return models.Good{}, custerrors.ErrNotFound
-		}
-		return models.Good{}, fmt.Errorf("error to scan good: %v", err)
-	}
-	
-	if err = tx.Commit(); err != nil {
-		return models.Good{}, fmt.Errorf("error to commit transaction: %v", err)
-	}
-	return updatedGood, nil
+	return collectGood(rows)
 }
 
 func (r *RepositoryPostgres) DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error) {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return models.Good{}, err
-	}
-	defer tx.Rollback()
-
-	row := r.db.QueryRowContext(
-		ctx, 
-		`UPDATE goods 
-		SET removed = true 
-		WHERE id = $1 
-			AND project_id = $2 
-			AND removed = false 
-		RETURNING id`, 
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`UPDATE goods
+		SET removed = true, version = version + 1
+		WHERE id = $1
+			AND project_id = $2
+			AND removed = false
+		RETURNING *`,
 		goodID, projectID)
-	if err := row.Err(); err != nil {
+	if err != nil {
 		return models.Good{}, fmt.Errorf("error to delete good: %v", err)
 	}
-
-	var id int
-	if err := row.Scan(&id); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return models.Good{}, custerrors.ErrNotFound
-		}
-		return models.Good{}, fmt.Errorf("error to scan id: %v", err)
-	}
-
-	removedGood := models.Good{
-		ID: id,
-		ProjectID: projectID,
-		Removed: true,
-	}
-
-	if err = tx.Commit(); err != nil {
-		return models.Good{}, fmt.Errorf("error to commit transaction: %v", err)
-	}
-	return removedGood, nil
+	return collectGood(rows)
 }
 
-func (r *RepositoryPostgres) ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.ReprioritizeGoodResponse, error) {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(
-		ctx, 
+func (r *RepositoryPostgres) ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.Good, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
 		`UPDATE goods
-			SET priority = priority + $1
+			SET priority = priority + $1, version = version + 1
 		WHERE id >= (
 			SELECT id
 			FROM goods
 			WHERE id = $2 AND project_id = $3
-		)`,
-		priority, goodID, projectID,
-	); err != nil {
+		)
+		RETURNING *`,
+		priority, goodID, projectID)
+	if err != nil {
 		return nil, fmt.Errorf("error to reprioritize good: %v", err)
 	}
 
-	rows, err := tx.QueryContext(
-		ctx,
-		`SELECT 
-			id, 
-			priority
-		FROM goods 
-		WHERE id >= (
-			SELECT id
-			FROM goods
-			WHERE id = $1 AND project_id = $2
-		)`, 
-		goodID, projectID)
+	reprioritizedGoods, err := pgx.CollectRows(rows, rowToGood)
 	if err != nil {
-		return nil, fmt.Errorf("error to get good: %v", err)
+		return nil, fmt.Errorf("error to scan good: %v", err)
+	}
+	if len(reprioritizedGoods) == 0 {
+		return nil, custerrors.ErrNotFound
 	}
+	return reprioritizedGoods, nil
+}
 
-	var reprioritizedGoods []models.ReprioritizeGoodResponse
-	for rows.Next() {
-		var reprioritizedGood models.ReprioritizeGoodResponse
-		if err := rows.Scan(
-			&reprioritizedGood.ID,
-			&reprioritizedGood.Priority,
-		); err != nil {
-			return nil, fmt.Errorf("error to scan good: %v", err)
+// RestoreGoods writes the projects and goods rebuilt from the log over the
+// current ones. When pruning, the goods neither restored nor in keep are
+// deleted.
+func (r *RepositoryPostgres) RestoreGoods(ctx context.Context, projects []models.Project, goods []models.Good, prune bool, keep []int) error {
+	return r.trManager.Do(ctx, func(ctx context.Context) error {
+		for _, project := range projects {
+			projectID, err := strconv.Atoi(project.ID)
+			if err != nil {
+				return fmt.Errorf("error to parse project id %q: %v", project.ID, err)
+			}
+			if _, err := r.conn(ctx).Exec(
+				ctx,
+				`INSERT INTO projects (id, name, created_at)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (id) DO UPDATE SET
+					name = EXCLUDED.name`,
+				projectID, project.Name, project.CreatedAt,
+			); err != nil {
+				return fmt.Errorf("error to restore project: %v", err)
+			}
 		}
-		reprioritizedGoods = append(reprioritizedGoods, reprioritizedGood)
-	}
 
-	if reprioritizedGoods == nil {
-		return nil, custerrors.ErrNotFound
-	}
+		ids := make([]int64, 0, len(goods)+len(keep))
+		for _, id := range keep {
+			ids = append(ids, int64(id))
+		}
+		for _, good := range goods {
+			if _, err := r.conn(ctx).Exec(
+				ctx,
+				`INSERT INTO
+					goods (id, project_id, name, description, priority, removed, created_at)
+				VALUES
+					($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (id) DO UPDATE SET
+					project_id = EXCLUDED.project_id,
+					name = EXCLUDED.name,
+					description = EXCLUDED.description,
+					priority = EXCLUDED.priority,
+					removed = EXCLUDED.removed,
+					version = goods.version + 1`,
+				good.ID,
+				good.ProjectID,
+				good.Name,
+				good.Description,
+				good.Priority,
+				good.Removed,
+				good.CreatedAt,
+			); err != nil {
+				return fmt.Errorf("error to restore good: %v", err)
+			}
+			ids = append(ids, int64(good.ID))
+		}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error to get good: %v", err)
-	}
+		if prune {
+			if _, err := r.conn(ctx).Exec(ctx, `DELETE FROM goods WHERE id <> ALL($1)`, ids); err != nil {
+				return fmt.Errorf("error to prune goods: %v", err)
+			}
+		}
+
+		if _, err := r.conn(ctx).Exec(
+			ctx,
+			`SELECT
+				setval('projects_id_seq', GREATEST((SELECT MAX(id) FROM projects), 1)),
+				setval('goods_id_seq', GREATEST((SELECT MAX(id) FROM goods), 1))`,
+		); err != nil {
+			return fmt.Errorf("error to reset sequences: %v", err)
+		}
+		// The counters of every restored project are recomputed in the same transaction.
+		if _, err := r.ReconcileCounters(ctx); err != nil {
+			return err
+		}
+		return nil
+	})
+}
 
-	if err = tx.Commit(); err != nil {
-		return nil, fmt.Errorf("error to commit transaction: %v", err)
+func collectGood(rows pgx.Rows) (models.Good, error) {
+	good, err := pgx.CollectOneRow(rows, rowToGood)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Good{}, custerrors.ErrNotFound
+		}
+		return models.Good{}, fmt.Errorf("error to scan good: %v", err)
 	}
-	return reprioritizedGoods, nil
-}
\ No newline at end of file
+	return good, nil
+}
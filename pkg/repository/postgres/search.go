@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// undefined_object, returned for an unknown text search configuration.
+const codeUndefinedObject = "42704"
+
+// searchQuery ranks the goods matching the query in the language of their
+// project. Snippets are only built for the page, ts_headline being costly, and
+// over escaped text since the highlights are HTML.
+var searchQuery = `
+	WITH matches AS (
+		SELECT
+			g.id, g.project_id, g.name, g.description, g.priority, g.removed, g.created_at, g.version,
+			p.search_language AS language,
+			q.query,
+			ts_rank(g.search, q.query) AS rank
+		FROM goods g
+		JOIN projects p ON p.id = g.project_id
+		CROSS JOIN LATERAL websearch_to_tsquery(p.search_language, $2) AS q(query)
+		WHERE ($1 = 0 OR g.project_id = $1)
+			AND g.removed = false
+			AND g.search @@ q.query
+	), page AS (
+		SELECT * FROM matches
+		ORDER BY rank DESC, id
+		LIMIT $3 OFFSET $4
+	)
+	SELECT
+		id, project_id, name, description, priority, removed, created_at, version,
+		rank,
+		ts_headline(language, ` + escapeHTML("name") + `, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS name_highlight,
+		ts_headline(language, ` + escapeHTML("description") + `, query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5') AS description_highlight,
+		(SELECT COUNT(*) FROM matches) AS total
+	FROM page
+	ORDER BY rank DESC, id`
+
+// searchTotalQuery counts the matches when the page is past the last of them.
+const searchTotalQuery = `
+	SELECT COUNT(*)
+	FROM goods g
+	JOIN projects p ON p.id = g.project_id
+	WHERE ($1 = 0 OR g.project_id = $1)
+		AND g.removed = false
+		AND g.search @@ websearch_to_tsquery(p.search_language, $2)`
+
+// escapeHTML is the SQL expression escaping the column as html.EscapeString does.
+func escapeHTML(column string) string {
+	for _, r := range [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&#34;"}, {"'", "&#39;"}} {
+		column = fmt.Sprintf("replace(%s, '%s', '%s')", column, strings.ReplaceAll(r[0], "'", "''"), r[1])
+	}
+	return column
+}
+
+type searchRow struct {
+	models.Good
+	Rank                 float32 `db:"rank"`
+	NameHighlight        string  `db:"name_highlight"`
+	DescriptionHighlight string  `db:"description_highlight"`
+	Total                int     `db:"total"`
+}
+
+func (r *RepositoryPostgres) SearchGoods(ctx context.Context, projectID int, query string, limit, offset int) (models.SearchResponse, error) {
+	rows, err := r.read(ctx).Query(ctx, searchQuery, projectID, query, limit, offset)
+	if err != nil {
+		return models.SearchResponse{}, fmt.Errorf("error to search goods: %v", err)
+	}
+
+	found, err := pgx.CollectRows(rows, pgx.RowToStructByName[searchRow])
+	if err != nil {
+		return models.SearchResponse{}, fmt.Errorf("error to scan search hit: %v", err)
+	}
+
+	searchResponse := models.SearchResponse{
+		Meta: models.SearchMeta{
+			Query:  query,
+			Limit:  limit,
+			Offset: offset,
+		},
+		Hits: make([]models.SearchHit, 0, len(found)),
+	}
+	if len(found) == 0 && offset > 0 {
+		if err = r.read(ctx).QueryRow(ctx, searchTotalQuery, projectID, query).Scan(&searchResponse.Meta.Total); err != nil {
+			return models.SearchResponse{}, fmt.Errorf("error to count search hits: %v", err)
+		}
+	}
+	for _, f := range found {
+		searchResponse.Meta.Total = f.Total
+		searchResponse.Hits = append(searchResponse.Hits, models.SearchHit{
+			Good: f.Good,
+			Rank: f.Rank,
+			Highlight: models.SearchHighlight{
+				Name:        f.NameHighlight,
+				Description: f.DescriptionHighlight,
+			},
+		})
+	}
+	return searchResponse, nil
+}
+
+// SetSearchLanguage changes the text search configuration of the project; its
+// goods are reindexed by a trigger in the same transaction.
+func (r *RepositoryPostgres) SetSearchLanguage(ctx context.Context, projectID int, language string) error {
+	tag, err := r.conn(ctx).Exec(ctx, `UPDATE projects SET search_language = $2::regconfig WHERE id = $1`, projectID, language)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == codeUndefinedObject {
+			return fmt.Errorf("%w: unknown search language %q", custerrors.ErrInvalid, language)
+		}
+		return fmt.Errorf("error to set search language: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return custerrors.ErrNotFound
+	}
+	return nil
+}
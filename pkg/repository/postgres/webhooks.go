@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	webhookColumns  = "id, project_id, url, secret, events, created_at"
+	deliveryColumns = `id, webhook_id, project_id, event, payload, status, attempts, response_status, error,
+	next_attempt_at, created_at, delivered_at`
+)
+
+func (r *RepositoryPostgres) CreateWebhook(ctx context.Context, projectID int, url, secret string, events []string) (models.Webhook, error) {
+	if events == nil {
+		events = []string{}
+	}
+
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`INSERT INTO webhooks (project_id, url, secret, events)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+webhookColumns,
+		projectID, url, secret, events)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("error to create webhook: %v", err)
+	}
+
+	webhook, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Webhook])
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == codeForeignKeyViolation {
+			return models.Webhook{}, fmt.Errorf("project %w", custerrors.ErrNotFound)
+		}
+		return models.Webhook{}, fmt.Errorf("error to create webhook: %v", err)
+	}
+	return webhook, nil
+}
+
+func (r *RepositoryPostgres) Webhooks(ctx context.Context, projectID int) ([]models.Webhook, error) {
+	rows, err := r.read(ctx).Query(
+		ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE project_id = $1 ORDER BY id`,
+		projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error to get webhooks: %v", err)
+	}
+
+	webhooks, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Webhook])
+	if err != nil {
+		return nil, fmt.Errorf("error to scan webhook: %v", err)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook also drops the deliveries of the webhook.
+func (r *RepositoryPostgres) DeleteWebhook(ctx context.Context, webhookID, projectID int) error {
+	tag, err := r.conn(ctx).Exec(ctx, `DELETE FROM webhooks WHERE id = $1 AND project_id = $2`, webhookID, projectID)
+	if err != nil {
+		return fmt.Errorf("error to delete webhook: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return custerrors.ErrNotFound
+	}
+	return nil
+}
+
+// CreateDeliveries queues the event for every webhook of the project that
+// subscribed to it.
+func (r *RepositoryPostgres) CreateDeliveries(ctx context.Context, projectID int, event string, payload []byte) (int, error) {
+	tag, err := r.conn(ctx).Exec(
+		ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, project_id, event, payload)
+		SELECT id, project_id, $2::TEXT, $3::JSONB
+		FROM webhooks
+		WHERE project_id = $1 AND (cardinality(events) = 0 OR $2::TEXT = ANY(events))`,
+		projectID, event, string(payload))
+	if err != nil {
+		return 0, fmt.Errorf("error to create deliveries: %v", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ClaimDeliveries takes up to limit due deliveries. They are leased rather
+// than locked: their next attempt is pushed lease away, so the deliveries of
+// a worker that dies are picked up again once it expires.
+func (r *RepositoryPostgres) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.DueDelivery, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`WITH due AS (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT $1
+		)
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1,
+			next_attempt_at = now() + make_interval(secs => $2)
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.project_id, d.event, d.payload, d.status, d.attempts,
+			d.response_status, d.error, d.next_attempt_at, d.created_at, d.delivered_at, w.url, w.secret`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error to claim deliveries: %v", err)
+	}
+
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.DueDelivery])
+	if err != nil {
+		return nil, fmt.Errorf("error to scan delivery: %v", err)
+	}
+	return deliveries, nil
+}
+
+// FinishDelivery records an attempt. A pending delivery is retried at retryAt.
+func (r *RepositoryPostgres) FinishDelivery(ctx context.Context, deliveryID int64, status string, responseStatus int, errMsg string, retryAt time.Time) error {
+	if _, err := r.conn(ctx).Exec(
+		ctx,
+		`UPDATE webhook_deliveries
+		SET status = $2,
+			response_status = $3,
+			error = $4,
+			next_attempt_at = $5,
+			delivered_at = CASE WHEN $2 = 'succeeded' THEN now() END
+		WHERE id = $1`,
+		deliveryID, status, responseStatus, errMsg, retryAt,
+	); err != nil {
+		return fmt.Errorf("error to finish delivery: %v", err)
+	}
+	return nil
+}
+
+// Deliveries lists the deliveries of the project, newest first. An empty
+// status lists all of them.
+func (r *RepositoryPostgres) Deliveries(ctx context.Context, projectID int, status string, limit, offset int) ([]models.WebhookDelivery, error) {
+	rows, err := r.read(ctx).Query(
+		ctx,
+		`SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE project_id = $1 AND ($2::TEXT = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4`,
+		projectID, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error to get deliveries: %v", err)
+	}
+
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.WebhookDelivery])
+	if err != nil {
+		return nil, fmt.Errorf("error to scan delivery: %v", err)
+	}
+	return deliveries, nil
+}
+
+// RedeliverDelivery queues a failed delivery again with a fresh set of attempts.
+func (r *RepositoryPostgres) RedeliverDelivery(ctx context.Context, deliveryID int64, projectID int) (models.WebhookDelivery, error) {
+	rows, err := r.conn(ctx).Query(
+		ctx,
+		`UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = now()
+		WHERE id = $1 AND project_id = $2 AND status = 'failed'
+		RETURNING `+deliveryColumns,
+		deliveryID, projectID)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("error to redeliver delivery: %v", err)
+	}
+
+	delivery, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.WebhookDelivery])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.WebhookDelivery{}, custerrors.ErrNotFound
+		}
+		return models.WebhookDelivery{}, fmt.Errorf("error to scan delivery: %v", err)
+	}
+	return delivery, nil
+}
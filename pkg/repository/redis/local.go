@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	log "github.com/sirupsen/logrus"
+)
+
+type broadcaster interface {
+	PublishInvalidation(projectID int) error
+	SubscribeInvalidations(evict func(projectID int)) error
+}
+
+type pageKey struct {
+	projectID, limit, offset int
+}
+
+// LocalRepository keeps recently served pages in process memory in front of
+// Redis. Every write is broadcast so all replicas evict the project at once.
+type LocalRepository struct {
+	*RepositoryRedis
+
+	broadcaster broadcaster
+	pages       *expirable.LRU[pageKey, models.GoodsResponse]
+
+	// epochs guards against putting back a page read from Redis before an
+	// eviction of its project arrived.
+	mu     sync.Mutex
+	epochs map[int]uint64
+
+	localHits, localMisses atomic.Int64
+	redisHits, redisMisses atomic.Int64
+}
+
+func NewLocalRepository(repo *RepositoryRedis, broadcaster broadcaster, size int, ttl time.Duration) (*LocalRepository, error) {
+	r := &LocalRepository{
+		RepositoryRedis: repo,
+		broadcaster:     broadcaster,
+		pages:           expirable.NewLRU[pageKey, models.GoodsResponse](size, nil, ttl),
+		epochs:          map[int]uint64{},
+	}
+
+	if err := broadcaster.SubscribeInvalidations(r.evict); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *LocalRepository) Goods(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error) {
+	key := pageKey{projectID: projectID, limit: limit, offset: offset}
+	if goods, ok := r.pages.Get(key); ok {
+		r.localHits.Add(1)
+		return goods, nil
+	}
+	r.localMisses.Add(1)
+
+	epoch := r.epoch(projectID)
+	goods, err := r.RepositoryRedis.Goods(ctx, projectID, limit, offset)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			r.redisMisses.Add(1)
+		}
+		return models.GoodsResponse{}, err
+	}
+	r.redisHits.Add(1)
+
+	r.mu.Lock()
+	if r.epochs[projectID] == epoch {
+		r.pages.Add(key, goods)
+	}
+	r.mu.Unlock()
+	return goods, nil
+}
+
+func (r *LocalRepository) Apply(ctx context.Context, good models.Good, totalDelta, removedDelta int) error {
+	defer r.invalidate(good.ProjectID)
+	return r.RepositoryRedis.Apply(ctx, good, totalDelta, removedDelta)
+}
+
+func (r *LocalRepository) Delete(ctx context.Context, projectID int) error {
+	defer r.invalidate(projectID)
+	return r.RepositoryRedis.Delete(ctx, projectID)
+}
+
+func (r *LocalRepository) Reset(ctx context.Context) error {
+	defer r.invalidate(0)
+	return r.RepositoryRedis.Reset(ctx)
+}
+
+func (r *LocalRepository) Stats() models.CacheStats {
+	return models.CacheStats{
+		Local: tierStats(r.localHits.Load(), r.localMisses.Load()),
+		Redis: tierStats(r.redisHits.Load(), r.redisMisses.Load()),
+	}
+}
+
+// ReportStats logs the hit ratios of both tiers until ctx is done.
+func (r *LocalRepository) ReportStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := r.Stats()
+			log.Infof(
+				"goods cache: local %d/%d hits (%.2f), redis %d/%d hits (%.2f)",
+				stats.Local.Hits, stats.Local.Hits+stats.Local.Misses, stats.Local.Ratio,
+				stats.Redis.Hits, stats.Redis.Hits+stats.Redis.Misses, stats.Redis.Ratio,
+			)
+		}
+	}
+}
+
+func (r *LocalRepository) invalidate(projectID int) {
+	r.evict(projectID)
+	if err := r.broadcaster.PublishInvalidation(projectID); err != nil {
+		log.Errorf("error to publish invalidation: %v", err)
+	}
+}
+
+func (r *LocalRepository) evict(projectID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.epochs[projectID]++
+	r.epochs[0]++
+	for _, key := range r.pages.Keys() {
+		if projectID == 0 || key.projectID == projectID || key.projectID == 0 {
+			r.pages.Remove(key)
+		}
+	}
+}
+
+func (r *LocalRepository) epoch(projectID int) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.epochs[projectID]
+}
+
+func tierStats(hits, misses int64) models.CacheTierStats {
+	stats := models.CacheTierStats{Hits: hits, Misses: misses}
+	if total := hits + misses; total > 0 {
+		stats.Ratio = float64(hits) / float64(total)
+	}
+	return stats
+}
@@ -2,21 +2,87 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"time"
 
+	"github.com/Hymiside/hezzl-api/pkg/breaker"
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	"github.com/redis/go-redis/v9"
 )
 
 func NewRedisDB(ctx context.Context, cfg models.ConfigRedis) (*redis.Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
-		Password: "",
-		DB:       0,
-	})
+	rdb := OpenRedisDB(cfg)
 	if _, err := rdb.Ping(ctx).Result(); err != nil {
 		return nil, fmt.Errorf("connection test error: %w", err)
 	}
 	return rdb, nil
 
-}
\ No newline at end of file
+}
+
+// OpenRedisDB creates the client without waiting for the server to answer.
+func OpenRedisDB(cfg models.ConfigRedis) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
+		Password:     "",
+		DB:           0,
+		DialTimeout:  2 * time.Second,
+		ReadTimeout:  500 * time.Millisecond,
+		WriteTimeout: 500 * time.Millisecond,
+		MaxRetries:   2,
+	})
+}
+
+// breakerHook runs every command through the breaker. Retries are left to the
+// client itself, which knows which errors are safe to retry. Replies from the
+// server, redis.Nil included, mean it is up and are not counted as failures.
+type breakerHook struct {
+	breaker *breaker.Breaker
+}
+
+func NewBreakerHook(b *breaker.Breaker) redis.Hook {
+	return breakerHook{breaker: b}
+}
+
+func (h breakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h breakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		var reply error
+		err := h.breaker.Do(ctx, func(ctx context.Context) error {
+			reply = next(ctx, cmd)
+			return unavailable(reply)
+		})
+		if err != nil {
+			return err
+		}
+		return reply
+	}
+}
+
+func (h breakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var reply error
+		err := h.breaker.Do(ctx, func(ctx context.Context) error {
+			reply = next(ctx, cmds)
+			return unavailable(reply)
+		})
+		if err != nil {
+			return err
+		}
+		return reply
+	}
+}
+
+func unavailable(err error) error {
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return nil
+	}
+	return err
+}
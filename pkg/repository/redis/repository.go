@@ -2,9 +2,12 @@ package redis
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/Hymiside/hezzl-api/pkg/custerrors"
@@ -12,56 +15,292 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Goods are cached per project (project 0 holds the whole catalog):
+//
+//	goods:index:<project>        ZSET of good ids scored by priority
+//	goods:counts:<project>       HASH with total and removed counters
+//	goods:ready:<project>        set once the index holds the full project
+//	goods:epoch:<project>        bumped by every write to the project
+//	goods:payloads:<project>     HASH of versioned good payloads by id
+//
+// The payloads expire and are dropped along with the index of their project.
+const lockTTL = 5 * time.Second
+
+// unlockScript releases the lock only if it is still held by the same owner.
+var unlockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// applyScript patches one good into the cache. The payload, its score and the
+// counters are written only if the change is newer than the cached good: a
+// slow writer can't put back a replaced version, and a change that a build
+// already loaded is not counted twice. Indexes that are not ready are left
+// alone, they will be loaded in full, and the written keys expire with the
+// ready key of their index.
+//
+//	KEYS: payloads, index, counts, ready, epoch for the project and for 0
+//	ARGV: id, priority, payload, version, total delta, removed delta
+var applyScript = redis.NewScript(`
+	for i = 1, #KEYS, 5 do
+		redis.call("INCR", KEYS[i + 4])
+		local ttl = redis.call("PTTL", KEYS[i + 3])
+		if ttl ~= -2 then
+			local newer = true
+			local current = redis.call("HGET", KEYS[i], ARGV[1])
+			if current then
+				local ok, decoded = pcall(cjson.decode, current)
+				if ok and tonumber(decoded.version) >= tonumber(ARGV[4]) then
+					newer = false
+				end
+			end
+			if newer then
+				redis.call("HSET", KEYS[i], ARGV[1], ARGV[3])
+				redis.call("ZADD", KEYS[i + 1], ARGV[2], ARGV[1])
+				redis.call("HINCRBY", KEYS[i + 2], "total", ARGV[5])
+				redis.call("HINCRBY", KEYS[i + 2], "removed", ARGV[6])
+				if ttl > 0 then
+					for k = i, i + 2 do
+						redis.call("PEXPIRE", KEYS[k], ttl)
+					end
+				end
+			end
+		end
+	end
+	return 1
+`)
+
+type cachedGood struct {
+	Version int64       `json:"version"`
+	Good    models.Good `json:"good"`
+}
+
 type RepositoryRedis struct {
-	db *redis.Client
+	db  *redis.Client
+	ttl time.Duration
 }
 
-func NewRepositoryRedis(db *redis.Client) *RepositoryRedis {
-	return &RepositoryRedis{db: db}
+func NewRepositoryRedis(db *redis.Client, ttl time.Duration) *RepositoryRedis {
+	return &RepositoryRedis{db: db, ttl: ttl}
 }
 
-func (r *RepositoryRedis) Set(ctx context.Context, b []byte) error {
-	if err := r.db.Set(ctx, "goods", b, 1*time.Minute).Err(); err != nil {
-		return fmt.Errorf("error to set goods: %v", err)
+func indexKey(projectID int) string  { return fmt.Sprintf("goods:index:%d", projectID) }
+func countsKey(projectID int) string { return fmt.Sprintf("goods:counts:%d", projectID) }
+func readyKey(projectID int) string  { return fmt.Sprintf("goods:ready:%d", projectID) }
+func epochKey(projectID int) string  { return fmt.Sprintf("goods:epoch:%d", projectID) }
+
+func payloadsKey(projectID int) string { return fmt.Sprintf("goods:payloads:%d", projectID) }
+
+// Epoch must be read before loading a project from Postgres and passed to Build.
+func (r *RepositoryRedis) Epoch(ctx context.Context, projectID int) (int64, error) {
+	epoch, err := r.db.Get(ctx, epochKey(projectID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("error to get epoch: %v", err)
 	}
-	return nil
+	return epoch, nil
 }
 
-func (r *RepositoryRedis) Get(ctx context.Context, limit, offset int) (models.GoodsResponse, error) {
-	b, goods := []byte{}, []models.Good{}
-	if err := r.db.Get(ctx, "goods").Scan(&b); err != nil {
-		if errors.Is(err, redis.Nil) {
-			return models.GoodsResponse{}, custerrors.ErrNotFound
-		}
+func (r *RepositoryRedis) Ready(ctx context.Context, projectID int) (bool, error) {
+	n, err := r.db.Exists(ctx, readyKey(projectID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("error to get ready: %v", err)
+	}
+	return n == 1, nil
+}
+
+func (r *RepositoryRedis) Goods(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error) {
+	// ZRANGE treats negative bounds as counted from the end, which is not what
+	// a page outside of the catalog means.
+	start, stop := int64(offset), int64(offset+limit-1)
+	if limit <= 0 || offset < 0 {
+		start, stop = 1, 0
+	}
+
+	pipe := r.db.Pipeline()
+	ready := pipe.Exists(ctx, readyKey(projectID))
+	ids := pipe.ZRange(ctx, indexKey(projectID), start, stop)
+	counts := pipe.HMGet(ctx, countsKey(projectID), "total", "removed")
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
 		return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
 	}
 
-	if err := json.Unmarshal(b, &goods); err != nil {
-		return models.GoodsResponse{}, fmt.Errorf("error to unmarshal goods: %v", err)
+	if ready.Val() == 0 {
+		return models.GoodsResponse{}, custerrors.ErrNotFound
 	}
 
-	var countRemoved = 0
-	for i := 0; i < len(goods); i++ {
-		if goods[i].Removed {
-			countRemoved++
+	var total, removed int
+	for i, v := range counts.Val() {
+		s, _ := v.(string)
+		n, _ := strconv.Atoi(s)
+		if i == 0 {
+			total = n
+		} else {
+			removed = n
+		}
+	}
+
+	goods := make([]models.Good, 0, len(ids.Val()))
+	if len(ids.Val()) > 0 {
+		payloads, err := r.db.HMGet(ctx, payloadsKey(projectID), ids.Val()...).Result()
+		if err != nil {
+			return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
+		}
+
+		for _, v := range payloads {
+			s, ok := v.(string)
+			if !ok {
+				// The payloads expired before the index: the page can't be served.
+				return models.GoodsResponse{}, custerrors.ErrNotFound
+			}
+
+			var cached cachedGood
+			if err = json.Unmarshal([]byte(s), &cached); err != nil {
+				return models.GoodsResponse{}, fmt.Errorf("error to unmarshal good: %v", err)
+			}
+			goods = append(goods, cached.Good)
 		}
 	}
 
 	goodsResponse := models.GoodsResponse{
-		Goods: goods[offset:offset+limit],
+		Goods: goods,
 		Meta: models.Meta{
 			Limit:   limit,
-			Ofset:   offset,
-			Total:   len(goods),
-			Removed: countRemoved,
+			Offset:  offset,
+			Total:   total,
+			Removed: removed,
 		},
 	}
 	return goodsResponse, nil
 }
 
-func (r *RepositoryRedis) Delete(ctx context.Context) error {
-	if err := r.db.Del(ctx, "goods").Err(); err != nil {
+// Build replaces the index of the project with the given goods. It fails with
+// custerrors.ErrStale if any write hit the project since epoch was read.
+func (r *RepositoryRedis) Build(ctx context.Context, projectID int, epoch int64, goods []models.Good) error {
+	var (
+		members  = make([]redis.Z, 0, len(goods))
+		payloads = make(map[string]interface{}, len(goods))
+		removed  int
+	)
+	for _, good := range goods {
+		b, err := json.Marshal(cachedGood{Version: good.Version, Good: good})
+		if err != nil {
+			return fmt.Errorf("error to marshal good: %v", err)
+		}
+		id := strconv.Itoa(good.ID)
+		members = append(members, redis.Z{Score: float64(good.Priority), Member: id})
+		payloads[id] = b
+		if good.Removed {
+			removed++
+		}
+	}
+
+	err := r.db.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, epochKey(projectID)).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != epoch {
+			return custerrors.ErrStale
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, indexKey(projectID), countsKey(projectID), payloadsKey(projectID))
+			if len(members) > 0 {
+				pipe.ZAdd(ctx, indexKey(projectID), members...)
+				pipe.HSet(ctx, payloadsKey(projectID), payloads)
+			}
+			pipe.HSet(ctx, countsKey(projectID), "total", len(goods), "removed", removed)
+			pipe.Set(ctx, readyKey(projectID), 1, r.ttl)
+			pipe.Expire(ctx, indexKey(projectID), r.ttl)
+			pipe.Expire(ctx, countsKey(projectID), r.ttl)
+			pipe.Expire(ctx, payloadsKey(projectID), r.ttl)
+			return nil
+		})
+		return err
+	}, epochKey(projectID))
+	if err != nil {
+		if errors.Is(err, custerrors.ErrStale) || errors.Is(err, redis.TxFailedErr) {
+			return custerrors.ErrStale
+		}
+		return fmt.Errorf("error to build goods index: %v", err)
+	}
+	return nil
+}
+
+// Apply patches a committed change of the good into the cached indexes of its
+// project and of the whole catalog.
+func (r *RepositoryRedis) Apply(ctx context.Context, good models.Good, totalDelta, removedDelta int) error {
+	b, err := json.Marshal(cachedGood{Version: good.Version, Good: good})
+	if err != nil {
+		return fmt.Errorf("error to marshal good: %v", err)
+	}
+
+	var keys []string
+	for _, projectID := range []int{good.ProjectID, 0} {
+		keys = append(keys, payloadsKey(projectID), indexKey(projectID), countsKey(projectID), readyKey(projectID), epochKey(projectID))
+	}
+
+	if err = applyScript.Run(
+		ctx, r.db, keys, good.ID, good.Priority, b, good.Version, totalDelta, removedDelta,
+	).Err(); err != nil {
+		return fmt.Errorf("error to apply good: %v", err)
+	}
+	return nil
+}
+
+// Lock tries to become the only loader of the project. The returned token must
+// be passed to Unlock; an empty token means another loader holds the lock.
+func (r *RepositoryRedis) Lock(ctx context.Context, projectID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error to generate lock token: %v", err)
+	}
+	token := hex.EncodeToString(b)
+
+	ok, err := r.db.SetNX(ctx, indexKey(projectID)+":lock", token, lockTTL).Result()
+	if err != nil {
+		return "", fmt.Errorf("error to lock goods: %v", err)
+	}
+	if !ok {
+		return "", nil
+	}
+	return token, nil
+}
+
+func (r *RepositoryRedis) Unlock(ctx context.Context, projectID int, token string) error {
+	if err := unlockScript.Run(ctx, r.db, []string{indexKey(projectID) + ":lock"}, token).Err(); err != nil {
+		return fmt.Errorf("error to unlock goods: %v", err)
+	}
+	return nil
+}
+
+// Delete drops the indexes of the project and of the whole catalog. Bumping
+// the epochs also fails builds that started before the delete.
+func (r *RepositoryRedis) Delete(ctx context.Context, projectID int) error {
+	pipe := r.db.TxPipeline()
+	for _, id := range []int{projectID, 0} {
+		pipe.Del(ctx, readyKey(id), indexKey(id), countsKey(id), payloadsKey(id))
+		pipe.Incr(ctx, epochKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("error to delete goods: %v", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// Reset drops every index, so nothing written while Redis was unreachable
+// is served once it is back.
+func (r *RepositoryRedis) Reset(ctx context.Context) error {
+	iter := r.db.Scan(ctx, 0, "goods:ready:*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := r.db.Del(ctx, iter.Val()).Err(); err != nil {
+			return fmt.Errorf("error to reset goods: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("error to reset goods: %v", err)
+	}
+	return nil
+}
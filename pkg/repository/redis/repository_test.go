@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRepository(t *testing.T) *RepositoryRedis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	db := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { db.Close() })
+	return NewRepositoryRedis(db, time.Minute)
+}
+
+func build(t *testing.T, r *RepositoryRedis, projectID int, goods ...models.Good) {
+	t.Helper()
+	ctx := context.Background()
+	epoch, err := r.Epoch(ctx, projectID)
+	if err != nil {
+		t.Fatalf("Epoch: %v", err)
+	}
+	if err = r.Build(ctx, projectID, epoch, goods); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+}
+
+func TestBuildFailsAfterAConcurrentWrite(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+
+	epoch, err := r.Epoch(ctx, 1)
+	if err != nil {
+		t.Fatalf("Epoch: %v", err)
+	}
+
+	// A write commits while the build is loading the project from Postgres.
+	if err = r.Apply(ctx, models.Good{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Version: 2}, 1, 0); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	err = r.Build(ctx, 1, epoch, []models.Good{{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Version: 1}})
+	if !errors.Is(err, custerrors.ErrStale) {
+		t.Fatalf("Build = %v, want ErrStale", err)
+	}
+	if ready, _ := r.Ready(ctx, 1); ready {
+		t.Fatal("a stale build made the index ready")
+	}
+}
+
+func TestApplyKeepsTheNewerVersion(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+
+	build(t, r, 1, models.Good{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Version: 1})
+
+	if err := r.Apply(ctx, models.Good{ID: 1, ProjectID: 1, Name: "b", Priority: 1, Version: 3}, 0, 0); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	// A slow writer comes back with the version in between.
+	if err := r.Apply(ctx, models.Good{ID: 1, ProjectID: 1, Name: "stale", Priority: 1, Version: 2}, 0, 0); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	goods, err := r.Goods(ctx, 1, 10, 0)
+	if err != nil {
+		t.Fatalf("Goods: %v", err)
+	}
+	if len(goods.Goods) != 1 || goods.Goods[0].Name != "b" {
+		t.Fatalf("goods = %+v, want only b", goods.Goods)
+	}
+}
+
+func TestApplyDoesNotCountABuiltChangeTwice(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+
+	// The build already loaded the removal of good 2.
+	build(t, r, 1,
+		models.Good{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Version: 1},
+		models.Good{ID: 2, ProjectID: 1, Name: "b", Priority: 2, Removed: true, Version: 2},
+	)
+
+	// The removal is applied after the build.
+	if err := r.Apply(ctx, models.Good{ID: 2, ProjectID: 1, Name: "b", Priority: 2, Removed: true, Version: 2}, 0, 1); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	// A new good is counted.
+	if err := r.Apply(ctx, models.Good{ID: 3, ProjectID: 1, Name: "c", Priority: 3, Version: 1}, 1, 0); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	goods, err := r.Goods(ctx, 1, 10, 0)
+	if err != nil {
+		t.Fatalf("Goods: %v", err)
+	}
+	if goods.Meta.Total != 3 || goods.Meta.Removed != 1 {
+		t.Errorf("total = %d, removed = %d, want 3 and 1", goods.Meta.Total, goods.Meta.Removed)
+	}
+
+	var ids []int
+	for _, good := range goods.Goods {
+		ids = append(ids, good.ID)
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("ids = %v, want [1 2 3] by priority", ids)
+	}
+}
+
+func TestApplyLeavesIndexesThatAreNotReady(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := r.Apply(ctx, models.Good{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Version: 1}, 1, 0); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if _, err := r.Goods(ctx, 1, 10, 0); !errors.Is(err, custerrors.ErrNotFound) {
+		t.Fatalf("Goods = %v, want ErrNotFound until the project is built", err)
+	}
+}
+
+func TestPayloadsLiveWithTheirIndex(t *testing.T) {
+	r := newTestRepository(t)
+	ctx := context.Background()
+
+	build(t, r, 1, models.Good{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Version: 1})
+	build(t, r, 2, models.Good{ID: 2, ProjectID: 2, Name: "b", Priority: 1, Version: 1})
+	if err := r.Apply(ctx, models.Good{ID: 3, ProjectID: 1, Name: "c", Priority: 2, Version: 1}, 1, 0); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	indexTTL := r.db.PTTL(ctx, indexKey(1)).Val()
+	if ttl := r.db.PTTL(ctx, payloadsKey(1)).Val(); ttl <= 0 || ttl > indexTTL {
+		t.Errorf("payloads expire in %s, want with the index in %s", ttl, indexTTL)
+	}
+
+	if err := r.Delete(ctx, 1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if n := r.db.Exists(ctx, payloadsKey(1)).Val(); n != 0 {
+		t.Error("the payloads of the deleted project are still cached")
+	}
+	if n := r.db.HLen(ctx, payloadsKey(2)).Val(); n != 1 {
+		t.Errorf("project 2 has %d payloads cached, want its own kept", n)
+	}
+}
@@ -2,27 +2,42 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	"github.com/go-chi/chi/v5"
 	log "github.com/sirupsen/logrus"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func StartServer(ctx context.Context, handler *chi.Mux, cfg models.ConfigServer) error {
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
 		Handler: handler,
 	}
 
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		<-ctx.Done()
-		if err := httpServer.Shutdown(ctx); err != nil {
-			log.Fatalf("failed to shutdown server: %v", err)
+
+		// ctx is already done, the shutdown gets its own deadline.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("failed to shutdown server: %v", err)
 		}
 	}()
 
 	log.Infof("server started on http://%s:%s/", cfg.Host, cfg.Port)
-	return httpServer.ListenAndServe()
-}
\ No newline at end of file
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-stopped
+	return nil
+}
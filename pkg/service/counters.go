@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileCounters fixes the goods counters that no longer match the goods,
+// e.g. after rows were changed around the service.
+func (s *Service) ReconcileCounters(ctx context.Context) ([]models.CounterDrift, error) {
+	drifts, err := s.repoPostgres.ReconcileCounters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error to reconcile counters: %v", err)
+	}
+	return drifts, nil
+}
+
+// RunReconciler reconciles the counters every interval until ctx is done.
+func (s *Service) RunReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			drifts, err := s.ReconcileCounters(ctx)
+			if err != nil {
+				log.Errorf("error to reconcile counters: %v", err)
+				continue
+			}
+			for _, d := range drifts {
+				log.Warnf(
+					"goods counters of project %d drifted: total %d -> %d, removed %d -> %d",
+					d.ProjectID, d.Total, d.ExpectedTotal, d.Removed, d.ExpectedRemoved,
+				)
+			}
+		}
+	}
+}
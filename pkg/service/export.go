@@ -0,0 +1,15 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+func (s *Service) ExportGoods(ctx context.Context, projectID int, removed *bool, fn func(models.Good) error) error {
+	if err := s.repoPostgres.ExportGoods(ctx, projectID, removed, fn); err != nil {
+		return fmt.Errorf("error to export goods: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// errDryRun rolls back the import transaction of a dry run.
+var errDryRun = errors.New("dry run")
+
+// ImportGoods upserts the rows in one transaction and reports what happened to
+// each of them. A dry run does the same writes and rolls them back, so the
+// report is exactly what a real import would give.
+func (s *Service) ImportGoods(ctx context.Context, projectID int, rows []models.ImportRow, dryRun bool) ([]models.ImportRowResult, error) {
+	var upserted []models.UpsertedGood
+	err := s.trManager.Do(ctx, func(ctx context.Context) (err error) {
+		if upserted, err = s.repoPostgres.UpsertGoods(ctx, projectID, rows); err != nil {
+			return err
+		}
+
+		var (
+			created      int
+			createdGoods []models.Good
+			updatedGoods []models.Good
+		)
+		for _, u := range upserted {
+			if u.Created {
+				created++
+				createdGoods = append(createdGoods, u.Good)
+			} else {
+				updatedGoods = append(updatedGoods, u.Good)
+			}
+		}
+		if err = s.repoPostgres.AddCounters(ctx, projectID, created, 0); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		if err = s.logChanges(ctx, models.EventCreated, createdGoods...); err != nil {
+			return err
+		}
+		return s.logChanges(ctx, models.EventUpdated, updatedGoods...)
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error to import goods: %v", err)
+	}
+
+	results := make([]models.ImportRowResult, 0, len(upserted))
+	for i, u := range upserted {
+		result := models.ImportRowResult{
+			Row:        rows[i].Row,
+			ExternalID: rows[i].Good.ExternalID,
+			Status:     models.ImportUpdated,
+			ID:         u.Good.ID,
+		}
+		if u.Created {
+			result.Status = models.ImportCreated
+		}
+		results = append(results, result)
+	}
+
+	// An import touches too many goods to patch them one by one.
+	if !dryRun && len(upserted) > 0 {
+		if err = s.repoRedis.Delete(ctx, projectID); err != nil {
+			log.Errorf("error to delete redis: %v", err)
+		}
+	}
+	return results, nil
+}
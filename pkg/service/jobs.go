@@ -0,0 +1,420 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/goodsfile"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxJobAttempts bounds how many times a job is claimed again after its
+	// worker died, so a job that crashes the process doesn't loop forever.
+	maxJobAttempts = 3
+
+	// reprioritizeChunk is how many goods a reprioritize job updates per
+	// transaction.
+	reprioritizeChunk = 100
+
+	// jobResultChunk is how many bytes of a job result are buffered before
+	// they are stored.
+	jobResultChunk = 256 << 10
+)
+
+var validate = validator.New()
+
+// jobProgress is written by the job and read by its heartbeat.
+type jobProgress struct {
+	done, total atomic.Int64
+}
+
+func (p *jobProgress) set(done, total int) {
+	p.done.Store(int64(done))
+	p.total.Store(int64(total))
+}
+
+func (p *jobProgress) get() (int, int) {
+	return int(p.done.Load()), int(p.total.Load())
+}
+
+// jobResult stores what a job writes as the result chunks of its attempt, so
+// the result is never held whole.
+type jobResult struct {
+	ctx  context.Context
+	repo repositoryPostgres
+	job  models.Job
+	seq  int
+	buf  []byte
+}
+
+func (r *jobResult) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	if len(r.buf) >= jobResultChunk {
+		if err := r.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush stores what is buffered.
+func (r *jobResult) Flush() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	if err := r.repo.AppendJobResult(r.ctx, r.job.ID, r.job.Attempts, r.seq, r.buf); err != nil {
+		return err
+	}
+	r.seq++
+	r.buf = r.buf[:0]
+	return nil
+}
+
+func (s *Service) SubmitJob(ctx context.Context, kind string, projectID int, params models.JobParams, input []byte) (models.Job, error) {
+	job, err := s.repoPostgres.CreateJob(ctx, kind, projectID, params, input)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("error to submit job: %v", err)
+	}
+
+	s.publishJobLog(models.JobQueued, job)
+	return job, nil
+}
+
+func (s *Service) Job(ctx context.Context, jobID int64, projectID int) (models.Job, error) {
+	job, err := s.repoPostgres.Job(ctx, jobID, projectID)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.Job{}, err
+		}
+		return models.Job{}, fmt.Errorf("error to get job: %v", err)
+	}
+	return job, nil
+}
+
+// CancelJob cancels a queued job or asks the worker of a running one to stop.
+// A reprioritize job keeps the chunks it already committed.
+func (s *Service) CancelJob(ctx context.Context, jobID int64, projectID int) (models.Job, error) {
+	job, canceled, err := s.repoPostgres.CancelJob(ctx, jobID, projectID)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.Job{}, err
+		}
+		return models.Job{}, fmt.Errorf("error to cancel job: %v", err)
+	}
+
+	// A running job is logged as canceled by its worker once it stops.
+	if canceled {
+		s.publishJobLog(models.JobCanceled, job)
+	}
+	return job, nil
+}
+
+func (s *Service) JobResult(ctx context.Context, jobID int64, projectID int) (models.JobResult, error) {
+	result, err := s.repoPostgres.JobResult(ctx, jobID, projectID)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.JobResult{}, err
+		}
+		return models.JobResult{}, fmt.Errorf("error to get job result: %v", err)
+	}
+	return result, nil
+}
+
+// WriteJobResult streams the result of a job that succeeded to w.
+func (s *Service) WriteJobResult(ctx context.Context, jobID int64, projectID int, w io.Writer) error {
+	if err := s.repoPostgres.WriteJobResult(ctx, jobID, projectID, w); err != nil {
+		return fmt.Errorf("error to write job result: %v", err)
+	}
+	return nil
+}
+
+// RunJobs starts the workers and blocks until ctx is done. Jobs still running
+// then are put back in the queue for the next instance.
+func (s *Service) RunJobs(ctx context.Context, cfg models.ConfigJobs) {
+	done := make(chan struct{})
+	for i := 0; i < cfg.Workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			s.work(ctx, cfg)
+		}()
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		<-done
+	}
+}
+
+func (s *Service) work(ctx context.Context, cfg models.ConfigJobs) {
+	for {
+		job, input, ok, err := s.repoPostgres.ClaimJob(ctx, cfg.StaleAfter)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("error to claim job: %v", err)
+		}
+		if ok {
+			s.execute(ctx, cfg, job, input)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+func (s *Service) execute(ctx context.Context, cfg models.ConfigJobs, job models.Job, input []byte) {
+	if job.Attempts > maxJobAttempts {
+		s.finishJob(ctx, job, models.JobFailed, &jobProgress{}, "",
+			fmt.Sprintf("gave up after %d attempts", maxJobAttempts))
+		return
+	}
+	s.publishJobLog(models.JobRunning, job)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		progress  = &jobProgress{}
+		result    = &jobResult{ctx: jobCtx, repo: s.repoPostgres, job: job}
+		canceled  atomic.Bool
+		lost      atomic.Bool
+		heartbeat = make(chan struct{})
+	)
+	go func() {
+		ticker := time.NewTicker(cfg.StaleAfter / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-heartbeat:
+				return
+			case <-ticker.C:
+				done, total := progress.get()
+				cancelRequested, err := s.repoPostgres.HeartbeatJob(ctx, job.ID, job.Attempts, done, total)
+				if errors.Is(err, custerrors.ErrLeaseLost) {
+					lost.Store(true)
+					cancel()
+					return
+				}
+				if err != nil {
+					log.Errorf("error to heartbeat job %d: %v", job.ID, err)
+					continue
+				}
+				if cancelRequested {
+					canceled.Store(true)
+					cancel()
+				}
+			}
+		}
+	}()
+
+	resultType, err := s.runJob(jobCtx, job, input, progress, result)
+	if err == nil {
+		err = result.Flush()
+	}
+	close(heartbeat)
+
+	switch {
+	case lost.Load() || errors.Is(err, custerrors.ErrLeaseLost):
+		log.Warnf("job %d was claimed again, leaving it to its new worker", job.ID)
+	case err == nil:
+		s.finishJob(ctx, job, models.JobSucceeded, progress, resultType, "")
+	case canceled.Load():
+		s.finishJob(ctx, job, models.JobCanceled, progress, "", "")
+	case ctx.Err() != nil:
+		if err = s.repoPostgres.RequeueJob(context.WithoutCancel(ctx), job.ID, job.Attempts); err != nil {
+			log.Errorf("error to requeue job %d: %v", job.ID, err)
+		}
+	default:
+		log.Errorf("error to run job %d: %v", job.ID, err)
+		s.finishJob(ctx, job, models.JobFailed, progress, "", err.Error())
+	}
+}
+
+// finishJob stores the outcome of the attempt the worker claimed. Once the job
+// was claimed again, the outcome of the new attempt is the one that counts.
+func (s *Service) finishJob(ctx context.Context, job models.Job, status string, progress *jobProgress, resultType, errMsg string) {
+	done, total := progress.get()
+	finished, err := s.repoPostgres.FinishJob(context.WithoutCancel(ctx), job.ID, job.Attempts, status, done, total, resultType, errMsg)
+	if errors.Is(err, custerrors.ErrLeaseLost) {
+		log.Warnf("job %d was claimed again, leaving it to its new worker", job.ID)
+		return
+	}
+	if err != nil {
+		log.Errorf("error to finish job %d: %v", job.ID, err)
+		return
+	}
+	s.publishJobLog(status, finished)
+}
+
+// runJob writes the result of the job to w and returns its content type.
+func (s *Service) runJob(ctx context.Context, job models.Job, input []byte, progress *jobProgress, w io.Writer) (string, error) {
+	switch job.Kind {
+	case models.JobExport:
+		return s.runExport(ctx, job, progress, w)
+	case models.JobImport:
+		return s.runImport(ctx, job, input, progress, w)
+	case models.JobPurge:
+		return s.runPurge(ctx, job, progress, w)
+	case models.JobReprioritize:
+		return s.runReprioritize(ctx, job, progress, w)
+	}
+	return "", fmt.Errorf("unknown job kind %q", job.Kind)
+}
+
+// runExport writes the file as the goods are read, it is stored in chunks.
+func (s *Service) runExport(ctx context.Context, job models.Job, progress *jobProgress, w io.Writer) (string, error) {
+	format, err := goodsfile.ParseFormat(job.Params.Format)
+	if err != nil {
+		return "", err
+	}
+	columns, err := goodsfile.ParseColumns(job.Params.Columns)
+	if err != nil {
+		return "", err
+	}
+
+	writer := goodsfile.NewWriter(w, format, columns)
+	if err = s.ExportGoods(ctx, job.ProjectID, job.Params.Removed, func(good models.Good) error {
+		if err := writer.Write(good); err != nil {
+			return err
+		}
+		progress.set(writer.Written(), 0)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", fmt.Errorf("error to write export: %v", err)
+	}
+
+	progress.set(writer.Written(), writer.Written())
+	return goodsfile.ContentType(format), nil
+}
+
+func (s *Service) runImport(ctx context.Context, job models.Job, input []byte, progress *jobProgress, w io.Writer) (string, error) {
+	format, err := goodsfile.ParseFormat(job.Params.Format)
+	if err != nil {
+		return "", err
+	}
+	lines, err := goodsfile.Read(bytes.NewReader(input), format)
+	if err != nil {
+		return "", err
+	}
+	progress.set(0, len(lines))
+
+	valid, failed := goodsfile.Prepare(lines, validate.Struct)
+	var results []models.ImportRowResult
+	if len(valid) > 0 {
+		if results, err = s.ImportGoods(ctx, job.ProjectID, valid, job.Params.DryRun); err != nil {
+			return "", err
+		}
+	}
+	progress.set(len(lines), len(lines))
+
+	return jsonResult(w, goodsfile.Report(job.Params.DryRun, failed, results))
+}
+
+// runPurge logs the removal of every purged good with the purge, so the
+// consumers of the log drop them as they do with change data capture.
+func (s *Service) runPurge(ctx context.Context, job models.Job, progress *jobProgress, w io.Writer) (string, error) {
+	var purged []models.Good
+	if err := s.trManager.Do(ctx, func(ctx context.Context) (err error) {
+		if purged, err = s.repoPostgres.PurgeGoods(ctx, job.ProjectID); err != nil {
+			return err
+		}
+		if err = s.repoPostgres.AddCounters(ctx, job.ProjectID, -len(purged), -len(purged)); err != nil {
+			return err
+		}
+		return s.logChanges(ctx, models.EventRemoved, purged...)
+	}); err != nil {
+		return "", fmt.Errorf("error to purge goods: %v", err)
+	}
+	progress.set(len(purged), len(purged))
+
+	if len(purged) > 0 {
+		if err := s.repoRedis.Delete(ctx, job.ProjectID); err != nil {
+			log.Errorf("error to delete redis: %v", err)
+		}
+	}
+	return jsonResult(w, models.PurgeReport{Purged: len(purged)})
+}
+
+// runReprioritize sets the priorities in chunks, each in its own transaction,
+// so a large job doesn't hold its locks until the end. Goods that are missing
+// or removed are reported and skipped.
+func (s *Service) runReprioritize(ctx context.Context, job models.Job, progress *jobProgress, w io.Writer) (string, error) {
+	var (
+		priorities = job.Params.Priorities
+		report     = models.ReprioritizeReport{Missing: []int{}}
+	)
+	progress.set(0, len(priorities))
+
+	for start := 0; start < len(priorities); start += reprioritizeChunk {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		end := min(start+reprioritizeChunk, len(priorities))
+
+		var updated []models.Good
+		var missing []int
+		if err := s.trManager.Do(ctx, func(ctx context.Context) error {
+			updated, missing = updated[:0], missing[:0]
+			for _, p := range priorities[start:end] {
+				good, err := s.repoPostgres.UpdateGood(ctx, models.Good{Priority: p.Priority}, p.ID, job.ProjectID)
+				if errors.Is(err, custerrors.ErrNotFound) {
+					missing = append(missing, p.ID)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				updated = append(updated, good)
+			}
+			return s.logChanges(ctx, models.EventReprioritized, reprioritized(updated)...)
+		}); err != nil {
+			return "", fmt.Errorf("error to reprioritize goods: %v", err)
+		}
+
+		s.writeThrough(ctx, 0, 0, updated...)
+
+		report.Updated += len(updated)
+		report.Missing = append(report.Missing, missing...)
+		progress.set(end, len(priorities))
+	}
+	return jsonResult(w, report)
+}
+
+func jsonResult(w io.Writer, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error to marshal job result: %v", err)
+	}
+	if _, err = w.Write(b); err != nil {
+		return "", err
+	}
+	return "application/json", nil
+}
+
+// publishJobLog is sent whatever publishLogs says: change data capture only
+// covers goods and projects.
+func (s *Service) publishJobLog(event string, job models.Job) {
+	bytes, err := json.Marshal(models.JobLog{Event: event, Job: job})
+	if err != nil {
+		log.Errorf("error to marshal job log: %v", err)
+		return
+	}
+
+	if err = s.queueNats.PublishJob(bytes); err != nil {
+		log.Errorf("error to publish job log: %v", err)
+	}
+}
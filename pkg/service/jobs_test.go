@@ -0,0 +1,296 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/goodsfile"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+// fakeJobsPostgres holds one job and guards its writes by the attempt that
+// claimed it, as the jobs queries do.
+type fakeJobsPostgres struct {
+	fakeOutboxPostgres
+
+	mu     sync.Mutex
+	job    models.Job
+	chunks map[int][][]byte
+	goods  []models.Good
+}
+
+func newFakeJobsPostgres(job models.Job) *fakeJobsPostgres {
+	job.Status = models.JobQueued
+	return &fakeJobsPostgres{job: job, chunks: map[int][][]byte{}}
+}
+
+// claim is ClaimJob of a queued or stale job.
+func (f *fakeJobsPostgres) claim() models.Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.job.Status = models.JobRunning
+	f.job.Attempts++
+	return f.job
+}
+
+func (f *fakeJobsPostgres) holds(jobID int64, attempt int) bool {
+	return f.job.ID == jobID && f.job.Status == models.JobRunning && f.job.Attempts == attempt
+}
+
+func (f *fakeJobsPostgres) HeartbeatJob(_ context.Context, jobID int64, attempt, _, _ int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.holds(jobID, attempt) {
+		return false, custerrors.ErrLeaseLost
+	}
+	return false, nil
+}
+
+func (f *fakeJobsPostgres) AppendJobResult(_ context.Context, jobID int64, attempt, seq int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.holds(jobID, attempt) {
+		return custerrors.ErrLeaseLost
+	}
+	if seq != len(f.chunks[attempt]) {
+		panic("chunk out of sequence")
+	}
+	f.chunks[attempt] = append(f.chunks[attempt], bytes.Clone(data))
+	return nil
+}
+
+func (f *fakeJobsPostgres) FinishJob(_ context.Context, jobID int64, attempt int, status string, progress, total int, resultType, errMsg string) (models.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.holds(jobID, attempt) {
+		return models.Job{}, custerrors.ErrLeaseLost
+	}
+	f.job.Status, f.job.Progress, f.job.Total = status, progress, total
+	f.job.ResultType, f.job.Error = resultType, errMsg
+	for a := range f.chunks {
+		if a != attempt || status != models.JobSucceeded {
+			delete(f.chunks, a)
+		}
+	}
+	return f.job, nil
+}
+
+func (f *fakeJobsPostgres) RequeueJob(_ context.Context, jobID int64, attempt int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.holds(jobID, attempt) {
+		f.job.Status = models.JobQueued
+		f.job.Attempts--
+		delete(f.chunks, attempt)
+	}
+	return nil
+}
+
+func (f *fakeJobsPostgres) result() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return bytes.Join(f.chunks[f.job.Attempts], nil)
+}
+
+func (f *fakeJobsPostgres) ExportGoods(ctx context.Context, _ int, _ *bool, fn func(models.Good) error) error {
+	for _, good := range f.goods {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(good); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeJobsPostgres) PurgeGoods(context.Context, int) ([]models.Good, error) {
+	purged := f.goods
+	f.goods = nil
+	return purged, nil
+}
+
+type fakeJobsRedis struct {
+	fakeApplyRedis
+}
+
+func (fakeJobsRedis) Delete(context.Context, int) error {
+	return nil
+}
+
+type fakeJobsQueue struct {
+	fakeQueue
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (f *fakeJobsQueue) PublishJob(b []byte) error {
+	var l models.JobLog
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, l.Event)
+	return nil
+}
+
+func newJobsService(pg *fakeJobsPostgres, queue *fakeJobsQueue) *Service {
+	return &Service{
+		repoPostgres: pg,
+		repoRedis:    fakeJobsRedis{},
+		queueNats:    queue,
+		trManager:    fakeTransactor{},
+		publishLogs:  true,
+	}
+}
+
+var testJobsConfig = models.ConfigJobs{StaleAfter: time.Minute}
+
+func TestExportJobStoresItsResultInChunks(t *testing.T) {
+	pg := newFakeJobsPostgres(models.Job{ID: 1, Kind: models.JobExport, ProjectID: 1, Params: models.JobParams{Format: "csv"}})
+	for i := 1; i <= 300; i++ {
+		pg.goods = append(pg.goods, models.Good{ID: i, ProjectID: 1, Name: "a", Description: strings.Repeat("d", 1<<10), Priority: i})
+	}
+	queue := &fakeJobsQueue{}
+	s := newJobsService(pg, queue)
+
+	s.execute(context.Background(), testJobsConfig, pg.claim(), nil)
+
+	if pg.job.Status != models.JobSucceeded {
+		t.Fatalf("status = %s (%s), want succeeded", pg.job.Status, pg.job.Error)
+	}
+	if len(pg.chunks[1]) < 2 {
+		t.Errorf("result stored in %d chunks, want it split", len(pg.chunks[1]))
+	}
+
+	columns, _ := goodsfile.ParseColumns("")
+	var want bytes.Buffer
+	w := goodsfile.NewWriter(&want, "csv", columns)
+	for _, good := range pg.goods {
+		if err := w.Write(good); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !bytes.Equal(pg.result(), want.Bytes()) {
+		t.Errorf("result of %d bytes differs from the export of %d bytes", len(pg.result()), want.Len())
+	}
+}
+
+func TestJobClaimedAgainKeepsTheResultOfItsNewAttempt(t *testing.T) {
+	pg := newFakeJobsPostgres(models.Job{ID: 1, Kind: models.JobPurge, ProjectID: 1})
+	queue := &fakeJobsQueue{}
+	s := newJobsService(pg, queue)
+
+	// The first worker stalls past the lease and the job is claimed again.
+	first := pg.claim()
+	second := pg.claim()
+
+	s.execute(context.Background(), testJobsConfig, second, nil)
+	want := pg.result()
+	if pg.job.Status != models.JobSucceeded || len(want) == 0 {
+		t.Fatalf("status = %s with %d bytes, want succeeded with a report", pg.job.Status, len(want))
+	}
+
+	// The first worker comes back and finishes too.
+	s.execute(context.Background(), testJobsConfig, first, nil)
+	if pg.job.Attempts != 2 || !bytes.Equal(pg.result(), want) {
+		t.Errorf("attempt %d with result %q, want attempt 2 with %q", pg.job.Attempts, pg.result(), want)
+	}
+
+	succeeded := 0
+	for _, event := range queue.events {
+		if event == models.JobSucceeded {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("job logs = %v, want a single succeeded", queue.events)
+	}
+}
+
+func TestFinishJobLeavesAJobClaimedAgain(t *testing.T) {
+	pg := newFakeJobsPostgres(models.Job{ID: 1, Kind: models.JobPurge, ProjectID: 1})
+	queue := &fakeJobsQueue{}
+	s := newJobsService(pg, queue)
+
+	first := pg.claim()
+	pg.claim()
+
+	s.finishJob(context.Background(), first, models.JobFailed, &jobProgress{}, "", "gave up")
+	if pg.job.Status != models.JobRunning || pg.job.Error != "" {
+		t.Errorf("job = %s (%q), want it still running for its new worker", pg.job.Status, pg.job.Error)
+	}
+	if len(queue.events) != 0 {
+		t.Errorf("job logs = %v, want none for a lost lease", queue.events)
+	}
+}
+
+func TestPurgeJobLogsTheRemovalOfEveryGood(t *testing.T) {
+	pg := newFakeJobsPostgres(models.Job{ID: 1, Kind: models.JobPurge, ProjectID: 1})
+	pg.goods = []models.Good{
+		{ID: 1, ProjectID: 1, Name: "a", Priority: 1, Removed: true, Version: 2},
+		{ID: 2, ProjectID: 1, Name: "b", Priority: 2, Removed: true, Version: 3},
+	}
+	s := newJobsService(pg, &fakeJobsQueue{})
+
+	s.execute(context.Background(), testJobsConfig, pg.claim(), nil)
+	if pg.job.Status != models.JobSucceeded {
+		t.Fatalf("status = %s (%s), want succeeded", pg.job.Status, pg.job.Error)
+	}
+	if len(pg.outbox) != 2 || !pg.outboxInTx {
+		t.Fatalf("outbox = %d events, in transaction %t; want 2 written with the purge", len(pg.outbox), pg.outboxInTx)
+	}
+	for i, payload := range pg.outbox {
+		var l models.Log
+		if err := json.Unmarshal(payload, &l); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if l.Event != models.EventRemoved || l.ID != i+1 {
+			t.Errorf("log = %s of good %d, want removed of good %d", l.Event, l.ID, i+1)
+		}
+	}
+
+	var report models.PurgeReport
+	if err := json.Unmarshal(pg.result(), &report); err != nil || report.Purged != 2 {
+		t.Errorf("report = %s, want 2 purged", pg.result())
+	}
+}
+
+func TestRequeuedJobKeepsItsAttempts(t *testing.T) {
+	pg := newFakeJobsPostgres(models.Job{ID: 1, Kind: models.JobExport, ProjectID: 1, Params: models.JobParams{Format: "csv"}})
+	pg.goods = []models.Good{{ID: 1, ProjectID: 1, Name: "a", Priority: 1}}
+	s := newJobsService(pg, &fakeJobsQueue{})
+
+	// More graceful shutdowns than attempts, each after a part of the result.
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	for i := 0; i <= maxJobAttempts; i++ {
+		job := pg.claim()
+		if err := pg.AppendJobResult(context.Background(), job.ID, job.Attempts, 0, []byte("partial")); err != nil {
+			t.Fatalf("AppendJobResult: %v", err)
+		}
+		s.execute(stopped, testJobsConfig, job, nil)
+		if pg.job.Status != models.JobQueued || pg.job.Attempts != 0 {
+			t.Fatalf("job = %s after %d attempts, want queued without any", pg.job.Status, pg.job.Attempts)
+		}
+	}
+
+	s.execute(context.Background(), testJobsConfig, pg.claim(), nil)
+	if pg.job.Status != models.JobSucceeded {
+		t.Fatalf("status = %s (%s), want succeeded", pg.job.Status, pg.job.Error)
+	}
+	if bytes.Contains(pg.result(), []byte("partial")) {
+		t.Errorf("result = %q, want the partial result of the requeued attempts dropped", pg.result())
+	}
+}
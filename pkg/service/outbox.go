@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// outboxBatch is how many events a relay publishes per transaction.
+const outboxBatch = 100
+
+// logChanges writes the events of the goods to the outbox. It must run in the
+// transaction of the changes, so they commit or roll back together.
+func (s *Service) logChanges(ctx context.Context, event string, goods ...models.Good) error {
+	if !s.publishLogs || len(goods) == 0 {
+		return nil
+	}
+
+	occurredAt := time.Now().UTC()
+	payloads := make([][]byte, 0, len(goods))
+	for _, good := range goods {
+		b, err := json.Marshal(models.Log{
+			Event:      event,
+			Good:       good,
+			Version:    good.Version,
+			OccurredAt: occurredAt,
+		})
+		if err != nil {
+			return fmt.Errorf("error to marshal log: %v", err)
+		}
+		payloads = append(payloads, b)
+	}
+	return s.repoPostgres.CreateOutbox(ctx, payloads)
+}
+
+// reprioritized are the goods as their reprioritize events carry them: only
+// what the change touched.
+func reprioritized(goods []models.Good) []models.Good {
+	changes := make([]models.Good, 0, len(goods))
+	for _, good := range goods {
+		changes = append(changes, models.Good{
+			ID:        good.ID,
+			ProjectID: good.ProjectID,
+			Priority:  good.Priority,
+			Version:   good.Version,
+		})
+	}
+	return changes
+}
+
+// RelayOutbox publishes the committed events of the outbox to NATS until it
+// is empty.
+func (s *Service) RelayOutbox(ctx context.Context) (int, error) {
+	var total int
+	for {
+		relayed, err := s.repoPostgres.RelayOutbox(ctx, outboxBatch, s.queueNats.Publish)
+		total += relayed
+		if err != nil {
+			return total, fmt.Errorf("error to relay outbox: %v", err)
+		}
+		if relayed < outboxBatch {
+			return total, nil
+		}
+	}
+}
+
+// RunOutboxRelay relays the outbox every interval until ctx is done. Events
+// wait in the outbox while NATS is unreachable.
+func (s *Service) RunOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.RelayOutbox(ctx); err != nil {
+				log.Errorf("error to relay outbox: %v", err)
+			}
+		}
+	}
+}
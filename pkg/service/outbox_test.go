@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+type txKey struct{}
+
+// fakeTransactor marks the ctx passed to fn as in a transaction.
+type fakeTransactor struct{}
+
+func (fakeTransactor) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, txKey{}, true))
+}
+
+func inTx(ctx context.Context) bool {
+	tx, _ := ctx.Value(txKey{}).(bool)
+	return tx
+}
+
+type fakeOutboxPostgres struct {
+	repositoryPostgres
+
+	outbox     [][]byte
+	outboxInTx bool
+	outboxErr  error
+}
+
+func (f *fakeOutboxPostgres) CreateGood(_ context.Context, projectID int, name string) (models.Good, error) {
+	return models.Good{ID: 1, ProjectID: projectID, Name: name, Priority: 1, Version: 1}, nil
+}
+
+func (f *fakeOutboxPostgres) AddCounters(context.Context, int, int, int) error {
+	return nil
+}
+
+func (f *fakeOutboxPostgres) CreateOutbox(ctx context.Context, payloads [][]byte) error {
+	if f.outboxErr != nil {
+		return f.outboxErr
+	}
+	f.outbox = append(f.outbox, payloads...)
+	f.outboxInTx = inTx(ctx)
+	return nil
+}
+
+func (f *fakeOutboxPostgres) RelayOutbox(_ context.Context, limit int, publish func(payload []byte) error) (int, error) {
+	var relayed int
+	for len(f.outbox) > 0 && relayed < limit {
+		if err := publish(f.outbox[0]); err != nil {
+			return relayed, err
+		}
+		f.outbox = f.outbox[1:]
+		relayed++
+	}
+	return relayed, nil
+}
+
+type fakeApplyRedis struct {
+	repositoryRedis
+}
+
+func (fakeApplyRedis) Apply(context.Context, models.Good, int, int) error {
+	return nil
+}
+
+type fakeQueue struct {
+	queueNats
+
+	published [][]byte
+	err       error
+}
+
+func (f *fakeQueue) Publish(b []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.published = append(f.published, b)
+	return nil
+}
+
+func newOutboxService(pg *fakeOutboxPostgres, queue *fakeQueue) *Service {
+	return &Service{
+		repoPostgres: pg,
+		repoRedis:    fakeApplyRedis{},
+		queueNats:    queue,
+		trManager:    fakeTransactor{},
+		publishLogs:  true,
+	}
+}
+
+func TestCreateGoodWritesItsEventInTheTransaction(t *testing.T) {
+	pg := &fakeOutboxPostgres{}
+	s := newOutboxService(pg, &fakeQueue{})
+
+	if _, err := s.CreateGood(context.Background(), 1, "a"); err != nil {
+		t.Fatalf("CreateGood: %v", err)
+	}
+	if len(pg.outbox) != 1 || !pg.outboxInTx {
+		t.Fatalf("outbox = %d events, in transaction %t; want 1 written in the transaction", len(pg.outbox), pg.outboxInTx)
+	}
+
+	var l models.Log
+	if err := json.Unmarshal(pg.outbox[0], &l); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if l.Event != models.EventCreated || l.ID != 1 || l.Version != 1 || l.OccurredAt.IsZero() {
+		t.Errorf("log = %+v, want the created event of good 1 at version 1 with its time", l)
+	}
+}
+
+func TestCreateGoodFailsWithoutItsEvent(t *testing.T) {
+	pg := &fakeOutboxPostgres{outboxErr: errors.New("outbox is down")}
+	s := newOutboxService(pg, &fakeQueue{})
+
+	if _, err := s.CreateGood(context.Background(), 1, "a"); err == nil {
+		t.Fatal("CreateGood succeeded without writing its event")
+	}
+}
+
+func TestRelayOutboxKeepsEventsWhilePublishingFails(t *testing.T) {
+	pg := &fakeOutboxPostgres{}
+	queue := &fakeQueue{err: errors.New("nats is down")}
+	s := newOutboxService(pg, queue)
+
+	for i := 0; i < outboxBatch+1; i++ {
+		if _, err := s.CreateGood(context.Background(), 1, "a"); err != nil {
+			t.Fatalf("CreateGood: %v", err)
+		}
+	}
+
+	if _, err := s.RelayOutbox(context.Background()); err == nil {
+		t.Fatal("RelayOutbox succeeded while NATS is down")
+	}
+	if len(pg.outbox) != outboxBatch+1 {
+		t.Fatalf("outbox = %d events, want all %d kept", len(pg.outbox), outboxBatch+1)
+	}
+
+	queue.err = nil
+	relayed, err := s.RelayOutbox(context.Background())
+	if err != nil {
+		t.Fatalf("RelayOutbox: %v", err)
+	}
+	if relayed != outboxBatch+1 || len(queue.published) != outboxBatch+1 || len(pg.outbox) != 0 {
+		t.Errorf("relayed %d, published %d, left %d; want every event relayed in batches", relayed, len(queue.published), len(pg.outbox))
+	}
+}
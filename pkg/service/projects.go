@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+// Projects, like ProjectsGoods and the history methods, takes many ids at once
+// so batched readers cost one query however many projects they need.
+func (s *Service) Projects(ctx context.Context, projectIDs []int) ([]models.ProjectSummary, error) {
+	projects, err := s.repoPostgres.Projects(ctx, projectIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error to get projects: %v", err)
+	}
+	return projects, nil
+}
+
+func (s *Service) ProjectsGoods(ctx context.Context, projectIDs []int, limit int, removed *bool) ([]models.Good, error) {
+	goods, err := s.repoPostgres.ProjectsGoods(ctx, projectIDs, limit, removed)
+	if err != nil {
+		return nil, fmt.Errorf("error to get projects goods: %v", err)
+	}
+	return goods, nil
+}
+
+func (s *Service) ProjectsHistory(ctx context.Context, projectIDs []int, limit int) ([]models.Log, error) {
+	logs, err := s.repoClickhouse.ProjectsHistory(ctx, projectIDs, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error to get projects history: %v", err)
+	}
+	return logs, nil
+}
+
+func (s *Service) GoodsHistory(ctx context.Context, goodIDs []int, limit int) ([]models.Log, error) {
+	logs, err := s.repoClickhouse.GoodsHistory(ctx, goodIDs, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error to get goods history: %v", err)
+	}
+	return logs, nil
+}
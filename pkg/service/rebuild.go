@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+type rebuildPostgres interface {
+	Goods(ctx context.Context) ([]models.Good, error)
+	Projects(ctx context.Context, projectIDs []int) ([]models.ProjectSummary, error)
+	RestoreGoods(ctx context.Context, projects []models.Project, goods []models.Good, prune bool, keep []int) error
+}
+
+type rebuildRedis interface {
+	Delete(ctx context.Context, projectID int) error
+}
+
+type rebuildClickhouse interface {
+	GoodsState(ctx context.Context, asOf time.Time) ([]models.Good, error)
+	ProjectsState(ctx context.Context, asOf time.Time) ([]models.Project, error)
+}
+
+// Rebuilder replays the ClickHouse change log into Postgres. It is kept apart
+// from Service so the rebuild command does not subscribe to the log queue.
+type Rebuilder struct {
+	repoPostgres   rebuildPostgres
+	repoClickhouse rebuildClickhouse
+	repoRedis      rebuildRedis
+}
+
+func NewRebuilder(repoPostgres rebuildPostgres, repoClickhouse rebuildClickhouse, repoRedis rebuildRedis) *Rebuilder {
+	return &Rebuilder{
+		repoPostgres:   repoPostgres,
+		repoClickhouse: repoClickhouse,
+		repoRedis:      repoRedis,
+	}
+}
+
+func (r *Rebuilder) Rebuild(ctx context.Context, asOf time.Time, dryRun, prune bool) (models.RebuildReport, error) {
+	logged, err := r.repoClickhouse.GoodsState(ctx, asOf)
+	if err != nil {
+		return models.RebuildReport{}, fmt.Errorf("error to replay logs: %v", err)
+	}
+
+	projects, err := r.repoClickhouse.ProjectsState(ctx, asOf)
+	if err != nil {
+		return models.RebuildReport{}, fmt.Errorf("error to replay project logs: %v", err)
+	}
+
+	rebuilt, skipped, err := r.restorable(ctx, logged, projects)
+	if err != nil {
+		return models.RebuildReport{}, err
+	}
+
+	current, err := r.repoPostgres.Goods(ctx)
+	if err != nil {
+		return models.RebuildReport{}, fmt.Errorf("error to get goods: %v", err)
+	}
+
+	// Skipped goods are in the log: they are neither missing nor extra.
+	keep := make([]int, 0, len(skipped))
+	skippedIDs := make(map[int]struct{}, len(skipped))
+	for _, skip := range skipped {
+		keep = append(keep, skip.ID)
+		skippedIDs[skip.ID] = struct{}{}
+	}
+	compared := make([]models.Good, 0, len(current))
+	for _, good := range current {
+		if _, ok := skippedIDs[good.ID]; !ok {
+			compared = append(compared, good)
+		}
+	}
+
+	report := models.RebuildReport{
+		AsOf:     asOf,
+		DryRun:   dryRun,
+		Pruned:   prune && !dryRun,
+		Projects: len(projects),
+		Goods:    len(rebuilt),
+		Diffs:    diffGoods(compared, rebuilt),
+		Skipped:  skipped,
+	}
+	for _, d := range report.Diffs {
+		switch d.Kind {
+		case models.DiffMissing:
+			report.Missing++
+		case models.DiffExtra:
+			report.Extra++
+		case models.DiffChanged:
+			report.Changed++
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	// Keep the original creation time of goods that still exist: the log only
+	// knows when a good was first seen by the queue.
+	createdAt := make(map[int]time.Time, len(current))
+	for _, good := range current {
+		createdAt[good.ID] = good.CreatedAt
+	}
+	for i := range rebuilt {
+		if t, ok := createdAt[rebuilt[i].ID]; ok {
+			rebuilt[i].CreatedAt = t
+		}
+	}
+
+	if err = r.repoPostgres.RestoreGoods(ctx, projects, rebuilt, prune, keep); err != nil {
+		return models.RebuildReport{}, fmt.Errorf("error to restore goods: %v", err)
+	}
+
+	// The restore logs no events, so the cached indexes of every project it
+	// may have written are dropped here.
+	restored := map[int]struct{}{}
+	for _, good := range current {
+		restored[good.ProjectID] = struct{}{}
+	}
+	for _, good := range rebuilt {
+		restored[good.ProjectID] = struct{}{}
+	}
+	for projectID := range restored {
+		if err = r.repoRedis.Delete(ctx, projectID); err != nil {
+			return models.RebuildReport{}, fmt.Errorf("goods restored, error to drop the cached goods of project %d: %v", projectID, err)
+		}
+	}
+	return report, nil
+}
+
+// restorable splits the goods of the log into those that can be written back
+// and those that can't: a good needs its name, which only some events carry,
+// and a project that is in the log or still in Postgres.
+func (r *Rebuilder) restorable(ctx context.Context, logged []models.Good, projects []models.Project) ([]models.Good, []models.SkippedGood, error) {
+	knownProjects := make(map[int]struct{}, len(projects))
+	for _, project := range projects {
+		projectID, err := strconv.Atoi(project.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error to parse project id %q: %v", project.ID, err)
+		}
+		knownProjects[projectID] = struct{}{}
+	}
+
+	var unknownProjects []int
+	for _, good := range logged {
+		if _, ok := knownProjects[good.ProjectID]; !ok {
+			unknownProjects = append(unknownProjects, good.ProjectID)
+		}
+	}
+	if len(unknownProjects) > 0 {
+		existing, err := r.repoPostgres.Projects(ctx, unknownProjects)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error to get projects: %v", err)
+		}
+		for _, project := range existing {
+			knownProjects[project.ID] = struct{}{}
+		}
+	}
+
+	rebuilt := make([]models.Good, 0, len(logged))
+	skipped := []models.SkippedGood{}
+	for _, good := range logged {
+		switch _, ok := knownProjects[good.ProjectID]; {
+		case good.Name == "":
+			skipped = append(skipped, models.SkippedGood{ID: good.ID, ProjectID: good.ProjectID, Reason: models.SkipNoName})
+		case !ok:
+			skipped = append(skipped, models.SkippedGood{ID: good.ID, ProjectID: good.ProjectID, Reason: models.SkipNoProject})
+		default:
+			rebuilt = append(rebuilt, good)
+		}
+	}
+	return rebuilt, skipped, nil
+}
+
+func diffGoods(current, rebuilt []models.Good) []models.GoodDiff {
+	currentByID := make(map[int]models.Good, len(current))
+	for _, good := range current {
+		currentByID[good.ID] = good
+	}
+
+	var diffs = []models.GoodDiff{}
+	for i := range rebuilt {
+		good := rebuilt[i]
+		cur, ok := currentByID[good.ID]
+		if !ok {
+			diffs = append(diffs, models.GoodDiff{ID: good.ID, Kind: models.DiffMissing, Rebuilt: &good})
+			continue
+		}
+		delete(currentByID, good.ID)
+
+		var fields []string
+		if cur.ProjectID != good.ProjectID {
+			fields = append(fields, "project")
+		}
+		if cur.Name != good.Name {
+			fields = append(fields, "name")
+		}
+		if cur.Description != good.Description {
+			fields = append(fields, "description")
+		}
+		if cur.Priority != good.Priority {
+			fields = append(fields, "priority")
+		}
+		if cur.Removed != good.Removed {
+			fields = append(fields, "removed")
+		}
+		if fields != nil {
+			diffs = append(diffs, models.GoodDiff{ID: good.ID, Kind: models.DiffChanged, Fields: fields, Current: &cur, Rebuilt: &good})
+		}
+	}
+
+	for i := range current {
+		if good, ok := currentByID[current[i].ID]; ok {
+			diffs = append(diffs, models.GoodDiff{ID: good.ID, Kind: models.DiffExtra, Current: &good})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+type fakeRebuildPostgres struct {
+	goods    []models.Good
+	projects []models.ProjectSummary
+
+	restoredProjects []models.Project
+	restoredGoods    []models.Good
+	kept             []int
+}
+
+func (f *fakeRebuildPostgres) Goods(context.Context) ([]models.Good, error) {
+	return f.goods, nil
+}
+
+func (f *fakeRebuildPostgres) Projects(_ context.Context, projectIDs []int) ([]models.ProjectSummary, error) {
+	var projects []models.ProjectSummary
+	for _, project := range f.projects {
+		for _, id := range projectIDs {
+			if project.ID == id {
+				projects = append(projects, project)
+				break
+			}
+		}
+	}
+	return projects, nil
+}
+
+func (f *fakeRebuildPostgres) RestoreGoods(_ context.Context, projects []models.Project, goods []models.Good, _ bool, keep []int) error {
+	f.restoredProjects, f.restoredGoods, f.kept = projects, goods, keep
+	return nil
+}
+
+type fakeRebuildClickhouse struct {
+	goods    []models.Good
+	projects []models.Project
+}
+
+func (f fakeRebuildClickhouse) GoodsState(context.Context, time.Time) ([]models.Good, error) {
+	return f.goods, nil
+}
+
+func (f fakeRebuildClickhouse) ProjectsState(context.Context, time.Time) ([]models.Project, error) {
+	return f.projects, nil
+}
+
+type fakeRebuildRedis struct {
+	deleted []int
+}
+
+func (f *fakeRebuildRedis) Delete(_ context.Context, projectID int) error {
+	f.deleted = append(f.deleted, projectID)
+	return nil
+}
+
+func TestRebuildSkipsGoodsThatCantBeRestored(t *testing.T) {
+	pg := &fakeRebuildPostgres{
+		goods: []models.Good{
+			{ID: 3, ProjectID: 1, Name: "only reprioritized", Priority: 3},
+		},
+		projects: []models.ProjectSummary{{ID: 2, Name: "Project B"}},
+	}
+	ch := fakeRebuildClickhouse{
+		goods: []models.Good{
+			{ID: 1, ProjectID: 1, Name: "from the log", Priority: 1},
+			{ID: 2, ProjectID: 2, Name: "project still in postgres", Priority: 2},
+			{ID: 3, ProjectID: 1, Priority: 4},
+			{ID: 4, ProjectID: 9, Name: "unknown project", Priority: 5},
+		},
+		projects: []models.Project{{ID: "1", Name: "Project A"}},
+	}
+
+	rdb := &fakeRebuildRedis{}
+	report, err := NewRebuilder(pg, ch, rdb).Rebuild(context.Background(), time.Now(), false, true)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+
+	wantSkipped := []models.SkippedGood{
+		{ID: 3, ProjectID: 1, Reason: models.SkipNoName},
+		{ID: 4, ProjectID: 9, Reason: models.SkipNoProject},
+	}
+	if !reflect.DeepEqual(report.Skipped, wantSkipped) {
+		t.Errorf("skipped = %+v, want %+v", report.Skipped, wantSkipped)
+	}
+	if report.Projects != 1 || report.Goods != 2 || report.Missing != 2 || report.Extra != 0 {
+		t.Errorf("report = %+v, want 1 project, 2 goods, 2 missing and none extra", report)
+	}
+
+	if !reflect.DeepEqual(pg.restoredProjects, ch.projects) {
+		t.Errorf("restored projects = %+v, want the ones of the log", pg.restoredProjects)
+	}
+	for _, good := range pg.restoredGoods {
+		if good.Name == "" {
+			t.Errorf("good %d restored without a name", good.ID)
+		}
+	}
+	if len(pg.restoredGoods) != 2 {
+		t.Errorf("restored %d goods, want 2", len(pg.restoredGoods))
+	}
+	// The skipped goods are in the log, pruning must not delete them.
+	if !reflect.DeepEqual(pg.kept, []int{3, 4}) {
+		t.Errorf("kept = %v, want [3 4]", pg.kept)
+	}
+
+	// The cached goods of the restored projects are dropped.
+	slices.Sort(rdb.deleted)
+	if !slices.Equal(rdb.deleted, []int{1, 2}) {
+		t.Errorf("deleted the cache of projects %v, want [1 2]", rdb.deleted)
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+func (s *Service) SearchGoods(ctx context.Context, projectID int, query string, limit, offset int) (models.SearchResponse, error) {
+	searchResponse, err := s.repoPostgres.SearchGoods(ctx, projectID, query, limit, offset)
+	if err != nil {
+		return models.SearchResponse{}, fmt.Errorf("error to search goods: %v", err)
+	}
+	return searchResponse, nil
+}
+
+func (s *Service) SetSearchLanguage(ctx context.Context, projectID int, language string) error {
+	if err := s.repoPostgres.SetSearchLanguage(ctx, projectID, language); err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) || errors.Is(err, custerrors.ErrInvalid) {
+			return err
+		}
+		return fmt.Errorf("error to set search language: %v", err)
+	}
+	return nil
+}
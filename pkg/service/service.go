@@ -2,45 +2,114 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"time"
 
 	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/metrics"
 	"github.com/Hymiside/hezzl-api/pkg/models"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	lockWaitRetries = 20
+	lockWaitDelay   = 50 * time.Millisecond
 )
 
 type repositoryPostgres interface{
-	Goods(ctx context.Context) ([]models.Good, error)
-	GoodsWithLimitAndOffset(ctx context.Context, limit, offset int) (models.GoodsResponse, error)
+	GoodsWithLimitAndOffset(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error)
+	ProjectGoods(ctx context.Context, projectID int) ([]models.Good, error)
+	Good(ctx context.Context, goodID, projectID int) (models.Good, error)
 	CreateGood(ctx context.Context, projectID int, name string) (models.Good, error)
 	UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error)
 	DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error)
-	ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.ReprioritizeGoodResponse, error)
+	ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.Good, error)
+	SearchGoods(ctx context.Context, projectID int, query string, limit, offset int) (models.SearchResponse, error)
+	SetSearchLanguage(ctx context.Context, projectID int, language string) error
+	ExportGoods(ctx context.Context, projectID int, removed *bool, fn func(models.Good) error) error
+	UpsertGoods(ctx context.Context, projectID int, rows []models.ImportRow) ([]models.UpsertedGood, error)
+	AddCounters(ctx context.Context, projectID, total, removed int) error
+	ReconcileCounters(ctx context.Context) ([]models.CounterDrift, error)
+	PurgeGoods(ctx context.Context, projectID int) ([]models.Good, error)
+	CreateOutbox(ctx context.Context, payloads [][]byte) error
+	RelayOutbox(ctx context.Context, limit int, publish func(payload []byte) error) (int, error)
+	CreateJob(ctx context.Context, kind string, projectID int, params models.JobParams, input []byte) (models.Job, error)
+	Job(ctx context.Context, jobID int64, projectID int) (models.Job, error)
+	ClaimJob(ctx context.Context, staleAfter time.Duration) (models.Job, []byte, bool, error)
+	HeartbeatJob(ctx context.Context, jobID int64, attempt, progress, total int) (bool, error)
+	AppendJobResult(ctx context.Context, jobID int64, attempt, seq int, data []byte) error
+	FinishJob(ctx context.Context, jobID int64, attempt int, status string, progress, total int, resultType, errMsg string) (models.Job, error)
+	RequeueJob(ctx context.Context, jobID int64, attempt int) error
+	CancelJob(ctx context.Context, jobID int64, projectID int) (models.Job, bool, error)
+	JobResult(ctx context.Context, jobID int64, projectID int) (models.JobResult, error)
+	WriteJobResult(ctx context.Context, jobID int64, projectID int, w io.Writer) error
+	CreateWebhook(ctx context.Context, projectID int, url, secret string, events []string) (models.Webhook, error)
+	Webhooks(ctx context.Context, projectID int) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, webhookID, projectID int) error
+	Deliveries(ctx context.Context, projectID int, status string, limit, offset int) ([]models.WebhookDelivery, error)
+	RedeliverDelivery(ctx context.Context, deliveryID int64, projectID int) (models.WebhookDelivery, error)
+	Projects(ctx context.Context, projectIDs []int) ([]models.ProjectSummary, error)
+	ProjectsGoods(ctx context.Context, projectIDs []int, limit int, removed *bool) ([]models.Good, error)
 }
 
 type repositoryRedis interface{
-	Set(ctx context.Context, goods []byte) error
-	Get(ctx context.Context, limit, offset int) (models.GoodsResponse, error)
-	Delete(ctx context.Context) error
+	Goods(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error)
+	Ready(ctx context.Context, projectID int) (bool, error)
+	Epoch(ctx context.Context, projectID int) (int64, error)
+	Build(ctx context.Context, projectID int, epoch int64, goods []models.Good) error
+	Apply(ctx context.Context, good models.Good, totalDelta, removedDelta int) error
+	Lock(ctx context.Context, projectID int) (string, error)
+	Unlock(ctx context.Context, projectID int, token string) error
+	Delete(ctx context.Context, projectID int) error
+}
+
+type repositoryClickhouse interface{
+	GoodsAsOf(ctx context.Context, projectID, limit, offset int, asOf time.Time) (models.GoodsResponse, error)
+	GoodAsOf(ctx context.Context, goodID, projectID int, asOf time.Time) (models.Good, error)
+	Activity(ctx context.Context, projectID int, bucket string, from, to time.Time) ([]models.ActivityBucket, error)
+	TopEditedGoods(ctx context.Context, projectID, limit int, from, to time.Time) ([]models.EditedGood, error)
+	ProjectsHistory(ctx context.Context, projectIDs []int, limit int) ([]models.Log, error)
+	GoodsHistory(ctx context.Context, goodIDs []int, limit int) ([]models.Log, error)
+}
+
+// transactor runs fn in one transaction that every repository call made with
+// the ctx passed to fn joins.
+type transactor interface {
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 type queueNats interface{
 	Subscribe() error
 	Publish(b []byte) error
+	PublishJob(b []byte) error
 }
 
 type Service struct {
 	repoPostgres   repositoryPostgres
 	repoRedis      repositoryRedis
+	repoClickhouse repositoryClickhouse
 	queueNats      queueNats
+	trManager      transactor
+
+	// loads deduplicates concurrent index builds of the same project within this instance.
+	loads singleflight.Group
+
+	// publishLogs is off when change data capture publishes the events instead
+	// of the outbox.
+	publishLogs bool
 }
 
 func NewService(
 	repoPostgres repositoryPostgres,
 	repoRedis repositoryRedis,
+	repoClickhouse repositoryClickhouse,
 	queueNats queueNats,
+	trManager transactor,
+	publishLogs bool,
 ) *Service {
 
 	if err := queueNats.Subscribe(); err != nil {
@@ -50,116 +119,208 @@ func NewService(
 	return &Service{
 		repoPostgres:   repoPostgres,
 		repoRedis:      repoRedis,
+		repoClickhouse: repoClickhouse,
 		queueNats:      queueNats,
+		trManager:      trManager,
+		publishLogs:    publishLogs,
 	}
 }
 
-func (s *Service) cacheGoods(ctx context.Context) {
-	goods, err := s.repoPostgres.Goods(ctx)
-	if err != nil {
-		log.Errorf("error to get goods: %v", err)
+// writeThrough patches the cached indexes after a committed change. When a
+// good can't be patched its project is invalidated instead.
+func (s *Service) writeThrough(ctx context.Context, totalDelta, removedDelta int, goods ...models.Good) {
+	for _, good := range goods {
+		if err := s.repoRedis.Apply(ctx, good, totalDelta, removedDelta); err != nil {
+			log.Errorf("error to apply good: %v", err)
+			if err = s.repoRedis.Delete(ctx, good.ProjectID); err != nil {
+				log.Errorf("error to delete redis: %v", err)
+			}
+		}
 	}
+}
 
-	bytes, err := json.Marshal(goods)
-	if err != nil {
-		log.Errorf("error to marshal goods: %v", err)
+func (s *Service) Goods(ctx context.Context, projectID, limit, offset int) (models.GoodsResponse, error) {
+	responseGoods, err := s.repoRedis.Goods(ctx, projectID, limit, offset)
+	switch {
+	case err == nil:
+		metrics.GoodsCache.WithLabelValues("hit").Inc()
+		return responseGoods, nil
+	case errors.Is(err, custerrors.ErrNotFound):
+		metrics.GoodsCache.WithLabelValues("miss").Inc()
+	default:
+		metrics.GoodsCache.WithLabelValues("error").Inc()
 	}
-	
-	if err := s.repoRedis.Set(ctx, bytes); err != nil {
-		log.Errorf("error to set goods: %v", err)
+
+	if !errors.Is(err, custerrors.ErrNotFound) {
+		log.Warnf("error to get goods from redis, reading postgres: %v", err)
+	} else if _, err, _ = s.loads.Do(strconv.Itoa(projectID), func() (interface{}, error) {
+		return nil, s.buildIndex(context.WithoutCancel(ctx), projectID)
+	}); err != nil {
+		log.Errorf("error to build goods index: %v", err)
+	} else if responseGoods, err = s.repoRedis.Goods(ctx, projectID, limit, offset); err == nil {
+		return responseGoods, nil
 	}
+
+	responseGoods, err = s.repoPostgres.GoodsWithLimitAndOffset(ctx, projectID, limit, offset)
+	if err != nil {
+		return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
+	}
+	return responseGoods, nil
 }
 
-func (s *Service) Goods(ctx context.Context, limit, offset int) (models.GoodsResponse, error) {
-	responseGoods, err := s.repoRedis.Get(ctx, limit, offset)
+// buildIndex loads a project into the cache. The Redis lock makes sure only one
+// instance hits Postgres for it; the others wait for the index to become ready.
+func (s *Service) buildIndex(ctx context.Context, projectID int) error {
+	token, err := s.repoRedis.Lock(ctx, projectID)
 	if err != nil {
-		if !errors.Is(err, custerrors.ErrNotFound) {
-			return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
-		}
+		return err
+	}
 
-		responseGoods, err = s.repoPostgres.GoodsWithLimitAndOffset(ctx, limit, offset)
-		if err != nil {
-			return models.GoodsResponse{}, fmt.Errorf("error to get goods: %v", err)
+	if token == "" {
+		for i := 0; i < lockWaitRetries; i++ {
+			time.Sleep(lockWaitDelay)
+			if ready, err := s.repoRedis.Ready(ctx, projectID); err != nil || ready {
+				return err
+			}
 		}
-		
-		go s.cacheGoods(context.Background())
+		return nil
 	}
-	
-	return responseGoods, nil
-}
+	defer func() {
+		if err := s.repoRedis.Unlock(ctx, projectID, token); err != nil {
+			log.Errorf("error to unlock goods: %v", err)
+		}
+	}()
 
-func (s *Service) CreateGood(ctx context.Context, projectID int, name string) (models.Good, error) {
-	createdGood, err := s.repoPostgres.CreateGood(ctx, projectID, name)
+	epoch, err := s.repoRedis.Epoch(ctx, projectID)
 	if err != nil {
-		return models.Good{}, fmt.Errorf("error to create good: %v", err)
+		return err
 	}
 
-	bytes, err := json.Marshal(createdGood)
+	goods, err := s.repoPostgres.ProjectGoods(ctx, projectID)
 	if err != nil {
-		log.Errorf("error to marshal good: %v", err)
+		return err
 	}
 
-	if err = s.queueNats.Publish(bytes); err != nil {
-		log.Errorf("error to publish good: %v", err)
+	// A write raced the load; the next miss builds the index again.
+	if err = s.repoRedis.Build(ctx, projectID, epoch, goods); err != nil && !errors.Is(err, custerrors.ErrStale) {
+		return err
 	}
+	return nil
+}
 
-	if err = s.repoRedis.Delete(ctx); err != nil {
-		log.Errorf("error to delete redis: %v", err)
+func (s *Service) GoodsAsOf(ctx context.Context, projectID, limit, offset int, asOf time.Time) (models.GoodsResponse, error) {
+	responseGoods, err := s.repoClickhouse.GoodsAsOf(ctx, projectID, limit, offset, asOf)
+	if err != nil {
+		return models.GoodsResponse{}, fmt.Errorf("error to get goods as of %s: %v", asOf.Format(time.RFC3339), err)
 	}
-	return createdGood, nil
+	return responseGoods, nil
 }
 
-func (s *Service) UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error) {
-	updatedGood, err := s.repoPostgres.UpdateGood(ctx, good, goodID, projectID)
+func (s *Service) Good(ctx context.Context, goodID, projectID int) (models.GoodResponse, error) {
+	good, err := s.repoPostgres.Good(ctx, goodID, projectID)
 	if err != nil {
 		if errors.Is(err, custerrors.ErrNotFound) {
-			return models.Good{}, err
+			return models.GoodResponse{}, err
 		}
-		return models.Good{}, fmt.Errorf("error to update good: %v", err)
+		return models.GoodResponse{}, fmt.Errorf("error to get good: %v", err)
 	}
+	return models.GoodResponse{Good: good}, nil
+}
 
-	bytes, err := json.Marshal(updatedGood)
+func (s *Service) GoodAsOf(ctx context.Context, goodID, projectID int, asOf time.Time) (models.GoodResponse, error) {
+	good, err := s.repoClickhouse.GoodAsOf(ctx, goodID, projectID, asOf)
 	if err != nil {
-		log.Errorf("error to marshal good: %v", err)
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.GoodResponse{}, err
+		}
+		return models.GoodResponse{}, fmt.Errorf("error to get good as of %s: %v", asOf.Format(time.RFC3339), err)
 	}
 
-	if err = s.queueNats.Publish(bytes); err != nil {
-		log.Errorf("error to publish good: %v", err)
-	}
+	return models.GoodResponse{
+		Meta: models.GoodMeta{Historical: true, AsOf: &asOf},
+		Good: good,
+	}, nil
+}
 
-	if err = s.repoRedis.Delete(ctx); err != nil {
-		log.Errorf("error to delete redis: %v", err)
+func (s *Service) CreateGood(ctx context.Context, projectID int, name string) (models.Good, error) {
+	var createdGood models.Good
+	err := s.trManager.Do(ctx, func(ctx context.Context) (err error) {
+		if createdGood, err = s.repoPostgres.CreateGood(ctx, projectID, name); err != nil {
+			return err
+		}
+		if err = s.repoPostgres.AddCounters(ctx, projectID, 1, 0); err != nil {
+			return err
+		}
+		return s.logChanges(ctx, models.EventCreated, createdGood)
+	})
+	if err != nil {
+		return models.Good{}, fmt.Errorf("error to create good: %v", err)
 	}
 
-	return updatedGood, nil
+	s.writeThrough(ctx, 1, 0, createdGood)
+	return createdGood, nil
 }
 
-func (s *Service) DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error) {
-	deletedGood, err := s.repoPostgres.DeleteGood(ctx, goodID, projectID)
+func (s *Service) UpdateGood(ctx context.Context, good models.Good, goodID, projectID int) (models.Good, error) {
+	var updatedGood models.Good
+	err := s.trManager.Do(ctx, func(ctx context.Context) (err error) {
+		if updatedGood, err = s.repoPostgres.UpdateGood(ctx, good, goodID, projectID); err != nil {
+			return err
+		}
+		if updatedGood.Removed {
+			if err = s.repoPostgres.AddCounters(ctx, projectID, 0, 1); err != nil {
+				return err
+			}
+		}
+		return s.logChanges(ctx, models.EventUpdated, updatedGood)
+	})
 	if err != nil {
 		if errors.Is(err, custerrors.ErrNotFound) {
 			return models.Good{}, err
 		}
-		return models.Good{}, fmt.Errorf("error to delete good: %v", err)
+		return models.Good{}, fmt.Errorf("error to update good: %v", err)
 	}
 
-	bytes, err := json.Marshal(deletedGood)
-	if err != nil {
-		log.Errorf("error to marshal good: %v", err)
+	// Only goods that were not removed can be updated, so a removed one was just removed.
+	var removedDelta int
+	if updatedGood.Removed {
+		removedDelta = 1
 	}
+	s.writeThrough(ctx, 0, removedDelta, updatedGood)
 
-	if err = s.queueNats.Publish(bytes); err != nil {
-		log.Errorf("error to publish good: %v", err)
-	}
+	return updatedGood, nil
+}
 
-	if err = s.repoRedis.Delete(ctx); err != nil {
-		log.Errorf("error to delete redis: %v", err)
+func (s *Service) DeleteGood(ctx context.Context, goodID, projectID int) (models.Good, error) {
+	var deletedGood models.Good
+	err := s.trManager.Do(ctx, func(ctx context.Context) (err error) {
+		if deletedGood, err = s.repoPostgres.DeleteGood(ctx, goodID, projectID); err != nil {
+			return err
+		}
+		if err = s.repoPostgres.AddCounters(ctx, projectID, 0, 1); err != nil {
+			return err
+		}
+		return s.logChanges(ctx, models.EventRemoved, deletedGood)
+	})
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.Good{}, err
+		}
+		return models.Good{}, fmt.Errorf("error to delete good: %v", err)
 	}
+
+	s.writeThrough(ctx, 0, 1, deletedGood)
 	return deletedGood, nil
 }
 
 func (s *Service) ReprioritizeGood(ctx context.Context, goodID, projectID, priority int) ([]models.ReprioritizeGoodResponse, error) {
-	reprioritizedGoods, err := s.repoPostgres.ReprioritizeGood(ctx, goodID, projectID, priority)
+	var reprioritizedGoods []models.Good
+	err := s.trManager.Do(ctx, func(ctx context.Context) (err error) {
+		if reprioritizedGoods, err = s.repoPostgres.ReprioritizeGood(ctx, goodID, projectID, priority); err != nil {
+			return err
+		}
+		return s.logChanges(ctx, models.EventReprioritized, reprioritized(reprioritizedGoods)...)
+	})
 	if err != nil {
 		if errors.Is(err, custerrors.ErrNotFound) {
 			return nil, err
@@ -167,18 +328,31 @@ func (s *Service) ReprioritizeGood(ctx context.Context, goodID, projectID, prior
 		return nil, fmt.Errorf("error to reprioritize good: %v", err)
 	}
 
-	bytes, err := json.Marshal(reprioritizedGoods)
-	if err != nil {
-		log.Errorf("error to marshal goods: %v", err)
+	priorities := make([]models.ReprioritizeGoodResponse, 0, len(reprioritizedGoods))
+	for _, reprioritizedGood := range reprioritizedGoods {
+		priorities = append(priorities, models.ReprioritizeGoodResponse{
+			ID:       strconv.Itoa(reprioritizedGood.ID),
+			Priority: reprioritizedGood.Priority,
+		})
 	}
 
-	if err = s.queueNats.Publish(bytes); err != nil {
-		log.Errorf("error to publish good: %v", err)
+	s.writeThrough(ctx, 0, 0, reprioritizedGoods...)
+
+	return priorities, nil
+}
+
+func (s *Service) Activity(ctx context.Context, projectID int, bucket string, from, to time.Time) ([]models.ActivityBucket, error) {
+	activity, err := s.repoClickhouse.Activity(ctx, projectID, bucket, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error to get activity: %v", err)
 	}
+	return activity, nil
+}
 
-	if err = s.repoRedis.Delete(ctx); err != nil {
-		log.Errorf("error to delete redis: %v", err)
+func (s *Service) TopEditedGoods(ctx context.Context, projectID, limit int, from, to time.Time) ([]models.EditedGood, error) {
+	goods, err := s.repoClickhouse.TopEditedGoods(ctx, projectID, limit, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error to get top edited goods: %v", err)
 	}
-	
-	return reprioritizedGoods, nil
-}
\ No newline at end of file
+	return goods, nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Hymiside/hezzl-api/pkg/custerrors"
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+func (s *Service) CreateWebhook(ctx context.Context, projectID int, request models.WebhookRequest) (models.Webhook, error) {
+	webhook, err := s.repoPostgres.CreateWebhook(ctx, projectID, request.URL, request.Secret, request.Events)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.Webhook{}, err
+		}
+		return models.Webhook{}, fmt.Errorf("error to create webhook: %v", err)
+	}
+	return webhook, nil
+}
+
+func (s *Service) Webhooks(ctx context.Context, projectID int) ([]models.Webhook, error) {
+	webhooks, err := s.repoPostgres.Webhooks(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error to get webhooks: %v", err)
+	}
+	return webhooks, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, webhookID, projectID int) error {
+	if err := s.repoPostgres.DeleteWebhook(ctx, webhookID, projectID); err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("error to delete webhook: %v", err)
+	}
+	return nil
+}
+
+func (s *Service) WebhookDeliveries(ctx context.Context, projectID int, status string, limit, offset int) ([]models.WebhookDelivery, error) {
+	deliveries, err := s.repoPostgres.Deliveries(ctx, projectID, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error to get webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues a failed delivery again, it is sent by the next
+// free worker.
+func (s *Service) RedeliverWebhook(ctx context.Context, deliveryID int64, projectID int) (models.WebhookDelivery, error) {
+	delivery, err := s.repoPostgres.RedeliverDelivery(ctx, deliveryID, projectID)
+	if err != nil {
+		if errors.Is(err, custerrors.ErrNotFound) {
+			return models.WebhookDelivery{}, err
+		}
+		return models.WebhookDelivery{}, fmt.Errorf("error to redeliver webhook: %v", err)
+	}
+	return delivery, nil
+}
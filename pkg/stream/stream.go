@@ -0,0 +1,144 @@
+// Package stream fans the goods change events out to the clients streaming
+// them.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Subscription receives the events of one project, or of every project for 0.
+// Overflow is closed when the client fell too far behind: the subscription is
+// dropped and the client has to resume from the last event it got.
+type Subscription struct {
+	projectID int
+	Events    chan models.StreamEvent
+	Overflow  chan struct{}
+}
+
+func (s *Subscription) ProjectID() int {
+	return s.projectID
+}
+
+// Hub numbers the events and keeps the latest ones, so a client that
+// reconnects gets what it missed. Ids are "<boot>-<seq>": after a restart, or
+// from another instance, an id can't be resumed and the client is told to
+// reload instead.
+type Hub struct {
+	cfg  models.ConfigStream
+	boot string
+
+	mu      sync.Mutex
+	seq     uint64
+	history []models.StreamEvent
+	subs    map[*Subscription]struct{}
+}
+
+func NewHub(cfg models.ConfigStream) *Hub {
+	return &Hub{
+		cfg:     cfg,
+		boot:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		history: make([]models.StreamEvent, 0, cfg.History),
+		subs:    map[*Subscription]struct{}{},
+	}
+}
+
+// Publish takes a goods event as published on NATS.
+func (h *Hub) Publish(b []byte) {
+	var event models.Log
+	if err := json.Unmarshal(b, &event); err != nil {
+		log.Errorf("error to unmarshal stream event: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := models.StreamEvent{ID: h.id(h.seq), Event: event.Event, Good: event.Good}
+	if len(h.history) == h.cfg.History && len(h.history) > 0 {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	if h.cfg.History > 0 {
+		h.history = append(h.history, e)
+	}
+
+	for sub := range h.subs {
+		if sub.projectID != 0 && sub.projectID != e.Good.ProjectID {
+			continue
+		}
+		select {
+		case sub.Events <- e:
+		default:
+			// A slow client must not hold up the others: it is dropped and
+			// resumes from history once it catches up.
+			close(sub.Overflow)
+			delete(h.subs, sub)
+		}
+	}
+}
+
+// Subscribe registers a client. With a lastEventID it also returns the events
+// of the project published since then; the flag is false when they are no
+// longer all known, then the client must reload its state.
+func (h *Hub) Subscribe(projectID int, lastEventID string) (*Subscription, []models.StreamEvent, bool) {
+	sub := &Subscription{
+		projectID: projectID,
+		Events:    make(chan models.StreamEvent, h.cfg.Buffer),
+		Overflow:  make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subs[sub] = struct{}{}
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+
+	seq, ok := h.parseID(lastEventID)
+	if !ok || seq > h.seq {
+		return sub, nil, false
+	}
+	// The history is complete if it still holds the event after the last one seen.
+	complete := seq == h.seq || (len(h.history) > 0 && h.seqOf(h.history[0]) <= seq+1)
+
+	var missed []models.StreamEvent
+	for _, e := range h.history {
+		if h.seqOf(e) > seq && (projectID == 0 || e.Good.ProjectID == projectID) {
+			missed = append(missed, e)
+		}
+	}
+	return sub, missed, complete
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+func (h *Hub) id(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.boot, seq)
+}
+
+func (h *Hub) parseID(id string) (uint64, bool) {
+	boot, seq, ok := strings.Cut(id, "-")
+	if !ok || boot != h.boot {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+func (h *Hub) seqOf(e models.StreamEvent) uint64 {
+	seq, _ := h.parseID(e.ID)
+	return seq
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+func publish(t *testing.T, h *Hub, goodID, projectID int) {
+	t.Helper()
+	b, err := json.Marshal(models.Log{Event: models.EventUpdated, Good: models.Good{ID: goodID, ProjectID: projectID}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	h.Publish(b)
+}
+
+func goodIDs(events []models.StreamEvent) []int {
+	ids := []int{}
+	for _, e := range events {
+		ids = append(ids, e.Good.ID)
+	}
+	return ids
+}
+
+func TestSlowSubscriberOverflowsAlone(t *testing.T) {
+	h := NewHub(models.ConfigStream{History: 10, Buffer: 1})
+	slow, _, _ := h.Subscribe(0, "")
+	fast, _, _ := h.Subscribe(0, "")
+
+	publish(t, h, 1, 1)
+	<-fast.Events
+	publish(t, h, 2, 1)
+
+	select {
+	case <-slow.Overflow:
+	default:
+		t.Fatal("the slow subscriber did not overflow")
+	}
+	select {
+	case <-fast.Overflow:
+		t.Fatal("the subscriber that kept up overflowed")
+	default:
+	}
+	if e := <-fast.Events; e.Good.ID != 2 {
+		t.Errorf("fast got good %d, want 2", e.Good.ID)
+	}
+
+	// The dropped subscriber is not written to anymore.
+	publish(t, h, 3, 1)
+	if len(slow.Events) != 1 {
+		t.Errorf("slow has %d events buffered, want only the first", len(slow.Events))
+	}
+}
+
+func TestResumeAfterOverflow(t *testing.T) {
+	h := NewHub(models.ConfigStream{History: 10, Buffer: 1})
+	sub, _, _ := h.Subscribe(1, "")
+
+	publish(t, h, 1, 1)
+	publish(t, h, 2, 2)
+	publish(t, h, 3, 1)
+	publish(t, h, 4, 1)
+	<-sub.Overflow
+	last := <-sub.Events
+
+	_, missed, complete := h.Subscribe(1, last.ID)
+	if !complete {
+		t.Fatal("resume reported the history incomplete")
+	}
+	if ids := goodIDs(missed); !slices.Equal(ids, []int{3, 4}) {
+		t.Errorf("missed = %v, want [3 4] of project 1", ids)
+	}
+}
+
+func TestResumeBeyondTheHistory(t *testing.T) {
+	h := NewHub(models.ConfigStream{History: 2, Buffer: 10})
+	sub, _, _ := h.Subscribe(0, "")
+
+	publish(t, h, 1, 1)
+	first := <-sub.Events
+	publish(t, h, 2, 1)
+	second := <-sub.Events
+	publish(t, h, 3, 1)
+	publish(t, h, 4, 1)
+
+	if _, _, complete := h.Subscribe(0, first.ID); complete {
+		t.Error("resume from an event that left the history reported it complete")
+	}
+	_, missed, complete := h.Subscribe(0, second.ID)
+	if !complete || !slices.Equal(goodIDs(missed), []int{3, 4}) {
+		t.Errorf("resume after the last event out of the history = %v, %t; want [3 4], complete", goodIDs(missed), complete)
+	}
+}
+
+func TestResumeFromAnotherBoot(t *testing.T) {
+	h := NewHub(models.ConfigStream{History: 10, Buffer: 10})
+	publish(t, h, 1, 1)
+
+	for _, id := range []string{"other-1", "garbage", h.id(5)} {
+		if _, missed, complete := h.Subscribe(0, id); complete || len(missed) > 0 {
+			t.Errorf("resume from %q = %d events, complete %t; want a reload", id, len(missed), complete)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package webhook delivers the goods events to the webhooks subscribed to
+// them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	// batchSize is how many deliveries a worker claims at once.
+	batchSize = 10
+)
+
+type repository interface {
+	CreateDeliveries(ctx context.Context, projectID int, event string, payload []byte) (int, error)
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.DueDelivery, error)
+	FinishDelivery(ctx context.Context, deliveryID int64, status string, responseStatus int, errMsg string, retryAt time.Time) error
+}
+
+type subscriber interface {
+	SubscribeEvents(group string, fn func(b []byte)) error
+}
+
+// Sign computes the signature of a delivery: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed by the webhook secret, prefixed by "sha256=".
+// Receivers recompute it from the X-Webhook-Timestamp header and the raw body,
+// and should reject old timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher turns the events published on NATS into deliveries and POSTs
+// them, retrying failed ones with an exponential backoff.
+type Dispatcher struct {
+	repo       repository
+	subscriber subscriber
+	client     *http.Client
+	cfg        models.ConfigWebhooks
+}
+
+// NewDispatcher creates the dispatcher. client may be nil, then one with
+// cfg.Timeout is used that does not follow redirects and, unless
+// cfg.AllowPrivate, refuses to connect to loopback and private addresses.
+func NewDispatcher(repo repository, subscriber subscriber, client *http.Client, cfg models.ConfigWebhooks) *Dispatcher {
+	if client == nil {
+		client = newClient(cfg)
+	}
+	return &Dispatcher{
+		repo:       repo,
+		subscriber: subscriber,
+		client:     client,
+		cfg:        cfg,
+	}
+}
+
+func newClient(cfg models.ConfigWebhooks) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		// Checked on the address dialed, so names resolving to internal
+		// addresses are refused too.
+		dialer.Control = refusePrivate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		// A redirect could lead to an address the URL was not checked for.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func refusePrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return fmt.Errorf("address %s is not public", ip)
+	}
+	return nil
+}
+
+// Run subscribes to the events and delivers them until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	// Instances share a queue group, so each event is queued once.
+	if err := d.subscriber.SubscribeEvents("webhooks", func(b []byte) {
+		if err := d.enqueue(ctx, b); err != nil {
+			log.Errorf("error to enqueue webhook deliveries: %v", err)
+		}
+	}); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < d.cfg.Workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			d.work(ctx)
+		}()
+	}
+	for i := 0; i < d.cfg.Workers; i++ {
+		<-done
+	}
+	return nil
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, b []byte) error {
+	var event models.Log
+	if err := json.Unmarshal(b, &event); err != nil {
+		return fmt.Errorf("error to unmarshal: %v", err)
+	}
+
+	// Events logged before they carried their time are stamped on arrival.
+	occurredAt := event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now().UTC()
+	}
+	payload, err := json.Marshal(models.WebhookEvent{
+		Event:      event.Event,
+		Good:       event.Good,
+		OccurredAt: occurredAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error to marshal webhook event: %v", err)
+	}
+
+	_, err = d.repo.CreateDeliveries(ctx, event.ProjectID, event.Event, payload)
+	return err
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		// A claimed delivery is not retried before the attempt had its time.
+		deliveries, err := d.repo.ClaimDeliveries(ctx, batchSize, 2*d.cfg.Timeout)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("error to claim webhook deliveries: %v", err)
+		}
+		for _, delivery := range deliveries {
+			d.deliver(ctx, delivery)
+		}
+		if len(deliveries) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.cfg.PollInterval):
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.DueDelivery) {
+	responseStatus, err := d.Send(ctx, delivery)
+
+	var (
+		status  = models.DeliverySucceeded
+		retryAt = time.Now()
+		errMsg  string
+	)
+	if err != nil {
+		errMsg = err.Error()
+		status = models.DeliveryPending
+		retryAt = retryAt.Add(d.backoff(delivery.Attempts))
+		if delivery.Attempts >= d.cfg.MaxAttempts {
+			status = models.DeliveryFailed
+		}
+		log.Warnf("webhook delivery %d to %s failed (attempt %d/%d): %v",
+			delivery.ID, delivery.URL, delivery.Attempts, d.cfg.MaxAttempts, err)
+	}
+
+	if err = d.repo.FinishDelivery(context.WithoutCancel(ctx), delivery.ID, status, responseStatus, errMsg, retryAt); err != nil {
+		log.Errorf("error to finish webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// Send POSTs the delivery once. Any answer but 2xx is an error.
+func (d *Dispatcher) Send(ctx context.Context, delivery models.DueDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("error to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error to send webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.cfg.Backoff
+	for i := 1; i < attempts && backoff < d.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, d.cfg.MaxBackoff)
+}
@@ -0,0 +1,246 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hymiside/hezzl-api/pkg/models"
+)
+
+type finished struct {
+	status         string
+	responseStatus int
+	retryAt        time.Time
+}
+
+// fakeRepository hands out each due delivery to a single claim, as SKIP
+// LOCKED does.
+type fakeRepository struct {
+	mu       sync.Mutex
+	due      []models.DueDelivery
+	payloads [][]byte
+	finished map[int64]finished
+}
+
+func (f *fakeRepository) CreateDeliveries(_ context.Context, _ int, _ string, payload []byte) (int, error) {
+	f.payloads = append(f.payloads, payload)
+	return 1, nil
+}
+
+func (f *fakeRepository) ClaimDeliveries(_ context.Context, limit int, _ time.Duration) ([]models.DueDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := min(limit, len(f.due))
+	claimed := f.due[:n]
+	f.due = f.due[n:]
+	return claimed, nil
+}
+
+func (f *fakeRepository) FinishDelivery(_ context.Context, deliveryID int64, status string, responseStatus int, _ string, retryAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.finished == nil {
+		f.finished = map[int64]finished{}
+	}
+	f.finished[deliveryID] = finished{status: status, responseStatus: responseStatus, retryAt: retryAt}
+	return nil
+}
+
+var testConfig = models.ConfigWebhooks{
+	Workers:      3,
+	PollInterval: time.Millisecond,
+	Timeout:      time.Second,
+	MaxAttempts:  3,
+	Backoff:      time.Minute,
+	MaxBackoff:   time.Hour,
+	AllowPrivate: true,
+}
+
+func dueDelivery(id int64, url string, attempts int) models.DueDelivery {
+	return models.DueDelivery{
+		WebhookDelivery: models.WebhookDelivery{
+			ID:       id,
+			Event:    models.EventCreated,
+			Payload:  json.RawMessage(`{"event":"created","good":{"id":1}}`),
+			Attempts: attempts,
+		},
+		URL:    url,
+		Secret: "secret",
+	}
+}
+
+func TestSendSignsTheBody(t *testing.T) {
+	var (
+		body   []byte
+		header http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	delivery := dueDelivery(1, server.URL, 1)
+	if _, err := NewDispatcher(&fakeRepository{}, nil, nil, testConfig).Send(context.Background(), delivery); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if string(body) != string(delivery.Payload) {
+		t.Fatalf("body = %s, want the payload %s", body, delivery.Payload)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(header.Get(HeaderTimestamp) + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get(HeaderSignature) != want {
+		t.Errorf("signature = %s, want %s", header.Get(HeaderSignature), want)
+	}
+	if header.Get(HeaderDelivery) != "1" || header.Get(HeaderEvent) != models.EventCreated {
+		t.Errorf("delivery %q of event %q, want 1 of created", header.Get(HeaderDelivery), header.Get(HeaderEvent))
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	var followed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	status, err := NewDispatcher(&fakeRepository{}, nil, nil, testConfig).Send(context.Background(), dueDelivery(1, server.URL, 1))
+	if err == nil || status != http.StatusTemporaryRedirect || followed {
+		t.Errorf("Send = %d, %v, followed %t; want the redirect as a failure", status, err, followed)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	var posted bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer server.Close()
+
+	cfg := testConfig
+	cfg.AllowPrivate = false
+	if _, err := NewDispatcher(&fakeRepository{}, nil, nil, cfg).Send(context.Background(), dueDelivery(1, server.URL, 1)); err == nil || posted {
+		t.Errorf("Send = %v, posted %t; want a loopback address refused", err, posted)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	repo := &fakeRepository{}
+	d := NewDispatcher(repo, nil, nil, testConfig)
+
+	for attempts, backoff := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute} {
+		before := time.Now()
+		d.deliver(context.Background(), dueDelivery(int64(attempts), server.URL, attempts))
+
+		got := repo.finished[int64(attempts)]
+		if got.status != models.DeliveryPending || got.responseStatus != http.StatusServiceUnavailable {
+			t.Errorf("attempt %d: %s with %d, want pending with 503", attempts, got.status, got.responseStatus)
+		}
+		if got.retryAt.Before(before.Add(backoff)) || got.retryAt.After(time.Now().Add(backoff)) {
+			t.Errorf("attempt %d: retry in %s, want %s", attempts, got.retryAt.Sub(before), backoff)
+		}
+	}
+}
+
+func TestDeliverFailsAfterTheLastAttempt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	repo := &fakeRepository{}
+	NewDispatcher(repo, nil, nil, testConfig).deliver(context.Background(), dueDelivery(1, server.URL, testConfig.MaxAttempts))
+
+	if got := repo.finished[1]; got.status != models.DeliveryFailed {
+		t.Errorf("status = %s, want failed after %d attempts", got.status, testConfig.MaxAttempts)
+	}
+}
+
+func TestWorkersDeliverEachClaimOnce(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		posts = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts[r.Header.Get(HeaderDelivery)]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	repo := &fakeRepository{}
+	for id := int64(1); id <= 25; id++ {
+		repo.due = append(repo.due, dueDelivery(id, server.URL, 1))
+	}
+	d := NewDispatcher(repo, nil, nil, testConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < testConfig.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx)
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		repo.mu.Lock()
+		done := len(repo.finished)
+		repo.mu.Unlock()
+		if done == 25 {
+			break
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	if len(repo.finished) != 25 || len(posts) != 25 {
+		t.Fatalf("finished %d and posted %d deliveries, want 25", len(repo.finished), len(posts))
+	}
+	for id, n := range posts {
+		if n != 1 {
+			t.Errorf("delivery %s posted %d times, want once", id, n)
+		}
+	}
+}
+
+func TestEnqueueKeepsTheTimeOfTheEvent(t *testing.T) {
+	occurredAt := time.Date(2024, 5, 1, 12, 0, 0, 123000, time.UTC)
+	b, _ := json.Marshal(models.Log{
+		Event:      models.EventUpdated,
+		Good:       models.Good{ID: 1, ProjectID: 1, Name: "a"},
+		OccurredAt: occurredAt,
+	})
+
+	repo := &fakeRepository{}
+	if err := NewDispatcher(repo, nil, nil, testConfig).enqueue(context.Background(), b); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	var event models.WebhookEvent
+	if err := json.Unmarshal(repo.payloads[0], &event); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !event.OccurredAt.Equal(occurredAt) {
+		t.Errorf("occurredAt = %s, want the time of the event %s", event.OccurredAt, occurredAt)
+	}
+}